@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// alertName is the name of the alert posted to Alertmanager when kamailio is down.
+const alertName = "KamailioDown"
+
+// alertNotifier posts an alert to Alertmanager when scrapes fail Threshold times in a row,
+// and resolves it when a scrape succeeds, for sites without a local Prometheus.
+type alertNotifier struct {
+	URL       string // of Alertmanager, e.g. "http://alertmanager:9093"
+	Threshold int
+
+	client   *http.Client
+	failures int
+	startsAt time.Time // of the alert, zero if it is not firing
+}
+
+// alert is an alert of the Alertmanager API v2.
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"` // nil while firing
+}
+
+// EnableAlerts makes c post an alert to the Alertmanager at url when threshold scrapes fail in a row.
+func (c *Collector) EnableAlerts(url string, threshold int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.alerts = &alertNotifier{
+		URL:       strings.TrimRight(url, "/"),
+		Threshold: threshold,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// notifyAlerts updates the alert with the outcome of a scrape. c.mutex must be held.
+func (c *Collector) notifyAlerts(err error) {
+	n := c.alerts
+
+	if n == nil {
+		return
+	}
+
+	labels := map[string]string{"alertname": alertName}
+
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+
+	labels["instance"] = c.instance()
+
+	if err == nil {
+		n.failures = 0
+
+		if !n.startsAt.IsZero() {
+			endsAt := time.Now()
+			go n.post(alert{Labels: labels, Annotations: map[string]string{}, StartsAt: n.startsAt, EndsAt: &endsAt})
+			n.startsAt = time.Time{}
+		}
+
+		return
+	}
+
+	if n.failures++; n.failures < n.Threshold {
+		return
+	}
+
+	if n.startsAt.IsZero() {
+		n.startsAt = time.Now()
+	}
+
+	// like Prometheus, firing alerts are sent again on every evaluation, so that they do not expire
+	go n.post(alert{
+		Labels: labels,
+		Annotations: map[string]string{
+			"summary":     fmt.Sprintf("kamailio is down: %d scrapes failed in a row", n.failures),
+			"description": err.Error(),
+		},
+		StartsAt: n.startsAt,
+	})
+}
+
+// instance returns the name of the target in notifications: its name, or its first scrape URI.
+// c.mutex must be held.
+func (c *Collector) instance() string {
+	if c.TargetName == "" && len(c.urls) > 0 {
+		return c.urls[0].String()
+	}
+
+	return c.TargetName
+}
+
+// post sends a to Alertmanager.
+func (n *alertNotifier) post(a alert) {
+	body, err := json.Marshal([]alert{a})
+
+	if err != nil {
+		log.Println("[error] cannot encode alert:", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL+"/api/v2/alerts", bytes.NewReader(body))
+
+	if err != nil {
+		log.Println("[error] cannot post alert:", err)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+
+	if err != nil {
+		log.Println("[error] cannot post alert:", err)
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[error] cannot post alert: Alertmanager replied %s", resp.Status)
+	}
+}
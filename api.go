@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// rpcRequest is the body of a request to /api/v1/rpc.
+type rpcRequest struct {
+	Method string `json:"method"`
+	Params []any  `json:"params"`
+}
+
+// writeJSON writes v as JSON with status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeJSONError writes an error as JSON with status code.
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// rpcHandler returns a handler that calls a method of allowlist with parameters, and returns the
+// decoded records as JSON. Every call is logged for auditing.
+//
+// Parameters are passed as strings or integers (or doubles for JSON numbers with decimals), like kamcmd:
+//
+//	curl -X POST -d '{"method": "dispatcher.set_state", "params": ["ip", 1, "sip:10.0.0.1:5060"]}' http://localhost:9494/api/v1/rpc
+func rpcHandler(c *Collector, allowlist []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("only POST requests allowed"))
+			return
+		}
+
+		var request rpcRequest
+
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+
+		if err := decoder.Decode(&request); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+
+		allowed := false
+
+		for _, m := range allowlist {
+			if m == request.Method {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			log.Printf("[audit] rpc from %s denied: %s %v", r.RemoteAddr, request.Method, request.Params)
+			writeJSONError(w, http.StatusForbidden, fmt.Errorf(`method "%s" is not allowed`, request.Method))
+			return
+		}
+
+		params := make([]any, 0, len(request.Params))
+
+		for _, param := range request.Params {
+			switch param := param.(type) {
+			case string:
+				params = append(params, param)
+			case json.Number:
+				if i, err := param.Int64(); err == nil {
+					params = append(params, int(i))
+				} else if f, err := param.Float64(); err == nil {
+					params = append(params, f)
+				}
+			default:
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid parameter %v: only strings and numbers are supported", param))
+				return
+			}
+		}
+
+		log.Printf("[audit] rpc from %s: %s %v", r.RemoteAddr, request.Method, request.Params)
+
+		records, err := c.Call(request.Method, params...)
+
+		if err != nil {
+			writeJSONError(w, http.StatusBadGateway, err)
+			return
+		}
+
+		values := make([]any, 0, len(records))
+
+		for _, record := range records {
+			values = append(values, recordToJSON(record))
+		}
+
+		writeJSON(w, http.StatusOK, map[string]any{"records": values})
+	})
+}
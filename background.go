@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// background holds the metrics collected in the background.
+// It has its own mutex so that serving metrics does not wait for a collection in progress.
+type background struct {
+	mutex   sync.Mutex
+	enabled bool
+	started bool
+	metrics map[string][]prometheus.Metric // per method
+	times   map[string]time.Time           // of the collection of each method
+
+	// samples carry the time of their collection if set
+	timestamps bool
+
+	// the metrics are kept after a failed collection during the grace period (see grace.go)
+	grace time.Duration
+	at    time.Time // of the last successful collection
+	stale bool
+
+	targetInfo prometheus.Metric // exported if Collector.TargetInfo is "metric"
+}
+
+// interval returns the collection interval of method.
+func (c *Collector) interval(method string) time.Duration {
+	if interval, found := c.MethodIntervals[method]; found {
+		return interval
+	}
+
+	return c.Interval
+}
+
+// Start starts the background collection if c.Interval is set.
+func (c *Collector) Start() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.startBackground()
+}
+
+// startBackground enables or disables background collection according to c.Interval,
+// starting the collection goroutine if needed. c.mutex must be held.
+func (c *Collector) startBackground() {
+	c.bg.mutex.Lock()
+	defer c.bg.mutex.Unlock()
+
+	c.bg.enabled = c.Interval > 0
+	c.bg.grace = c.GracePeriod
+	c.bg.timestamps = c.Timestamps
+
+	// forget methods that are no longer configured
+	for method := range c.bg.metrics {
+		found := false
+
+		for _, m := range c.Methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			delete(c.bg.metrics, method)
+			delete(c.bg.times, method)
+		}
+	}
+
+	if c.bg.enabled && !c.bg.started {
+		c.bg.started = true
+		go c.runBackground()
+	}
+}
+
+// runBackground calls each method when its interval is elapsed.
+// Methods due at the same time share a connection.
+func (c *Collector) runBackground() {
+	next := make(map[string]time.Time)
+
+	for {
+		c.mutex.Lock()
+
+		now := time.Now()
+		// wake up regularly to take reloaded intervals into account
+		wake := now.Add(time.Second)
+
+		if c.Interval > 0 {
+			var due []string
+
+			for _, method := range c.Methods {
+				if !next[method].After(now) {
+					due = append(due, method)
+					next[method] = now.Add(c.interval(method))
+				}
+
+				if next[method].Before(wake) {
+					wake = next[method]
+				}
+			}
+
+			if len(due) > 0 {
+				for _, method := range c.collectBackground(due) {
+					next[method] = now.Add(c.Interval)
+				}
+			}
+		}
+
+		c.mutex.Unlock()
+
+		time.Sleep(time.Until(wake))
+	}
+}
+
+// collectBackground calls methods and stores the results.
+// It returns the methods that must be retried at the base interval. c.mutex must be held.
+func (c *Collector) collectBackground(methods []string) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	collected := make(map[string][]prometheus.Metric)
+
+	skipped, failed, err := c.scrape(ctx, methods, func(method string, metric prometheus.Metric) {
+		collected[method] = append(collected[method], metric)
+	})
+
+	c.bg.mutex.Lock()
+	defer c.bg.mutex.Unlock()
+
+	if err != nil {
+		c.scrapeFailed(err)
+
+		// kamailio is considered down: drop everything after the grace period, and collect every method on the next cycle
+		c.bg.stale = withinGrace(c.bg.at, c.GracePeriod)
+
+		if !c.bg.stale {
+			c.bg.metrics = nil
+			c.bg.times = nil
+			c.bg.targetInfo = nil
+		}
+
+		return c.Methods
+	}
+
+	c.scrapeSucceeded()
+	c.bg.at = time.Now()
+	c.bg.stale = false
+
+	c.bg.targetInfo = nil
+	if c.TargetInfo == targetInfoMetric {
+		c.bg.targetInfo = c.targetInfo
+	}
+
+	if c.bg.metrics == nil {
+		c.bg.metrics = make(map[string][]prometheus.Metric)
+		c.bg.times = make(map[string]time.Time)
+	}
+
+	// skipped methods keep their values until the next cycle, while the values of failed methods are dropped
+	pending := make(map[string]bool, len(skipped)+len(failed))
+
+	for _, method := range skipped {
+		pending[method] = true
+	}
+
+	for _, method := range failed {
+		pending[method] = true
+		delete(c.bg.metrics, method)
+		delete(c.bg.times, method)
+	}
+
+	for _, method := range methods {
+		if !pending[method] {
+			c.bg.metrics[method] = collected[method]
+			c.bg.times[method] = c.bg.at
+		}
+	}
+
+	return skipped
+}
+
+// collectCached sends the metrics collected in the background to ch.
+// It returns false if background collection is disabled.
+func (c *Collector) collectCached(ch chan<- prometheus.Metric) bool {
+	c.bg.mutex.Lock()
+	defer c.bg.mutex.Unlock()
+
+	if !c.bg.enabled {
+		return false
+	}
+
+	// the grace period may end between two collections
+	if c.bg.stale && !withinGrace(c.bg.at, c.bg.grace) {
+		c.bg.metrics = nil
+		c.bg.times = nil
+		c.bg.targetInfo = nil
+		c.bg.stale = false
+	}
+
+	for method, metrics := range c.bg.metrics {
+		for _, metric := range metrics {
+			if c.bg.timestamps {
+				metric = prometheus.NewMetricWithTimestamp(c.bg.times[method], metric)
+			}
+
+			ch <- metric
+		}
+	}
+
+	if c.bg.targetInfo != nil {
+		ch <- c.bg.targetInfo
+	}
+
+	ch <- c.up
+	c.collectMaintenance(ch)
+	c.collectStale(ch, c.bg.grace, c.bg.stale)
+	ch <- c.totalScrapes
+	ch <- c.failedScrapes
+	c.failedByType.Collect(ch)
+	c.lastError.Collect(ch)
+	ch <- c.dnsErrors
+	c.methodsSkipped.Collect(ch)
+	c.methodUp.Collect(ch)
+	c.activeURI.Collect(ch)
+	c.lastSuccess.Collect(ch)
+
+	return true
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> cr.dump_routes
+{
+	carrier: default
+	domain: {
+		name: proxy
+		rule: {
+			prefix: 49
+			max_targets: 2
+			prob: 0.500000
+			rewrite_hostpart: gw1.example.com
+			status: 1
+			hash_index: 1
+		}
+		rule: {
+			prefix: 49
+			max_targets: 2
+			prob: 0.500000
+			rewrite_hostpart: gw2.example.com
+			status: 1
+			hash_index: 2
+		}
+	}
+}
+*/
+
+// carrierRouteKey identifies a routing tree of the carrierroute module.
+type carrierRouteKey struct {
+	Carrier string
+	Domain  string
+}
+
+// scrapeCarrierRoutes passes the number of routes (distinct prefixes) and targets of each routing tree
+// of the carrierroute module, by carrier and domain, to fn. A tree coming up empty after a reload
+// shows as a count of 0.
+func (c *Collector) scrapeCarrierRoutes(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	var (
+		order    []carrierRouteKey // of the trees, as returned
+		routes   = make(map[carrierRouteKey]map[string]bool)
+		targets  = make(map[carrierRouteKey]int)
+		carrier  string
+		domain   string
+		prefixes = make(map[string]bool)
+		count    int
+	)
+
+	err := c.streamBINRPC(ctx, "cr.dump_routes", func(d *rpcDecoder) error {
+		return walkRecords(d, "cr.dump_routes", func(path []string, key string, record binrpc.Record) error {
+			switch {
+			case len(path) == 0 && key == "carrier":
+				carrier, _ = record.String()
+			case len(path) == 1 && path[0] == "domain" && key == "name":
+				domain, _ = record.String()
+			case len(path) > 1 && path[0] == "domain" && key == "prefix":
+				// prefixes may be strings or ints, depending on their digits
+				if s, err := record.String(); err == nil {
+					prefixes[s] = true
+				} else if i, err := record.Int(); err == nil {
+					prefixes[strconv.Itoa(i)] = true
+				}
+			case len(path) > 1 && path[0] == "domain" && key == "rewrite_hostpart":
+				count++
+			case len(path) == 0 && key == "domain" && record.Type == typeEnd:
+				k := carrierRouteKey{Carrier: carrier, Domain: domain}
+
+				if _, found := routes[k]; !found {
+					order = append(order, k)
+					routes[k] = make(map[string]bool)
+				}
+
+				for prefix := range prefixes {
+					routes[k][prefix] = true
+				}
+
+				targets[k] += count
+				domain, prefixes, count = "", make(map[string]bool), 0
+			case len(path) == 0 && key == "" && record.Type == typeEnd:
+				carrier = ""
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range order {
+		labels := map[string]string{"carrier": k.Carrier, "domain": k.Domain}
+
+		if err := fn("routes", MetricValue{Value: float64(len(routes[k])), Labels: labels}); err != nil {
+			return err
+		}
+
+		if err := fn("targets", MetricValue{Value: float64(targets[k]), Labels: labels}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
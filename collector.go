@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
 	"net/url"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
@@ -93,38 +97,180 @@ type Collector struct {
 	Timeout time.Duration
 	Methods []string
 
-	url   *url.URL
+	// background collection (see background.go), disabled if Interval is 0
+	Interval        time.Duration
+	MethodIntervals map[string]time.Duration // overrides Interval for some methods
+
+	// size of the cookie of requests, "fixed" or "compact" (see packet.go)
+	BINRPCCookie string
+
+	// if not 0, addresses of tcp:// URIs are cached for this duration
+	DNSTTL time.Duration
+
+	// export the version of kamailio and TargetName with target_info, or as labels of every metric (see info.go)
+	TargetInfo string
+	TargetName string
+
+	// dlg.list is skipped when there are more active dialogs, if not 0
+	DlgListMaxDialogs int
+
+	// dialog profiles of dlg.profile_get_size, with the values counted for profiles with values (see dlgprofile.go)
+	DialogProfiles map[string][]string
+
+	// if not nil, dlg.list also counts the active dialogs by a label extracted from their URIs
+	DialogLabel *DialogLabel
+
+	// statistics groups of stats.fetch, "all" for every statistic (see stats.go)
+	StatsGroups []string
+
+	// level of detail of mod.stats: "module", "function" or "line" (see modstats.go)
+	ModStatsLevel string
+
+	// mount point of the procfs of the host of kamailio, for core.psx
+	ProcfsPath string
+
+	// if not nil, makes the requests of http(s):// URIs instead of jsonrpcClient
+	HTTPClient *http.Client
+
+	// if not nil, filter the hash tables of htable.stats by name
+	HTableInclude *regexp.Regexp
+	HTableExclude *regexp.Regexp
+
+	// scrapes lasting longer are logged with the duration of each method, if not 0
+	SlowScrapeThreshold time.Duration
+
+	// write the requests of all methods before reading the responses (see pipeline.go)
+	Pipeline bool
+
+	// normalization steps of the URIs of dispatcher.list targets (see dispatcher.go)
+	DispatcherURINormalize []string
+
+	// domain.dump also exports an info series per domain
+	DomainInfo bool
+
+	// string values of the responses are exported by string_info metrics, instead of being dropped (see stringvalues.go)
+	StringValues bool
+
+	// constant labels added to every metric of kamailio, such as the datacenter or role of the target
+	Labels map[string]string
+
+	// if not 0, the last successful values are served again when scrapes fail during this period (see grace.go)
+	GracePeriod time.Duration
+
+	// keep the connection open between scrapes, checked when idle for KeepaliveInterval (see persistent.go)
+	Persistent        bool
+	KeepaliveInterval time.Duration
+
+	// in background mode, samples carry the time of their collection instead of the time of the scrape
+	Timestamps bool
+
+	urls  []*url.URL // URI may contain a list of fallback URIs
 	mutex sync.Mutex
 	conn  net.Conn
-
-	up            prometheus.Gauge
-	failedScrapes prometheus.Counter
-	totalScrapes  prometheus.Counter
+	dns   dnsCache
+
+	bg         background
+	persistent persistentConn
+	dialogs    dialogTracker // see dlg.go
+
+	descs map[string]*prometheus.Desc // cache of descriptions, by name and label keys
+
+	pipelined map[string][]binrpc.Record // responses read ahead by pipeline, during a scrape
+
+	health    targetHealth     // see targets.go
+	history   scrapeHistory    // see history.go
+	status    statusRecorder   // see status.go
+	alerts    *alertNotifier   // see alertmanager.go, nil if disabled
+	heartbeat *heartbeatPinger // see heartbeat.go, nil if disabled
+	webhooks  *webhookNotifier // see webhook.go, nil if disabled
+
+	maintenance     maintenanceState // see maintenance.go
+	maintenanceDesc *prometheus.Desc
+
+	lastGood  lastGood // see grace.go
+	staleDesc *prometheus.Desc
+
+	targetLabels prometheus.Labels
+	targetInfo   prometheus.Metric // nil if the last scrape failed
+
+	up             prometheus.Gauge
+	failedScrapes  prometheus.Counter // deprecated alias of the sum of failedByType
+	failedByType   *prometheus.CounterVec
+	lastError      *prometheus.GaugeVec
+	totalScrapes   prometheus.Counter
+	methodsSkipped *prometheus.CounterVec
+	methodUp       *prometheus.GaugeVec
+	dnsErrors      prometheus.Counter
+	activeURI      *prometheus.GaugeVec
+	lastSuccess    *prometheus.GaugeVec
 }
 
 // Metric is the definition of a metric.
 type Metric struct {
-	Kind   prometheus.ValueType
-	Name   string
-	Help   string
-	Method string // kamailio method associated with the metric
+	Kind    prometheus.ValueType
+	Name    string
+	Help    string
+	Method  string    // kamailio method associated with the metric
+	Buckets []float64 // for histograms only
+
+	// FullName, if set, is exported instead of the name derived from Method and Name, for the
+	// few metrics whose names do not follow the method, such as kamailio_htable_entries.
+	FullName string
 }
 
 // MetricValue is the value of a metric, with its labels.
+// For histograms, Value is the sum of the observations.
 type MetricValue struct {
 	Value  float64
 	Labels map[string]string
+
+	// histograms only
+	Count   uint64
+	Buckets map[float64]uint64 // cumulative counts by upper bound
 }
 
 // DispatcherTarget is a target of the dispatcher module.
 type DispatcherTarget struct {
-	URI   string
-	Flags string
-	SetID int
+	URI      string
+	Flags    string
+	SetID    int
+	Priority int
+	Weight   int // from the attributes, 0 if not set
+	Attrs    string
+	Latency  *DispatcherLatency // nil unless ds_ping_latency_stats is enabled
+}
+
+// DispatcherLatency are the latency statistics of the keepalives of a dispatcher target, in milliseconds.
+type DispatcherLatency struct {
+	Average  float64
+	Stdev    float64
+	Estimate float64
+	Max      float64
+	Timeouts int
+}
+
+// methodTiming is the duration of a method call, for slow scrape diagnostics.
+type methodTiming struct {
+	method   string
+	duration time.Duration
+}
+
+// RPCError is an error reply of kamailio to a method call.
+type RPCError struct {
+	Method  string
+	Code    int
+	Message string
 }
 
 const (
 	namespace = "kamailio"
+
+	// remaining methods are skipped when less than timeout/deadlineMarginDivisor
+	// (or the duration of the slowest method) is left before the scrape deadline
+	deadlineMarginDivisor = 10
+
+	// maximum delay between two connection attempts
+	maxBackoff = 5 * time.Second
 )
 
 var (
@@ -132,6 +278,11 @@ var (
 	// examples: "200" or "6xx" or even "xxx"
 	codeRegex = regexp.MustCompile("^[0-9x]{3}$")
 
+	labelNameRegex = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*$")
+
+	// labels set by the exporter, that cannot be used in Collector.Labels
+	reservedLabels = []string{"code", "uri", "flags", "setid", "status", "rank", "description", "table", "version", "target", "host", "port", "domain", "did", "sdomain", "carrier", "list", "profile", "value", "key", "group", "name", "pid", "type", "module", "site", "url", "set", "l_uuid", "r_uri", "state", "cipher", "protocol", "sub_protocol", "proto", "address", "advertise", "pipe"}
+
 	// implemented RPC methods
 	availableMethods = []string{
 		"tm.stats",
@@ -139,9 +290,32 @@ var (
 		"core.shmmem",
 		"core.uptime",
 		"core.tcp_info",
+		"core.udp4_raw_info",
 		"dispatcher.list",
 		"tls.info",
 		"dlg.stats_active",
+		"dlg.list",
+		"dlg.profile_get_size",
+		"dmq.list_nodes",
+		"core.psx",
+		"htable.stats",
+		"domain.dump",
+		"pdt.list",
+		"cr.dump_routes",
+		"userblocklist.dump_blocklist",
+		"siptrace.status",
+		"corex.debug",
+		"stats.fetch",
+		"mod.stats",
+		"ul.dump",
+		"rtpengine.show",
+		"uac.reg_dump",
+		"tls.list",
+		"ws.dump",
+		"core.version",
+		"core.sockets_list",
+		"pike.top",
+		"pl.stats",
 	}
 
 	metricsList = map[string][]Metric{
@@ -180,8 +354,22 @@ var (
 			NewMetricGauge("opened_tls_connections", "Opened TLS connections.", "core.tcp_info"),
 			NewMetricGauge("write_queued_bytes", "Write queued bytes.", "core.tcp_info"),
 		},
+		"core.udp4_raw_info": {
+			NewMetricGauge("udp4_raw", "Whether UDP over IPv4 is sent with raw sockets: 1 enabled, 0 disabled, -1 auto.", "core.udp4_raw_info"),
+			NewMetricGauge("udp4_raw_mtu", "MTU of the packets sent with raw sockets, fragmented above.", "core.udp4_raw_info"),
+			NewMetricGauge("udp4_raw_ttl", "TTL of the packets sent with raw sockets, -1 for the system default.", "core.udp4_raw_info"),
+		},
 		"dispatcher.list": {
 			NewMetricGauge("target", "Target status.", "dispatcher.list"),
+			NewMetricGauge("target_state", "State of the target (StateSet).", "dispatcher.list"),
+			NewMetricGauge("target_probing", "Whether the target is probed with keepalives.", "dispatcher.list"),
+			NewMetricGauge("target_priority", "Priority of the target in its set.", "dispatcher.list"),
+			NewMetricGauge("target_weight", "Weight of the target, from its attributes.", "dispatcher.list"),
+			NewMetricGauge("target_latency_average_seconds", "Average latency of the keepalives of the target.", "dispatcher.list"),
+			NewMetricGauge("target_latency_stdev_seconds", "Standard deviation of the latency of the keepalives of the target.", "dispatcher.list"),
+			NewMetricGauge("target_latency_estimate_seconds", "Estimated latency of the keepalives of the target (exponentially weighted).", "dispatcher.list"),
+			NewMetricGauge("target_latency_max_seconds", "Maximum latency of the keepalives of the target.", "dispatcher.list"),
+			NewMetricCounter("target_latency_timeouts", "Keepalives of the target that timed out.", "dispatcher.list"),
 		},
 		"tls.info": {
 			NewMetricGauge("opened_connections", "TLS Opened Connections.", "tls.info"),
@@ -194,44 +382,161 @@ var (
 			NewMetricGauge("ongoing", "Dialogs ongoing.", "dlg.stats_active"),
 			NewMetricGauge("all", "Dialogs all.", "dlg.stats_active"),
 		},
+		"dlg.list": {
+			NewMetricGauge("active", "Active dialogs, by the label extracted from their URIs.", "dlg.list"),
+			NewMetricHistogram("age_seconds", "Age of the active dialogs, since they were answered.", "dlg.list", dialogDurationBuckets),
+			NewMetricHistogram("completed_duration_seconds", "Duration of the dialogs that completed between two calls, as last seen.", "dlg.list", dialogDurationBuckets),
+		},
+		"dlg.profile_get_size": {
+			NewMetricGauge("dialogs", "Dialogs in the profile, by value for profiles with values.", "dlg.profile_get_size").Named("kamailio_dlg_profile_size"),
+		},
+		"core.psx": {
+			NewMetricCounter("cpu_seconds", "CPU time of the process, in seconds.", "core.psx"),
+			NewMetricGauge("resident_memory_bytes", "Resident memory of the process, in bytes.", "core.psx"),
+			NewMetricGauge("threads", "Number of threads of the process.", "core.psx"),
+			NewMetricGauge("open_fds", "Number of open file descriptors of the process.", "core.psx"),
+			NewMetricGauge("processes", "Number of processes of kamailio, by type.", "core.psx").Named("kamailio_core_processes"),
+			NewMetricGauge("process_info", "Processes of kamailio, always 1, with their PID and description.", "core.psx"),
+		},
+		"htable.stats": {
+			NewMetricGauge("slots", "Number of slots of the hash table.", "htable.stats"),
+			NewMetricGauge("items", "Number of items in the hash table.", "htable.stats").Named("kamailio_htable_entries"),
+			NewMetricGauge("slot_items_min", "Minimum number of items in a slot of the hash table.", "htable.stats"),
+			NewMetricGauge("slot_items_max", "Maximum number of items in a slot of the hash table.", "htable.stats"),
+		},
+		"dmq.list_nodes": {
+			NewMetricGauge("total", "Number of DMQ nodes, including the local node.", "dmq.list_nodes"),
+			NewMetricGauge("status", "Number of DMQ nodes by status.", "dmq.list_nodes"),
+			NewMetricGauge("node_state", "State of the DMQ node (StateSet).", "dmq.list_nodes"),
+		},
+		"domain.dump": {
+			NewMetricGauge("domains", "Number of domains loaded by the domain module.", "domain.dump"),
+			NewMetricGauge("domain_info", "Domains loaded by the domain module, with their domain ID.", "domain.dump"),
+		},
+		"pdt.list": {
+			NewMetricGauge("prefixes", "Number of prefix to domain mappings loaded by the pdt module, by source domain.", "pdt.list"),
+		},
+		"cr.dump_routes": {
+			NewMetricGauge("routes", "Number of routes (distinct prefixes) of the carrierroute routing tree, by carrier and domain.", "cr.dump_routes"),
+			NewMetricGauge("targets", "Number of targets of the carrierroute routing tree, by carrier and domain.", "cr.dump_routes"),
+		},
+		"userblocklist.dump_blocklist": {
+			NewMetricGauge("entries", "Number of entries of the global lists of the userblocklist module, by list.", "userblocklist.dump_blocklist"),
+		},
+		"siptrace.status": {
+			NewMetricGauge("enabled", "Whether SIP tracing is enabled.", "siptrace.status"),
+		},
+		"corex.debug": {
+			NewMetricGauge("level", "Debug level of the process.", "corex.debug"),
+		},
+		"stats.fetch": {
+			NewMetricGauge("value", "Statistics of kamailio, by group and name.", "stats.fetch"),
+		},
+		"ul.dump": {
+			NewMetricGauge("aors", "Number of registered AoRs, by usrloc table and domain.", "ul.dump"),
+			NewMetricGauge("contacts", "Number of registered contacts, including expired contacts not removed yet, by usrloc table and domain.", "ul.dump"),
+			NewMetricGauge("expired_contacts", "Number of expired contacts not removed yet, by usrloc table and domain.", "ul.dump"),
+		},
+		"rtpengine.show": {
+			NewMetricGauge("enabled", "Whether the RTPEngine node is enabled, by URL and set.", "rtpengine.show"),
+			NewMetricGauge("disabled_permanently", "Whether the RTPEngine node is disabled permanently, without being checked again.", "rtpengine.show"),
+			NewMetricGauge("weight", "Weight of the RTPEngine node.", "rtpengine.show"),
+			NewMetricGauge("recheck_ticks", "Ticks before the disabled RTPEngine node is checked again.", "rtpengine.show"),
+		},
+		"uac.reg_dump": {
+			NewMetricGauge("registered", "Whether the remote registration of the uac module is registered, by l_uuid and remote URI.", "uac.reg_dump"),
+			NewMetricGauge("disabled", "Whether the remote registration is disabled.", "uac.reg_dump"),
+			NewMetricGauge("expires_seconds", "Requested expiration of the remote registration, in seconds.", "uac.reg_dump"),
+			NewMetricGauge("remaining_seconds", "Time before the remote registration is refreshed, in seconds, 0 if not registered.", "uac.reg_dump"),
+		},
+		"tls.list": {
+			NewMetricGauge("connections", "Number of TLS connections, by state, TLS version and cipher.", "tls.list"),
+		},
+		"ws.dump": {
+			NewMetricGauge("connections", "Number of WebSocket connections, by protocol, state and sub-protocol.", "ws.dump"),
+			NewMetricGauge("truncated", "Whether kamailio listed only part of the WebSocket connections.", "ws.dump"),
+		},
+		"core.version": {
+			NewMetricGauge("info", "Version of kamailio and its compile flags.", "core.version"),
+		},
+		"core.sockets_list": {
+			NewMetricGauge("socket", "Listening socket, by protocol, address, port and advertised address.", "core.sockets_list"),
+			NewMetricGauge("sockets", "Number of listening sockets, by protocol.", "core.sockets_list"),
+		},
+		"pike.top": {
+			NewMetricGauge("ips", "Number of source addresses tracked by pike, by status: hot (blocked) or warm.", "pike.top"),
+		},
+		"pl.stats": {
+			NewMetricGauge("limit", "Limit of the pipe, in requests per interval.", "pl.stats"),
+			NewMetricGauge("counter", "Requests of the pipe during the current interval.", "pl.stats"),
+			NewMetricGauge("last_counter", "Requests of the pipe during the last interval.", "pl.stats"),
+			NewMetricGauge("load", "Share of the limit of the pipe used during the last interval.", "pl.stats"),
+		},
+		"mod.stats": {
+			NewMetricGauge("shm_bytes", "Shared memory allocated by the module, in bytes.", "mod.stats"),
+			NewMetricGauge("shm_site_bytes", "Shared memory allocated by the module, by function or allocation site, in bytes.", "mod.stats"),
+		},
 	}
 )
 
 // NewMetricGauge is a helper function to create a gauge.
 func NewMetricGauge(name string, help string, method string, labels ...string) Metric {
 	return Metric{
-		prometheus.GaugeValue,
-		name,
-		help,
-		method,
+		Kind:   prometheus.GaugeValue,
+		Name:   name,
+		Help:   help,
+		Method: method,
 	}
 }
 
 // NewMetricCounter is a helper function to create a counter.
 func NewMetricCounter(name string, help string, method string, labels ...string) Metric {
 	return Metric{
-		prometheus.CounterValue,
-		name,
-		help,
-		method,
+		Kind:   prometheus.CounterValue,
+		Name:   name,
+		Help:   help,
+		Method: method,
+	}
+}
+
+// NewMetricHistogram is a helper function to create a histogram.
+func NewMetricHistogram(name string, help string, method string, buckets []float64) Metric {
+	return Metric{
+		Kind:    prometheus.UntypedValue,
+		Name:    name,
+		Help:    help,
+		Method:  method,
+		Buckets: buckets,
 	}
 }
 
+// Named returns m exported as name, instead of the name derived from its method.
+func (m Metric) Named(name string) Metric {
+	m.FullName = name
+	return m
+}
+
 // NewCollector processes uri, timeout and methods and returns a new Collector.
 func NewCollector(uri string, timeout time.Duration, methods string) (*Collector, error) {
 	c := Collector{}
 
 	c.URI = uri
 	c.Timeout = timeout
+	c.TargetInfo = targetInfoOff
+	c.BINRPCCookie = cookieFixed
+	c.ProcfsPath = "/proc"
+	c.ModStatsLevel = modStatsModule
 
-	var url *url.URL
-	var err error
+	// several URIs can be given for the same instance, they are tried in order
+	for _, uri := range strings.Split(c.URI, ",") {
+		url, err := url.Parse(uri)
 
-	if url, err = url.Parse(c.URI); err != nil {
-		return nil, fmt.Errorf("cannot parse URI: %w", err)
-	}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse URI: %w", err)
+		}
 
-	c.url = url
+		c.urls = append(c.urls, url)
+	}
 
 	c.Methods = strings.Split(methods, ",")
 
@@ -260,6 +565,18 @@ func NewCollector(uri string, timeout time.Duration, methods string) (*Collector
 		Help:      "Was the last scrape successful.",
 	})
 
+	c.maintenanceDesc = prometheus.NewDesc(
+		namespace+"_maintenance",
+		"Whether kamailio is in maintenance. Failed scrapes do not set kamailio_up to 0 during maintenance.",
+		nil, nil,
+	)
+
+	c.staleDesc = prometheus.NewDesc(
+		namespace+"_data_stale",
+		"Whether the metrics of kamailio are the last successful values, served again during the grace period after a failed scrape.",
+		nil, nil,
+	)
+
 	c.totalScrapes = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "exporter_total_scrapes",
@@ -269,12 +586,219 @@ func NewCollector(uri string, timeout time.Duration, methods string) (*Collector
 	c.failedScrapes = prometheus.NewCounter(prometheus.CounterOpts{
 		Namespace: namespace,
 		Name:      "exporter_failed_scrapes",
-		Help:      "Number of failed kamailio scrapes",
+		Help:      "Number of failed kamailio scrapes. Deprecated: use kamailio_exporter_failed_scrapes_total, by type of error",
 	})
 
+	c.failedByType = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exporter_failed_scrapes_total",
+		Help:      "Number of failed kamailio scrapes, by type of error",
+	}, []string{"error_type"})
+
+	c.lastError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_last_scrape_error",
+		Help:      "Whether the last scrape failed with this type of error.",
+	}, []string{"error_type"})
+
+	// known types are exported from the start, so that rate() and alerts see their first failure
+	for _, errorType := range scrapeErrorTypes {
+		c.failedByType.WithLabelValues(errorType)
+		c.lastError.WithLabelValues(errorType)
+	}
+
+	c.methodsSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exporter_methods_skipped_total",
+		Help:      "Number of methods skipped because the scrape deadline was nearly exhausted",
+	}, []string{"method"})
+
+	c.methodUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "method_up",
+		Help:      "Whether the last call of the method succeeded.",
+	}, []string{"method"})
+
+	c.dnsErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "exporter_dns_resolution_errors_total",
+		Help:      "Number of failed resolutions of the kamailio host name",
+	})
+
+	c.activeURI = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_active_scrape_uri",
+		Help:      "Whether this URI answered the last connection attempt.",
+	}, []string{"uri"})
+
+	c.lastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "exporter_method_last_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful call of the method.",
+	}, []string{"method"})
+
 	return &c, nil
 }
 
+// Reconfigure applies the settings of n (created with NewCollector) to c.
+// Scrape counters are preserved.
+func (c *Collector) Reconfigure(n *Collector) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.URI != n.URI {
+		c.disconnect()
+	}
+
+	c.URI = n.URI
+	c.Timeout = n.Timeout
+	// forget methods that are no longer configured
+	for _, method := range c.Methods {
+		found := false
+
+		for _, m := range n.Methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			c.lastSuccess.DeleteLabelValues(method)
+			c.methodUp.DeleteLabelValues(method)
+		}
+	}
+
+	c.Methods = n.Methods
+	c.Interval = n.Interval
+	c.MethodIntervals = n.MethodIntervals
+	c.DNSTTL = n.DNSTTL
+	c.BINRPCCookie = n.BINRPCCookie
+	c.TargetInfo = n.TargetInfo
+	c.TargetName = n.TargetName
+	c.Labels = n.Labels
+	c.DlgListMaxDialogs = n.DlgListMaxDialogs
+	c.SlowScrapeThreshold = n.SlowScrapeThreshold
+	c.ProcfsPath = n.ProcfsPath
+	c.HTableInclude = n.HTableInclude
+	c.HTableExclude = n.HTableExclude
+	c.DispatcherURINormalize = n.DispatcherURINormalize
+	c.DomainInfo = n.DomainInfo
+	c.StringValues = n.StringValues
+	c.Pipeline = n.Pipeline
+	c.DialogLabel = n.DialogLabel
+	c.DialogProfiles = n.DialogProfiles
+	c.StatsGroups = n.StatsGroups
+	c.ModStatsLevel = n.ModStatsLevel
+	c.GracePeriod = n.GracePeriod
+	c.Timestamps = n.Timestamps
+	c.Persistent = n.Persistent
+	c.KeepaliveInterval = n.KeepaliveInterval
+	c.urls = n.urls
+
+	// their labels may have changed
+	c.lastGood = lastGood{}
+
+	// target labels are updated on the next scrape
+	c.targetLabels = nil
+	c.targetInfo = nil
+	c.descs = nil
+
+	// forget URIs that are no longer configured
+	c.activeURI.Reset()
+
+	c.startBackground()
+}
+
+// Error implements error.
+func (e *RPCError) Error() string {
+	return fmt.Sprintf(`invalid response for method "%s": [%d] %s`, e.Method, e.Code, e.Message)
+}
+
+// methodNotFound returns true if kamailio does not know the method: "command ... not found" over BINRPC,
+// "Method Not Found" over the jsonrpcs module.
+func (e *RPCError) methodNotFound() bool {
+	return strings.Contains(strings.ToLower(e.Message), "not found")
+}
+
+// scrapeErrorTypes are the types of scrape errors, as returned by scrapeErrorType.
+// RPC errors other than 500 are exported as "rpc_<code>" too.
+var scrapeErrorTypes = []string{"dns", "connection_refused", "connection_error", "timeout", "rpc_500", "parse"}
+
+// scrapeErrorType returns the type of err, a scrape error, to tell "kamailio down"
+// from "kamailio overloaded" or "exporter misparsing" in alerts.
+func scrapeErrorType(err error) string {
+	var (
+		dnsErr *net.DNSError
+		rpcErr *RPCError
+		netErr net.Error
+		opErr  *net.OpError
+		status *httpStatusError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	// a missing unix socket, or a FIFO without reader, means that kamailio is not running too
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ENOENT), errors.Is(err, syscall.ENXIO):
+		return "connection_refused"
+	case errors.As(err, &rpcErr):
+		return "rpc_" + strconv.Itoa(rpcErr.Code)
+	case errors.As(err, &opErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET),
+		errors.As(err, &status):
+		return "connection_error"
+	}
+
+	// anything else comes from the decoding of the response
+	return "parse"
+}
+
+// scrapeFailed accounts for err, the error of a scrape. c.mutex must be held.
+func (c *Collector) scrapeFailed(err error) {
+	errorType := scrapeErrorType(err)
+
+	c.failedScrapes.Inc()
+	c.failedByType.WithLabelValues(errorType).Inc()
+	c.setLastError(errorType)
+
+	// planned restarts must not trigger alerts: kamailio_up keeps its value
+	if c.InMaintenance() {
+		log.Printf("[info] %s (%s), ignored during maintenance", err, errorType)
+		return
+	}
+
+	c.up.Set(0)
+	c.notifyAlerts(err)
+	c.notifyWebhooks(false)
+
+	log.Printf("[error] %s (%s)", err, errorType)
+}
+
+// scrapeSucceeded accounts for a successful scrape. c.mutex must be held.
+func (c *Collector) scrapeSucceeded() {
+	c.up.Set(1)
+	c.setLastError("")
+	c.notifyAlerts(nil)
+	c.notifyWebhooks(true)
+	c.pingHeartbeat()
+}
+
+// setLastError sets kamailio_exporter_last_scrape_error to 1 for errorType only.
+// Unknown types (such as unusual RPC codes) are dropped on the next scrape.
+func (c *Collector) setLastError(errorType string) {
+	c.lastError.Reset()
+
+	for _, t := range scrapeErrorTypes {
+		c.lastError.WithLabelValues(t)
+	}
+
+	if errorType != "" {
+		c.lastError.WithLabelValues(errorType).Set(1)
+	}
+}
+
 // ExportedName returns a formatted Prometheus metric name, in the form:
 // "namespace_method_metric" for gauge
 // "namespace_method_metric_total" for counters
@@ -284,6 +808,10 @@ func NewCollector(uri string, timeout time.Duration, methods string) (*Collector
 //           "kamailio_tm_stats_created_total"
 //           "kamailio_sl_stats_200_total"
 func (m *Metric) ExportedName() string {
+	if m.FullName != "" {
+		return m.FullName
+	}
+
 	suffix := m.Name
 
 	if m.Kind == prometheus.CounterValue {
@@ -331,58 +859,552 @@ func (m *MetricValue) LabelValues() []string {
 	return list
 }
 
-// scrape will connect to the kamailio instance if needed, and push metrics to the Prometheus channel.
-func (c *Collector) scrape(ch chan<- prometheus.Metric) error {
+// dial connects to the kamailio instance, trying each URI in order.
+// The URI that answered is exported by kamailio_exporter_active_scrape_uri.
+func (c *Collector) dial(ctx context.Context) (conn net.Conn, err error) {
+	for i, u := range c.urls {
+		if conn, err = c.dialURL(ctx, u); err == nil {
+			c.setActiveURI(u.Redacted())
+
+			for j, other := range c.urls {
+				if j == i {
+					c.activeURI.WithLabelValues(other.Redacted()).Set(1)
+				} else {
+					c.activeURI.WithLabelValues(other.Redacted()).Set(0)
+				}
+			}
+
+			return conn, nil
+		}
+
+		if i < len(c.urls)-1 {
+			log.Printf("[warning] cannot connect to %s (%s), trying next URI", u.Redacted(), err)
+		}
+	}
+
+	c.setActiveURI("")
+
+	for _, u := range c.urls {
+		c.activeURI.WithLabelValues(u.Redacted()).Set(0)
+	}
+
+	return nil, err
+}
+
+// dialURL connects to the kamailio instance at u.
+func (c *Collector) dialURL(ctx context.Context, u *url.URL) (net.Conn, error) {
+	address := u.Host
+	if u.Scheme == "unix" || u.Scheme == "unixgram" {
+		address = u.Path
+	}
+
+	if u.Scheme == "exec" {
+		return dialExec(u)
+	}
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		client := c.HTTPClient
+		if client == nil {
+			client = jsonrpcClient
+		}
+
+		return dialJSONRPC(u, client), nil
+	}
+
+	if u.Scheme == "fifo" {
+		return dialFIFO(u)
+	}
+
+	if u.Scheme == "unixgram" || u.Scheme == "udp" {
+		return dialDatagram(ctx, u.Scheme, address)
+	}
+
+	if u.Scheme == "tcp" && c.DNSTTL > 0 {
+		return c.dialCached(ctx, u.Scheme, address)
+	}
+
+	dialer := net.Dialer{}
+
+	return dialer.DialContext(ctx, u.Scheme, address)
+}
+
+// WaitReady tries to connect to the kamailio instance until it succeeds, or until
+// maxWait is elapsed. Attempts are retried with an exponential backoff.
+func (c *Collector) WaitReady(maxWait time.Duration) error {
+	deadline := time.Now().Add(maxWait)
+	backoff := 250 * time.Millisecond
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		conn, err := c.dial(ctx)
+		cancel()
+
+		if err == nil {
+			return conn.Close()
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("kamailio not reachable after %s: %w", maxWait, err)
+		}
+
+		log.Printf("[info] waiting for kamailio (%s), retrying in %s", err, backoff)
+		time.Sleep(backoff)
+
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// scrape will connect to the kamailio instance if needed, call methods, and pass the metrics to emit.
+// ctx bounds the whole scrape: name resolution, dialing, every RPC and parsing.
+// Methods skipped because the deadline was nearly exhausted are returned, and the methods that failed
+// without failing the scrape (see isMethodError). err is set if the connection to kamailio failed.
+func (c *Collector) scrape(ctx context.Context, methods []string, emit func(method string, metric prometheus.Metric)) (skipped []string, failed []string, err error) {
 	c.totalScrapes.Inc()
 
-	var err error
+	var (
+		succeeded    []string
+		methodErrors = make(map[string]string)
+	)
 
-	address := c.url.Host
-	if c.url.Scheme == "unix" {
-		address = c.url.Path
+	defer func(start time.Time) {
+		c.updateHealth(start, skipped, err)
+		c.recordScrape(start, succeeded, methodErrors, skipped, err)
+	}(time.Now())
+	c.targetInfo = nil
+
+	var timings []methodTiming
+
+	if c.SlowScrapeThreshold > 0 {
+		defer logSlowScrape(time.Now(), c.SlowScrapeThreshold, &timings)
 	}
 
-	c.conn, err = net.DialTimeout(c.url.Scheme, address, c.Timeout)
+	start := time.Now()
+
+	c.conn, err = c.connect(ctx)
+	timings = append(timings, methodTiming{"connect", time.Since(start)})
 
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	c.conn.SetDeadline(time.Now().Add(c.Timeout))
+	defer func() {
+		// nil if reconnecting after a failed method failed
+		if c.conn != nil {
+			c.release(c.conn, err)
+		}
+	}()
 
-	defer c.conn.Close()
+	if c.TargetInfo != targetInfoOff {
+		start := time.Now()
+
+		if err := c.updateTargetLabels(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		timings = append(timings, methodTiming{"core.version", time.Since(start)})
+	}
+
+	if c.Pipeline {
+		var n int
+
+		methods, n = pipelineOrder(methods)
+
+		if n > 0 {
+			start := time.Now()
+
+			defer func() {
+				c.pipelined = nil
+			}()
+
+			if err := c.pipeline(ctx, methods[:n]); err != nil {
+				return nil, nil, err
+			}
+
+			timings = append(timings, methodTiming{"pipeline", time.Since(start)})
+		}
+	}
+
+	deadline, _ := ctx.Deadline()
+
+	// the remaining budget must allow for the slowest method seen so far
+	margin := c.Timeout / deadlineMarginDivisor
+
+	for i, method := range methods {
+		// partial data is better than a scrape failing on timeout
+		if time.Until(deadline) < margin {
+			for _, skipped := range methods[i:] {
+				c.methodsSkipped.WithLabelValues(skipped).Inc()
+			}
+
+			log.Println("[warning] scrape deadline nearly exhausted, skipped methods:", strings.Join(methods[i:], ","))
+			return methods[i:], failed, nil
+		}
 
-	for _, method := range c.Methods {
 		if _, found := metricsList[method]; !found {
 			panic("invalid method requested")
 		}
 
-		metricsScraped, err := c.scrapeMethod(method)
+		start := time.Now()
 
-		if err != nil {
-			return err
-		}
+		err := c.scrapeMethod(ctx, method, func(name string, metricValue MetricValue) error {
+			metricDef, found := findMetric(method, name)
 
-		for _, metricDef := range metricsList[method] {
-			metricValues, found := metricsScraped[metricDef.Name]
+			if !found && name == stringInfo && c.StringValues {
+				metricDef, found = stringInfoMetric(method), true
+			}
 
 			if !found {
-				continue
+				return nil
 			}
 
-			for _, metricValue := range metricValues {
-				metric, err := prometheus.NewConstMetric(
-					prometheus.NewDesc(metricDef.ExportedName(), metricDef.Help, metricValue.LabelKeys(), nil),
+			var (
+				metric prometheus.Metric
+				err    error
+			)
+
+			if metricDef.Buckets != nil {
+				metric, err = prometheus.NewConstHistogram(
+					c.desc(metricDef, metricValue.LabelKeys()),
+					metricValue.Count,
+					metricValue.Value,
+					metricValue.Buckets,
+					metricValue.LabelValues()...,
+				)
+			} else {
+				metric, err = prometheus.NewConstMetric(
+					c.desc(metricDef, metricValue.LabelKeys()),
 					metricDef.Kind,
 					metricValue.Value,
 					metricValue.LabelValues()...,
 				)
+			}
+
+			if err != nil {
+				return err
+			}
+
+			c.recordStatus(metricDef, metricValue)
+			c.recordTransition(metricDef, metricValue)
+			emit(method, metric)
+
+			return nil
+		})
+
+		elapsed := time.Since(start)
+		timings = append(timings, methodTiming{method, elapsed})
+
+		c.commitStatus(method, err == nil)
+		c.commitTransitions(method, err == nil)
+
+		if err != nil {
+			methodErrors[method] = err.Error()
+			c.methodUp.WithLabelValues(method).Set(0)
+
+			if !isMethodError(err) {
+				return nil, failed, err
+			}
+
+			failed = append(failed, method)
+			log.Printf("[warning] method %s failed: %s (%s)", method, err, scrapeErrorType(err))
+
+			if err := c.redial(ctx, err); err != nil {
+				return nil, failed, err
+			}
+
+			continue
+		}
+
+		succeeded = append(succeeded, method)
+		c.methodUp.WithLabelValues(method).Set(1)
+		c.lastSuccess.WithLabelValues(method).SetToCurrentTime()
+
+		if elapsed > margin {
+			margin = elapsed
+		}
+	}
+
+	return nil, failed, nil
+}
+
+// isMethodError returns true if err, returned by a method, concerns the method alone: kamailio
+// rejected the call (e.g. module not loaded), or the response could not be decoded. The other
+// methods are still scraped, while errors of the connection (or of the calls of exec:, http: and fifo: URIs) fail the scrape.
+func isMethodError(err error) bool {
+	var (
+		rpcErr   *RPCError
+		transErr *transportError
+	)
+
+	switch {
+	case errors.As(err, &rpcErr):
+		return true
+	case errors.As(err, &transErr):
+		return false
+	}
+
+	return scrapeErrorType(err) == "parse"
+}
+
+// redial replaces c.conn after err, returned by a method, unless it is an error reply of kamailio:
+// the response may have been read partially. c.mutex must be held.
+func (c *Collector) redial(ctx context.Context, err error) error {
+	var rpcErr *RPCError
+
+	if errors.As(err, &rpcErr) {
+		return nil
+	}
+
+	c.release(c.conn, err)
+	c.conn, err = c.connect(ctx)
+
+	return err
+}
+
+// logSlowScrape logs the duration of each step of a scrape started at start, if it lasted longer than threshold.
+func logSlowScrape(start time.Time, threshold time.Duration, timings *[]methodTiming) {
+	total := time.Since(start)
+
+	if total <= threshold {
+		return
+	}
+
+	steps := make([]string, 0, len(*timings))
+
+	for _, timing := range *timings {
+		steps = append(steps, fmt.Sprintf("%s=%s", timing.method, timing.duration.Round(time.Millisecond)))
+	}
+
+	log.Printf("[warning] slow scrape: duration=%s threshold=%s %s", total.Round(time.Millisecond), threshold, strings.Join(steps, " "))
+}
+
+// desc returns the description of metricDef with labelKeys.
+// Descriptions are cached, since building them is costly. c.mutex must be held.
+func (c *Collector) desc(metricDef Metric, labelKeys []string) *prometheus.Desc {
+	key := metricDef.Method + "\xff" + metricDef.Name + "\xff" + strings.Join(labelKeys, "\xff")
+
+	if desc, found := c.descs[key]; found {
+		return desc
+	}
+
+	if c.descs == nil {
+		c.descs = make(map[string]*prometheus.Desc)
+	}
+
+	constLabels := prometheus.Labels{}
+
+	for name, value := range c.Labels {
+		constLabels[name] = value
+	}
+
+	if c.TargetInfo == targetInfoLabels {
+		for name, value := range c.targetLabels {
+			// the own labels of the metric win, e.g. "version" of core.version or tls.list
+			found := false
+
+			for _, key := range labelKeys {
+				if key == name {
+					found = true
+				}
+			}
+
+			if found {
+				continue
+			}
+
+			constLabels[name] = value
+		}
+	}
+
+	desc := prometheus.NewDesc(metricDef.ExportedName(), metricDef.Help, labelKeys, constLabels)
+	c.descs[key] = desc
+
+	return desc
+}
+
+// findMetric returns the definition of the metric name of method.
+func findMetric(method string, name string) (Metric, bool) {
+	for _, metricDef := range metricsList[method] {
+		if metricDef.Name == name {
+			return metricDef, true
+		}
+	}
+
+	return Metric{}, false
+}
+
+// scrapeMethod will pass metrics for one method to fn, by name.
+// Methods with large responses are decoded and passed to fn while reading the response.
+func (c *Collector) scrapeMethod(ctx context.Context, method string, fn func(name string, value MetricValue) error) error {
+	switch method {
+	case "dispatcher.list":
+		// normalized URIs may collide, and a metric cannot be exported twice
+		seen := make(map[string]bool)
+		seenTargets := make(map[string]bool)
+
+		return c.streamBINRPC(ctx, method, func(d *rpcDecoder) error {
+			return streamDispatcherTargets(d, func(target DispatcherTarget) error {
+				uri := normalizeURI(target.URI, c.DispatcherURINormalize)
+				key := fmt.Sprintf("%s\xff%s\xff%d", uri, target.Flags, target.SetID)
+
+				if seen[key] {
+					return nil
+				}
+
+				seen[key] = true
+
+				err := fn("target", MetricValue{
+					Value: 1,
+					Labels: map[string]string{
+						"uri":   uri,
+						"flags": target.Flags,
+						"setid": strconv.Itoa(target.SetID),
+					},
+				})
 
 				if err != nil {
 					return err
 				}
 
-				ch <- metric
+				if c.StringValues && target.Attrs != "" {
+					err := fn(stringInfo, stringValue("attrs", target.Attrs, map[string]string{
+						"uri":   uri,
+						"setid": strconv.Itoa(target.SetID),
+					}))
+
+					if err != nil {
+						return err
+					}
+				}
+
+				// the other metrics are exported once per URI and set, whatever the flags
+				targetKey := fmt.Sprintf("%s\xff%d", uri, target.SetID)
+
+				if seenTargets[targetKey] {
+					return nil
+				}
+
+				seenTargets[targetKey] = true
+
+				labels := map[string]string{
+					"uri":   uri,
+					"setid": strconv.Itoa(target.SetID),
+				}
+
+				for name, value := range map[string]float64{
+					"target_probing":  boolValue(len(target.Flags) > 1 && target.Flags[1] == 'P'),
+					"target_priority": float64(target.Priority),
+					"target_weight":   float64(target.Weight),
+				} {
+					if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+						return err
+					}
+				}
+
+				if target.Latency != nil {
+					for name, value := range map[string]float64{
+						"target_latency_average_seconds":  target.Latency.Average / 1000,
+						"target_latency_stdev_seconds":    target.Latency.Stdev / 1000,
+						"target_latency_estimate_seconds": target.Latency.Estimate / 1000,
+						"target_latency_max_seconds":      target.Latency.Max / 1000,
+						"target_latency_timeouts":         float64(target.Latency.Timeouts),
+					} {
+						if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+							return err
+						}
+					}
+				}
+
+				if target.Flags == "" {
+					return nil
+				}
+
+				state, found := dispatcherStates[target.Flags[0]]
+
+				if !found {
+					return nil
+				}
+
+				for _, s := range dispatcherStates {
+					value := 0.0
+					if s == state {
+						value = 1
+					}
+
+					err := fn("target_state", MetricValue{
+						Value: value,
+						Labels: map[string]string{
+							"uri":                     uri,
+							"setid":                   strconv.Itoa(target.SetID),
+							dispatcherStateMetricName: s,
+						},
+					})
+
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		})
+	case "dlg.list":
+		return c.scrapeDialogs(ctx, fn)
+	case "dlg.profile_get_size":
+		return c.scrapeDialogProfiles(ctx, fn)
+	case "dmq.list_nodes":
+		return c.scrapeDMQNodes(ctx, fn)
+	case "core.psx":
+		return c.scrapeProcesses(ctx, fn)
+	case "htable.stats":
+		return c.scrapeHTables(ctx, fn)
+	case "domain.dump":
+		return c.scrapeDomains(ctx, fn)
+	case "pdt.list":
+		return c.scrapePDT(ctx, fn)
+	case "cr.dump_routes":
+		return c.scrapeCarrierRoutes(ctx, fn)
+	case "userblocklist.dump_blocklist":
+		return c.scrapeUserBlocklist(ctx, fn)
+	case "siptrace.status":
+		return c.scrapeSiptrace(ctx, fn)
+	case "corex.debug":
+		return c.scrapeDebugLevels(ctx, fn)
+	case "stats.fetch":
+		return c.scrapeStats(ctx, fn)
+	case "mod.stats":
+		return c.scrapeModStats(ctx, fn)
+	case "ul.dump":
+		return c.scrapeUsrloc(ctx, fn)
+	case "rtpengine.show":
+		return c.scrapeRTPEngines(ctx, fn)
+	case "uac.reg_dump":
+		return c.scrapeUACRegistrations(ctx, fn)
+	case "tls.list":
+		return c.scrapeTLSConnections(ctx, fn)
+	case "ws.dump":
+		return c.scrapeWebSockets(ctx, fn)
+	case "core.version":
+		return c.scrapeVersion(ctx, fn)
+	case "core.sockets_list":
+		return c.scrapeSockets(ctx, fn)
+	case "pike.top":
+		return c.scrapePike(ctx, fn)
+	case "pl.stats":
+		return c.scrapePipes(ctx, fn)
+	}
+
+	metrics, err := c.parseMethod(ctx, method)
+
+	if err != nil {
+		return err
+	}
+
+	for name, values := range metrics {
+		for _, value := range values {
+			if err := fn(name, value); err != nil {
+				return err
 			}
 		}
 	}
@@ -390,17 +1412,23 @@ func (c *Collector) scrape(ch chan<- prometheus.Metric) error {
 	return nil
 }
 
-// scrapeMethod will return metrics for one method.
-func (c *Collector) scrapeMethod(method string) (map[string][]MetricValue, error) {
-	records, err := c.fetchBINRPC(method)
+// parseMethod will return metrics for one method.
+func (c *Collector) parseMethod(ctx context.Context, method string) (map[string][]MetricValue, error) {
+	records, found := c.pipelined[method]
 
-	if err != nil {
-		return nil, err
+	if found {
+		delete(c.pipelined, method)
+	} else {
+		var err error
+
+		if records, err = c.fetchBINRPC(ctx, method); err != nil {
+			return nil, err
+		}
 	}
 
 	// we expect just 1 record of type map
 	if len(records) == 2 && records[0].Type == binrpc.TypeInt && records[0].Value.(int) == 500 {
-		return nil, fmt.Errorf(`invalid response for method "%s": [500] %s`, method, records[1].Value.(string))
+		return nil, &RPCError{Method: method, Code: 500, Message: records[1].Value.(string)}
 	} else if len(records) != 1 {
 		return nil, fmt.Errorf(`invalid response for method "%s", expected %d record, got %d`,
 			method, 1, len(records),
@@ -429,7 +1457,7 @@ func (c *Collector) scrapeMethod(method string) (map[string][]MetricValue, error
 					MetricValue{
 						Value: float64(i),
 						Labels: map[string]string{
-							"code": item.Key,
+							"code": labelValues.String(item.Key),
 						},
 					},
 				)
@@ -450,124 +1478,41 @@ func (c *Collector) scrapeMethod(method string) (map[string][]MetricValue, error
 			i, _ := item.Value.Int()
 			metrics[item.Key] = []MetricValue{{Value: float64(i)}}
 		}
-	case "dispatcher.list":
-		targets, err := parseDispatcherTargets(items)
-
-		if err != nil {
-			return nil, err
-		}
-
-		if len(targets) == 0 {
-			break
+	case "core.udp4_raw_info":
+		for _, item := range items {
+			i, _ := item.Value.Int()
+			// ints are decoded as unsigned, which suits counters, but -1 (auto) is sent as a 32-bit integer
+			metrics[item.Key] = []MetricValue{{Value: float64(int32(uint32(i)))}}
 		}
+	}
 
-		for _, target := range targets {
-			mv := MetricValue{
-				Value: 1,
-				Labels: map[string]string{
-					"uri":   target.URI,
-					"flags": target.Flags,
-					"setid": strconv.Itoa(target.SetID),
-				},
-			}
+	if c.StringValues {
+		metrics[stringInfo] = stringItems(items)
+	}
 
-			metrics["target"] = append(metrics["target"], mv)
-		}
+	// parsing large responses takes time too
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf(`timeout while parsing method "%s": %w`, method, err)
 	}
 
 	return metrics, nil
 }
 
-// parseDispatcherTargets parses the "dispatcher.list" result and returns a list of targets.
-func parseDispatcherTargets(items []binrpc.StructItem) ([]DispatcherTarget, error) {
-	var result []DispatcherTarget
-
-	for _, item := range items {
-		if item.Key != "RECORDS" {
-			continue
-		}
-
-		sets, err := item.Value.StructItems()
+// fetchBINRPC talks to kamailio using the BINRPC protocol.
+// The socket deadline is refreshed from ctx before the request.
+func (c *Collector) fetchBINRPC(ctx context.Context, method string, params ...any) ([]binrpc.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf(`timeout before calling method "%s": %w`, method, err)
+	}
 
-		if err != nil {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
 			return nil, err
 		}
-
-		for _, item = range sets {
-			if item.Key != "SET" {
-				continue
-			}
-
-			setItems, err := item.Value.StructItems()
-
-			if err != nil {
-				return nil, err
-			}
-
-			var setID int
-			var targets []DispatcherTarget
-
-			for _, set := range setItems {
-				if set.Key == "ID" {
-					if setID, err = set.Value.Int(); err != nil {
-						return nil, err
-					}
-				} else if set.Key == "TARGETS" {
-					destinations, err := set.Value.StructItems()
-
-					if err != nil {
-						return nil, err
-					}
-
-					for _, destination := range destinations {
-						if destination.Key != "DEST" {
-							continue
-						}
-
-						props, err := destination.Value.StructItems()
-
-						if err != nil {
-							return nil, err
-						}
-
-						target := DispatcherTarget{}
-
-						for _, prop := range props {
-							switch prop.Key {
-							case "URI":
-								target.URI, _ = prop.Value.String()
-							case "FLAGS":
-								target.Flags, _ = prop.Value.String()
-							}
-						}
-
-						targets = append(targets, target)
-					}
-				}
-			}
-
-			if setID == 0 {
-				return nil, errors.New("missing set ID while parsing dispatcher.list")
-			}
-
-			if len(targets) == 0 {
-				continue
-			}
-
-			for _, target := range targets {
-				target.SetID = setID
-				result = append(result, target)
-			}
-		}
 	}
 
-	return result, nil
-}
-
-// fetchBINRPC talks to kamailio using the BINRPC protocol.
-func (c *Collector) fetchBINRPC(method string) ([]binrpc.Record, error) {
 	// WritePacket returns the cookie generated
-	cookie, err := binrpc.WritePacket(c.conn, method)
+	cookie, err := writePacket(c.conn, c.BINRPCCookie, append([]any{method}, params...)...)
 
 	if err != nil {
 		return nil, err
@@ -575,7 +1520,7 @@ func (c *Collector) fetchBINRPC(method string) ([]binrpc.Record, error) {
 
 	// the cookie is passed again for verification
 	// we receive records in response
-	records, err := binrpc.ReadPacket(c.conn, cookie)
+	records, err := readPacket(c.conn, cookie)
 
 	if err != nil {
 		return nil, err
@@ -584,6 +1529,28 @@ func (c *Collector) fetchBINRPC(method string) ([]binrpc.Record, error) {
 	return records, nil
 }
 
+// Call connects to kamailio, or uses the persistent connection, calls method with params and
+// returns the records of the response.
+func (c *Collector) Call(method string, params ...any) ([]binrpc.Record, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	conn, err := c.connect(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+	records, err := c.fetchBINRPC(ctx, method, params...)
+	c.release(conn, err)
+
+	return records, err
+}
+
 // Describe implements prometheus.Collector.
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	prometheus.DescribeByCollect(c, ch)
@@ -591,20 +1558,66 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect implements prometheus.Collector.
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, 0)
+}
+
+// collect scrapes kamailio, within c.Timeout, or within limit if it is shorter (see scrapetimeout.go).
+func (c *Collector) collect(ch chan<- prometheus.Metric, limit time.Duration) {
+	if c.collectCached(ch) {
+		return
+	}
+
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
-	err := c.scrape(ch)
+	timeout := c.Timeout
+	if limit > 0 && limit < timeout {
+		timeout = limit
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	emit := func(method string, metric prometheus.Metric) {
+		ch <- metric
+	}
+
+	var collected map[string][]prometheus.Metric
+
+	if c.GracePeriod > 0 {
+		// buffered, since the last good metrics are sent instead if the scrape fails
+		collected = make(map[string][]prometheus.Metric)
+		emit = func(method string, metric prometheus.Metric) {
+			collected[method] = append(collected[method], metric)
+		}
+	}
+
+	_, _, err := c.scrape(ctx, c.Methods, emit)
 
 	if err != nil {
-		c.failedScrapes.Inc()
-		c.up.Set(0)
-		log.Println("[error]", err)
+		c.scrapeFailed(err)
 	} else {
-		c.up.Set(1)
+		c.scrapeSucceeded()
+	}
+
+	stale := false
+
+	if c.GracePeriod > 0 {
+		stale = c.serveGrace(ch, collected, err == nil)
+	} else if c.TargetInfo == targetInfoMetric && c.targetInfo != nil {
+		ch <- c.targetInfo
 	}
 
 	ch <- c.up
+	c.collectMaintenance(ch)
+	c.collectStale(ch, c.GracePeriod, stale)
 	ch <- c.totalScrapes
 	ch <- c.failedScrapes
+	c.failedByType.Collect(ch)
+	c.lastError.Collect(ch)
+	ch <- c.dnsErrors
+	c.methodsSkipped.Collect(ch)
+	c.methodUp.Collect(ch)
+	c.activeURI.Collect(ch)
+	c.lastSuccess.Collect(ch)
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Completion scripts, with "{{name}}" replaced by the name of the program. They call the program
+// with the hidden --completion-bash flag of kingpin, which completes flags, commands and the
+// values of flags with hints, such as the methods of --kamailio.methods. The word being completed
+// is only passed if it is a flag, as kingpin fails to parse partial commands and values; the shell
+// filters the completions.
+const (
+	bashCompletionScript = `_{{name}}_completion() {
+    local cur opts words
+    COMPREPLY=()
+    cur="${COMP_WORDS[COMP_CWORD]}"
+    words=("${COMP_WORDS[@]:1:COMP_CWORD-1}")
+    [[ "${cur}" == -* ]] && words+=("${cur}")
+    opts=$("${COMP_WORDS[0]}" --completion-bash "${words[@]}")
+    COMPREPLY=($(compgen -W "${opts}" -- "${cur}"))
+    return 0
+}
+complete -F _{{name}}_completion {{name}}
+`
+
+	zshCompletionScript = `#compdef {{name}}
+autoload -U bashcompinit && bashcompinit
+
+` + bashCompletionScript
+
+	fishCompletionScript = `function __{{name}}_completion
+    set -l args (commandline -opc)
+    set -e args[1]
+    set -l current (commandline -ct)
+    if string match -q -- '-*' "$current"
+        set -a args $current
+    end
+    {{name}} --completion-bash $args
+end
+complete -c {{name}} -f -a '(__{{name}}_completion)'
+`
+)
+
+// writeCompletionScript writes the completion script of program for shell: "bash", "zsh" or "fish".
+func writeCompletionScript(w io.Writer, program string, shell string) error {
+	var script string
+
+	switch shell {
+	case "bash":
+		script = bashCompletionScript
+	case "zsh":
+		script = zshCompletionScript
+	case "fish":
+		script = fishCompletionScript
+	default:
+		return fmt.Errorf(`unsupported shell "%s", expected "bash", "zsh" or "fish"`, shell)
+	}
+
+	// shell function names cannot contain every character of file names
+	function := strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, program)
+
+	script = strings.ReplaceAll(script, "_{{name}}_", "_"+function+"_")
+	script = strings.ReplaceAll(script, "{{name}}", program)
+
+	_, err := io.WriteString(w, script)
+
+	return err
+}
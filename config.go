@@ -0,0 +1,1015 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// See exampleConfig in configprint.go for a sample configuration file.
+
+// Config is the content of the configuration file.
+// Unset values (nil pointers, empty strings and lists) fall back on the command line flags.
+// Numbers and booleans are pointers, so that 0 and false can override the flags.
+type Config struct {
+	ScrapeURI              string                   `yaml:"scrape_uri"`
+	Methods                []string                 `yaml:"methods"`
+	Timeout                *time.Duration           `yaml:"timeout"`
+	DNSTTL                 *time.Duration           `yaml:"dns_ttl"`               // cache of the addresses of tcp:// URIs
+	BINRPCCookie           string                   `yaml:"binrpc_cookie"`         // "fixed" or "compact"
+	Pipeline               *bool                    `yaml:"pipeline"`              // write all requests before reading the responses
+	PersistentConnection   *bool                    `yaml:"persistent_connection"` // connection kept open between scrapes
+	KeepaliveInterval      *time.Duration           `yaml:"keepalive_interval"`    // check of the idle persistent connection
+	CollectInterval        *time.Duration           `yaml:"collect_interval"`      // background collection if not 0
+	MethodIntervals        map[string]time.Duration `yaml:"method_intervals"`
+	CollectTimestamps      *bool                    `yaml:"collect_timestamps"`    // samples of background collection carry their collection time
+	GracePeriod            *time.Duration           `yaml:"grace_period"`          // last successful values served after failures if not 0
+	SlowScrapeThreshold    *time.Duration           `yaml:"slow_scrape_threshold"` // scrapes lasting longer are logged if not 0
+	ProcfsPath             string                   `yaml:"procfs_path"`
+	HTableInclude          string                   `yaml:"htable_include"` // regex of the tables of htable.stats
+	HTableExclude          string                   `yaml:"htable_exclude"`
+	DispatcherURINormalize []string                 `yaml:"dispatcher_uri_normalize"` // see dispatcher.go
+	DomainInfo             *bool                    `yaml:"domain_info"`              // info series per domain of domain.dump
+	StringValues           *bool                    `yaml:"string_values"`            // string values exported by string_info metrics
+	DlgListMaxDialogs      *int                     `yaml:"dlg_list_max_dialogs"`
+	DialogLabel            *DialogLabel             `yaml:"dialog_label"`
+	DialogProfiles         map[string][]string      `yaml:"dialog_profiles"` // values by profile of dlg.profile_get_size
+	StatsGroups            []string                 `yaml:"stats_groups"`    // groups of stats.fetch
+	ModStatsLevel          string                   `yaml:"mod_stats_level"` // "module", "function" or "line"
+	TargetInfo             string                   `yaml:"target_info"`     // "off", "metric" or "labels"
+	TargetName             string                   `yaml:"target_name"`
+	Labels                 map[string]string        `yaml:"labels"` // added to every kamailio metric
+	RegisterProbe          *RegisterProbeConfig     `yaml:"register_probe"`
+	InviteProbe            *InviteProbeConfig       `yaml:"invite_probe"`
+	Dependencies           []DependencyProbeConfig  `yaml:"dependencies"` // probes of the backends of kamailio
+	Targets                []ProbeTargetConfig      `yaml:"targets"`      // instances scraped with /probe?target=<name> or /metrics/<name>
+	IncludeDir             string                   `yaml:"include_dir"`  // directory of *.yml files merged into this config
+
+	includedMethods []string // methods of the included files, added to the flags when the file has none
+}
+
+// ConfigLoader loads the configuration file and applies it to a Collector.
+// It also exports metrics about the reloads.
+type ConfigLoader struct {
+	File     string
+	Defaults Config // from the command line flags
+
+	mutex     sync.Mutex
+	collector *Collector
+	config    *Config
+	hash      float64
+	override  *Config             // applied over the file with the API, see configapi.go
+	targets   []ProbeTargetConfig // added with the API, see configapi.go
+	reloaded  chan struct{}       // signaled after each successful load, see Reloaded
+
+	lastReloadSuccessful prometheus.Gauge
+	lastReloadTime       prometheus.Gauge
+	configHash           prometheus.Gauge
+}
+
+// NewConfigLoader returns a new ConfigLoader for file, with fallback values defaults.
+func NewConfigLoader(file string, defaults Config) *ConfigLoader {
+	return &ConfigLoader{
+		File:     file,
+		Defaults: defaults,
+		reloaded: make(chan struct{}, 1),
+
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful.",
+		}),
+		lastReloadTime: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_config_last_reload_time_seconds",
+			Help:      "Timestamp of the last successful configuration reload.",
+		}),
+		configHash: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_config_hash",
+			Help:      "Hash of the currently loaded configuration file.",
+		}),
+	}
+}
+
+// ParseMethodIntervals parses a list of intervals in the form "method=interval,method=interval".
+func ParseMethodIntervals(s string) (map[string]time.Duration, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	intervals := make(map[string]time.Duration)
+
+	for _, item := range strings.Split(s, ",") {
+		method, value, found := strings.Cut(item, "=")
+
+		if !found {
+			return nil, fmt.Errorf(`invalid method interval "%s", expected "method=interval"`, item)
+		}
+
+		interval, err := time.ParseDuration(value)
+
+		if err != nil {
+			return nil, fmt.Errorf(`invalid interval for method "%s": %w`, method, err)
+		}
+
+		intervals[method] = interval
+	}
+
+	return intervals, nil
+}
+
+// ParseLabels parses a list of labels in the form "name=value,name=value".
+func ParseLabels(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+
+	for _, item := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(item, "=")
+
+		if !found {
+			return nil, fmt.Errorf(`invalid label "%s", expected "name=value"`, item)
+		}
+
+		labels[name] = value
+	}
+
+	return labels, nil
+}
+
+// validateLabels checks the names of the labels added to every kamailio metric.
+func validateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !labelNameRegex.MatchString(name) {
+			return fmt.Errorf(`invalid label name "%s"`, name)
+		}
+
+		for _, reserved := range reservedLabels {
+			if name == reserved {
+				return fmt.Errorf(`label name "%s" is reserved`, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// NewCollector returns a new Collector created from c.
+func (c *Config) NewCollector() (*Collector, error) {
+	if c.Timeout == nil || *c.Timeout <= 0 {
+		return nil, errors.New("invalid timeout: it must be positive")
+	}
+
+	collector, err := NewCollector(c.ScrapeURI, *c.Timeout, strings.Join(c.Methods, ","))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for method, interval := range c.MethodIntervals {
+		found := false
+
+		for _, m := range collector.Methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf(`interval set for method "%s", which is not configured`, method)
+		}
+
+		if interval <= 0 {
+			return nil, fmt.Errorf(`invalid interval for method "%s": %s`, method, interval)
+		}
+	}
+
+	if len(c.MethodIntervals) > 0 && (c.CollectInterval == nil || *c.CollectInterval == 0) {
+		return nil, errors.New("method intervals require a collect interval")
+	}
+
+	switch c.BINRPCCookie {
+	case "", cookieFixed, cookieCompact:
+	default:
+		return nil, fmt.Errorf(`invalid binrpc_cookie "%s", expected "fixed" or "compact"`, c.BINRPCCookie)
+	}
+
+	if c.BINRPCCookie != "" {
+		collector.BINRPCCookie = c.BINRPCCookie
+	}
+
+	if c.Pipeline != nil {
+		collector.Pipeline = *c.Pipeline
+	}
+
+	if c.PersistentConnection != nil {
+		collector.Persistent = *c.PersistentConnection
+	}
+
+	if c.KeepaliveInterval != nil {
+		if *c.KeepaliveInterval < 0 {
+			return nil, fmt.Errorf("invalid keepalive_interval: %s", *c.KeepaliveInterval)
+		}
+
+		collector.KeepaliveInterval = *c.KeepaliveInterval
+	}
+
+	if c.DomainInfo != nil {
+		collector.DomainInfo = *c.DomainInfo
+	}
+
+	if c.StringValues != nil {
+		collector.StringValues = *c.StringValues
+	}
+
+	if c.CollectTimestamps != nil {
+		collector.Timestamps = *c.CollectTimestamps
+	}
+
+	switch c.TargetInfo {
+	case "", targetInfoOff, targetInfoMetric, targetInfoLabels:
+	default:
+		return nil, fmt.Errorf(`invalid target_info "%s", expected "off", "metric" or "labels"`, c.TargetInfo)
+	}
+
+	if c.TargetInfo != "" {
+		collector.TargetInfo = c.TargetInfo
+	}
+
+	if err := validateLabels(c.Labels); err != nil {
+		return nil, err
+	}
+
+	if c.DialogLabel != nil {
+		if err := c.DialogLabel.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	for profile, values := range c.DialogProfiles {
+		if profile == "" {
+			return nil, errors.New("invalid dialog profile: empty name")
+		}
+
+		for _, value := range values {
+			if value == "" {
+				return nil, fmt.Errorf(`invalid value of dialog profile "%s": empty value`, profile)
+			}
+		}
+	}
+
+	if c.GracePeriod != nil {
+		if *c.GracePeriod < 0 {
+			return nil, fmt.Errorf("invalid grace_period: %s", *c.GracePeriod)
+		}
+
+		collector.GracePeriod = *c.GracePeriod
+	}
+
+	if c.SlowScrapeThreshold != nil {
+		collector.SlowScrapeThreshold = *c.SlowScrapeThreshold
+	}
+	if c.ProcfsPath != "" {
+		collector.ProcfsPath = c.ProcfsPath
+	}
+
+	if collector.HTableInclude, err = compileTableFilter(c.HTableInclude); err != nil {
+		return nil, err
+	}
+
+	if collector.HTableExclude, err = compileTableFilter(c.HTableExclude); err != nil {
+		return nil, err
+	}
+
+	if err := validateURINormalize(c.DispatcherURINormalize); err != nil {
+		return nil, err
+	}
+
+	collector.DispatcherURINormalize = c.DispatcherURINormalize
+
+	if c.RegisterProbe != nil {
+		if err := c.RegisterProbe.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.InviteProbe != nil {
+		if err := c.InviteProbe.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateDependencies(c.Dependencies); err != nil {
+		return nil, err
+	}
+
+	if err := validateProbeTargets(c.Targets); err != nil {
+		return nil, err
+	}
+
+	if c.DlgListMaxDialogs != nil {
+		collector.DlgListMaxDialogs = *c.DlgListMaxDialogs
+	}
+
+	collector.DialogLabel = c.DialogLabel
+	collector.DialogProfiles = c.DialogProfiles
+
+	if err := validateStatsGroups(c.StatsGroups); err != nil {
+		return nil, err
+	}
+
+	collector.StatsGroups = c.StatsGroups
+
+	switch c.ModStatsLevel {
+	case "", modStatsModule, modStatsFunction, modStatsLine:
+	default:
+		return nil, fmt.Errorf(`invalid mod_stats_level "%s", expected "module", "function" or "line"`, c.ModStatsLevel)
+	}
+
+	if c.ModStatsLevel != "" {
+		collector.ModStatsLevel = c.ModStatsLevel
+	}
+
+	collector.TargetName = c.TargetName
+	collector.Labels = c.Labels
+	collector.MethodIntervals = c.MethodIntervals
+
+	if c.DNSTTL != nil {
+		collector.DNSTTL = *c.DNSTTL
+	}
+
+	if c.CollectInterval != nil {
+		collector.Interval = *c.CollectInterval
+	}
+
+	return collector, nil
+}
+
+// LoadConfigFile reads and parses the configuration file at path, and merges the
+// files of its include directory, in lexical order. If the file has no methods, the methods of
+// the included files are kept apart, to be added to the methods of the flags.
+// It also returns a hash of the content of all the files.
+func LoadConfigFile(path string) (*Config, float64, error) {
+	config, content, err := parseConfigFile(path)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if config.IncludeDir == "" {
+		return config, hashBytes(content), nil
+	}
+
+	dir := config.IncludeDir
+
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+
+	config.IncludeDir = dir
+
+	// without this check, a missing directory would silently include no file
+	if info, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, fmt.Errorf("include_dir %q: no such directory", dir)
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("include_dir: %w", err)
+	} else if !info.IsDir() {
+		return nil, 0, fmt.Errorf("include_dir %q: not a directory", dir)
+	}
+
+	var files []string
+
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+
+	ownMethods := len(config.Methods) > 0
+
+	for _, file := range files {
+		snippet, b, err := parseConfigFile(file)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := config.merge(snippet); err != nil {
+			return nil, 0, fmt.Errorf("cannot merge config file %q: %w", file, err)
+		}
+
+		content = append(content, file...)
+		content = append(content, b...)
+	}
+
+	// a snippet adding a method would otherwise drop the default methods of the flags
+	if !ownMethods {
+		config.includedMethods = config.Methods
+		config.Methods = nil
+	}
+
+	return config, hashBytes(content), nil
+}
+
+// parseConfigFile reads and parses a single configuration file.
+func parseConfigFile(path string) (*Config, []byte, error) {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	config := Config{}
+
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, nil, fmt.Errorf("cannot parse config file %q: %w", path, err)
+	}
+
+	return &config, b, nil
+}
+
+// merge adds the values of an included file to c.
+// Lists are appended, and single values may be set by only one file.
+func (c *Config) merge(snippet *Config) error {
+	if snippet.IncludeDir != "" {
+		return errors.New("include_dir cannot be used in an included file")
+	}
+
+	if snippet.ScrapeURI != "" {
+		if c.ScrapeURI != "" && c.ScrapeURI != snippet.ScrapeURI {
+			return fmt.Errorf("scrape_uri is already set to %q", c.ScrapeURI)
+		}
+
+		c.ScrapeURI = snippet.ScrapeURI
+	}
+
+	if snippet.Timeout != nil {
+		if c.Timeout != nil && *c.Timeout != *snippet.Timeout {
+			return fmt.Errorf("timeout is already set to %s", *c.Timeout)
+		}
+
+		c.Timeout = snippet.Timeout
+	}
+
+	if snippet.DNSTTL != nil {
+		if c.DNSTTL != nil && *c.DNSTTL != *snippet.DNSTTL {
+			return fmt.Errorf("dns_ttl is already set to %s", *c.DNSTTL)
+		}
+
+		c.DNSTTL = snippet.DNSTTL
+	}
+
+	if snippet.BINRPCCookie != "" {
+		if c.BINRPCCookie != "" && c.BINRPCCookie != snippet.BINRPCCookie {
+			return fmt.Errorf("binrpc_cookie is already set to %q", c.BINRPCCookie)
+		}
+
+		c.BINRPCCookie = snippet.BINRPCCookie
+	}
+
+	if snippet.Pipeline != nil {
+		if c.Pipeline != nil && *c.Pipeline != *snippet.Pipeline {
+			return fmt.Errorf("pipeline is already set to %t", *c.Pipeline)
+		}
+
+		c.Pipeline = snippet.Pipeline
+	}
+
+	if snippet.PersistentConnection != nil {
+		if c.PersistentConnection != nil && *c.PersistentConnection != *snippet.PersistentConnection {
+			return fmt.Errorf("persistent_connection is already set to %t", *c.PersistentConnection)
+		}
+
+		c.PersistentConnection = snippet.PersistentConnection
+	}
+
+	if snippet.KeepaliveInterval != nil {
+		if c.KeepaliveInterval != nil && *c.KeepaliveInterval != *snippet.KeepaliveInterval {
+			return fmt.Errorf("keepalive_interval is already set to %s", *c.KeepaliveInterval)
+		}
+
+		c.KeepaliveInterval = snippet.KeepaliveInterval
+	}
+
+	if snippet.DomainInfo != nil {
+		if c.DomainInfo != nil && *c.DomainInfo != *snippet.DomainInfo {
+			return fmt.Errorf("domain_info is already set to %t", *c.DomainInfo)
+		}
+
+		c.DomainInfo = snippet.DomainInfo
+	}
+
+	if snippet.StringValues != nil {
+		if c.StringValues != nil && *c.StringValues != *snippet.StringValues {
+			return fmt.Errorf("string_values is already set to %t", *c.StringValues)
+		}
+
+		c.StringValues = snippet.StringValues
+	}
+
+	if snippet.CollectInterval != nil {
+		if c.CollectInterval != nil && *c.CollectInterval != *snippet.CollectInterval {
+			return fmt.Errorf("collect_interval is already set to %s", *c.CollectInterval)
+		}
+
+		c.CollectInterval = snippet.CollectInterval
+	}
+
+	if snippet.CollectTimestamps != nil {
+		if c.CollectTimestamps != nil && *c.CollectTimestamps != *snippet.CollectTimestamps {
+			return fmt.Errorf("collect_timestamps is already set to %t", *c.CollectTimestamps)
+		}
+
+		c.CollectTimestamps = snippet.CollectTimestamps
+	}
+
+	if snippet.GracePeriod != nil {
+		if c.GracePeriod != nil && *c.GracePeriod != *snippet.GracePeriod {
+			return fmt.Errorf("grace_period is already set to %s", *c.GracePeriod)
+		}
+
+		c.GracePeriod = snippet.GracePeriod
+	}
+
+	if snippet.SlowScrapeThreshold != nil {
+		if c.SlowScrapeThreshold != nil && *c.SlowScrapeThreshold != *snippet.SlowScrapeThreshold {
+			return fmt.Errorf("slow_scrape_threshold is already set to %s", *c.SlowScrapeThreshold)
+		}
+
+		c.SlowScrapeThreshold = snippet.SlowScrapeThreshold
+	}
+
+	if snippet.ProcfsPath != "" {
+		if c.ProcfsPath != "" && c.ProcfsPath != snippet.ProcfsPath {
+			return fmt.Errorf("procfs_path is already set to %q", c.ProcfsPath)
+		}
+
+		c.ProcfsPath = snippet.ProcfsPath
+	}
+
+	if snippet.HTableInclude != "" {
+		if c.HTableInclude != "" && c.HTableInclude != snippet.HTableInclude {
+			return fmt.Errorf("htable_include is already set to %q", c.HTableInclude)
+		}
+
+		c.HTableInclude = snippet.HTableInclude
+	}
+
+	if snippet.HTableExclude != "" {
+		if c.HTableExclude != "" && c.HTableExclude != snippet.HTableExclude {
+			return fmt.Errorf("htable_exclude is already set to %q", c.HTableExclude)
+		}
+
+		c.HTableExclude = snippet.HTableExclude
+	}
+
+	if snippet.DlgListMaxDialogs != nil {
+		if c.DlgListMaxDialogs != nil && *c.DlgListMaxDialogs != *snippet.DlgListMaxDialogs {
+			return fmt.Errorf("dlg_list_max_dialogs is already set to %d", *c.DlgListMaxDialogs)
+		}
+
+		c.DlgListMaxDialogs = snippet.DlgListMaxDialogs
+	}
+
+	if snippet.DialogLabel != nil {
+		if c.DialogLabel != nil {
+			return errors.New("dialog_label is already set")
+		}
+
+		c.DialogLabel = snippet.DialogLabel
+	}
+
+	if len(snippet.DispatcherURINormalize) > 0 {
+		if len(c.DispatcherURINormalize) > 0 && strings.Join(c.DispatcherURINormalize, ",") != strings.Join(snippet.DispatcherURINormalize, ",") {
+			return fmt.Errorf("dispatcher_uri_normalize is already set to %q", strings.Join(c.DispatcherURINormalize, ","))
+		}
+
+		c.DispatcherURINormalize = snippet.DispatcherURINormalize
+	}
+
+	if snippet.ModStatsLevel != "" {
+		if c.ModStatsLevel != "" && c.ModStatsLevel != snippet.ModStatsLevel {
+			return fmt.Errorf("mod_stats_level is already set to %q", c.ModStatsLevel)
+		}
+
+		c.ModStatsLevel = snippet.ModStatsLevel
+	}
+
+	if snippet.TargetInfo != "" {
+		if c.TargetInfo != "" && c.TargetInfo != snippet.TargetInfo {
+			return fmt.Errorf("target_info is already set to %q", c.TargetInfo)
+		}
+
+		c.TargetInfo = snippet.TargetInfo
+	}
+
+	if snippet.TargetName != "" {
+		if c.TargetName != "" && c.TargetName != snippet.TargetName {
+			return fmt.Errorf("target_name is already set to %q", c.TargetName)
+		}
+
+		c.TargetName = snippet.TargetName
+	}
+
+	if snippet.RegisterProbe != nil {
+		if c.RegisterProbe != nil {
+			return errors.New("register_probe is already set")
+		}
+
+		c.RegisterProbe = snippet.RegisterProbe
+	}
+
+	if snippet.InviteProbe != nil {
+		if c.InviteProbe != nil {
+			return errors.New("invite_probe is already set")
+		}
+
+		c.InviteProbe = snippet.InviteProbe
+	}
+
+	// duplicate names are rejected by validateDependencies
+	c.Dependencies = append(c.Dependencies, snippet.Dependencies...)
+
+	// duplicate names are rejected by validateProbeTargets
+	c.Targets = append(c.Targets, snippet.Targets...)
+
+	for method, interval := range snippet.MethodIntervals {
+		if current, found := c.MethodIntervals[method]; found && current != interval {
+			return fmt.Errorf(`interval of method "%s" is already set to %s`, method, current)
+		}
+
+		if c.MethodIntervals == nil {
+			c.MethodIntervals = make(map[string]time.Duration)
+		}
+
+		c.MethodIntervals[method] = interval
+	}
+
+	for profile, values := range snippet.DialogProfiles {
+		if _, found := c.DialogProfiles[profile]; found {
+			return fmt.Errorf(`dialog profile "%s" is already set`, profile)
+		}
+
+		if c.DialogProfiles == nil {
+			c.DialogProfiles = make(map[string][]string)
+		}
+
+		c.DialogProfiles[profile] = values
+	}
+
+	for _, group := range snippet.StatsGroups {
+		found := false
+
+		for _, g := range c.StatsGroups {
+			if g == group {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			c.StatsGroups = append(c.StatsGroups, group)
+		}
+	}
+
+	for name, value := range snippet.Labels {
+		if current, found := c.Labels[name]; found && current != value {
+			return fmt.Errorf(`label "%s" is already set to %q`, name, current)
+		}
+
+		if c.Labels == nil {
+			c.Labels = make(map[string]string)
+		}
+
+		c.Labels[name] = value
+	}
+
+	c.Methods = appendMethods(c.Methods, snippet.Methods)
+
+	return nil
+}
+
+// appendMethods returns a copy of methods followed by the methods of more that are not in methods.
+func appendMethods(methods []string, more []string) []string {
+	result := append([]string(nil), methods...)
+
+	for _, method := range more {
+		found := false
+
+		for _, m := range result {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			result = append(result, method)
+		}
+	}
+
+	return result
+}
+
+// hashBytes returns the first 8 bytes of the sha256 of b, as a float64 usable as a metric value.
+func hashBytes(b []byte) float64 {
+	sum := sha256.Sum256(b)
+
+	return float64(binary.BigEndian.Uint64(sum[:8]))
+}
+
+// Collector returns a new Collector created from the configuration.
+// Subsequent calls to Reload update this Collector in place.
+func (l *ConfigLoader) Collector() (*Collector, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	config, hash, err := l.load()
+
+	if err != nil {
+		l.lastReloadSuccessful.Set(0)
+		return nil, err
+	}
+
+	c, err := config.NewCollector()
+
+	if err != nil {
+		l.lastReloadSuccessful.Set(0)
+		return nil, err
+	}
+
+	l.collector = c
+	l.success(config, hash)
+
+	return c, nil
+}
+
+// Reload reads the configuration file again and applies it to the Collector.
+// On error, the previous configuration is kept.
+func (l *ConfigLoader) Reload() error {
+	return l.reload(false)
+}
+
+// ReloadIfChanged is like Reload, but does nothing if the content of the
+// configuration files did not change since the last successful load.
+func (l *ConfigLoader) ReloadIfChanged() error {
+	return l.reload(true)
+}
+
+func (l *ConfigLoader) reload(ifChanged bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	config, hash, err := l.load()
+
+	if err == nil && ifChanged && hash == l.hash {
+		return nil
+	}
+
+	var c *Collector
+
+	if err == nil {
+		c, err = config.NewCollector()
+	}
+
+	if err != nil {
+		l.lastReloadSuccessful.Set(0)
+		return err
+	}
+
+	l.collector.Reconfigure(c)
+	l.success(config, hash)
+	log.Println("[info] configuration reloaded")
+
+	return nil
+}
+
+// Config returns the current configuration.
+func (l *ConfigLoader) Config() *Config {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.config
+}
+
+// Reloaded returns a channel receiving a value after successful loads of the configuration, however
+// they were triggered (signal, /-/reload, the API or the watcher), so that WatchDirs watches the
+// include_dir of the new configuration. Loads in a row may be notified once.
+func (l *ConfigLoader) Reloaded() <-chan struct{} {
+	return l.reloaded
+}
+
+// WatchedDirs returns the directories containing the configuration files.
+func (l *ConfigLoader) WatchedDirs() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.File == "" {
+		return nil
+	}
+
+	dirs := []string{filepath.Dir(l.File)}
+
+	if l.config != nil && l.config.IncludeDir != "" {
+		dirs = append(dirs, l.config.IncludeDir)
+	}
+
+	return dirs
+}
+
+// load returns the configuration file merged with the defaults, and the override of the API if any.
+func (l *ConfigLoader) load() (*Config, float64, error) {
+	config := l.Defaults
+
+	var hash float64
+
+	if l.File != "" {
+		file, h, err := LoadConfigFile(l.File)
+
+		if err != nil {
+			return nil, 0, err
+		}
+
+		config.overrideWith(file)
+		config.Methods = appendMethods(config.Methods, file.includedMethods)
+
+		// probes are only configured in the file, or with the API, and targets in the file, or with /api/v1/targets
+		config.RegisterProbe = file.RegisterProbe
+		config.InviteProbe = file.InviteProbe
+		config.Dependencies = file.Dependencies
+		config.Targets = file.Targets
+		config.IncludeDir = file.IncludeDir
+
+		hash = h
+	}
+
+	// targets of the API, unless the file has a target of the same name
+	config.Targets = append([]ProbeTargetConfig(nil), config.Targets...)
+
+	for _, t := range l.targets {
+		if findProbeTarget(config.Targets, t.Name) == nil {
+			t.api = true
+			config.Targets = append(config.Targets, t)
+		}
+	}
+
+	if o := l.override; o != nil {
+		config.overrideWith(o)
+
+		if o.RegisterProbe != nil {
+			config.RegisterProbe = o.RegisterProbe
+		}
+		if o.InviteProbe != nil {
+			config.InviteProbe = o.InviteProbe
+		}
+		if o.Dependencies != nil {
+			config.Dependencies = o.Dependencies
+		}
+	}
+
+	return &config, hash, nil
+}
+
+// overrideWith sets the values of c that are set in o, except probes and include_dir.
+func (c *Config) overrideWith(o *Config) {
+	if o.ScrapeURI != "" {
+		c.ScrapeURI = o.ScrapeURI
+	}
+	if len(o.Methods) > 0 {
+		c.Methods = o.Methods
+	}
+	if o.Timeout != nil {
+		c.Timeout = o.Timeout
+	}
+	if o.DNSTTL != nil {
+		c.DNSTTL = o.DNSTTL
+	}
+	if o.BINRPCCookie != "" {
+		c.BINRPCCookie = o.BINRPCCookie
+	}
+	if o.Pipeline != nil {
+		c.Pipeline = o.Pipeline
+	}
+	if o.PersistentConnection != nil {
+		c.PersistentConnection = o.PersistentConnection
+	}
+	if o.KeepaliveInterval != nil {
+		c.KeepaliveInterval = o.KeepaliveInterval
+	}
+	if o.DomainInfo != nil {
+		c.DomainInfo = o.DomainInfo
+	}
+	if o.StringValues != nil {
+		c.StringValues = o.StringValues
+	}
+	if o.CollectInterval != nil {
+		c.CollectInterval = o.CollectInterval
+	}
+	if len(o.MethodIntervals) > 0 {
+		c.MethodIntervals = o.MethodIntervals
+	}
+	if o.CollectTimestamps != nil {
+		c.CollectTimestamps = o.CollectTimestamps
+	}
+	if o.GracePeriod != nil {
+		c.GracePeriod = o.GracePeriod
+	}
+	if o.SlowScrapeThreshold != nil {
+		c.SlowScrapeThreshold = o.SlowScrapeThreshold
+	}
+	if o.ProcfsPath != "" {
+		c.ProcfsPath = o.ProcfsPath
+	}
+	if len(o.DispatcherURINormalize) > 0 {
+		c.DispatcherURINormalize = o.DispatcherURINormalize
+	}
+	if o.HTableInclude != "" {
+		c.HTableInclude = o.HTableInclude
+	}
+	if o.HTableExclude != "" {
+		c.HTableExclude = o.HTableExclude
+	}
+	if o.DlgListMaxDialogs != nil {
+		c.DlgListMaxDialogs = o.DlgListMaxDialogs
+	}
+	if o.DialogLabel != nil {
+		c.DialogLabel = o.DialogLabel
+	}
+	if len(o.DialogProfiles) > 0 {
+		c.DialogProfiles = o.DialogProfiles
+	}
+	if len(o.StatsGroups) > 0 {
+		c.StatsGroups = o.StatsGroups
+	}
+	if o.ModStatsLevel != "" {
+		c.ModStatsLevel = o.ModStatsLevel
+	}
+	if o.TargetInfo != "" {
+		c.TargetInfo = o.TargetInfo
+	}
+	if o.TargetName != "" {
+		c.TargetName = o.TargetName
+	}
+	if len(o.Labels) > 0 {
+		c.Labels = o.Labels
+	}
+}
+
+// success records config and updates the reload metrics after a successful (re)load.
+func (l *ConfigLoader) success(config *Config, hash float64) {
+	// the clients of the previous targets are replaced
+	if l.config != nil {
+		for _, previous := range l.config.Targets {
+			if t := findProbeTarget(config.Targets, previous.Name); previous.client != nil && (t == nil || t.client != previous.client) {
+				previous.client.CloseIdleConnections()
+			}
+		}
+	}
+
+	l.config = config
+	l.hash = hash
+	l.lastReloadSuccessful.Set(1)
+	l.lastReloadTime.SetToCurrentTime()
+	l.configHash.Set(hash)
+
+	select {
+	case l.reloaded <- struct{}{}:
+	default:
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (l *ConfigLoader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.lastReloadSuccessful.Desc()
+	ch <- l.lastReloadTime.Desc()
+	ch <- l.configHash.Desc()
+}
+
+// Collect implements prometheus.Collector.
+func (l *ConfigLoader) Collect(ch chan<- prometheus.Metric) {
+	ch <- l.lastReloadSuccessful
+	ch <- l.lastReloadTime
+	ch <- l.configHash
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeConfig writes the files of a configuration in a temporary directory, and returns the path of
+// the first one, "kamailio_exporter.yml".
+func writeConfig(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return filepath.Join(dir, "kamailio_exporter.yml")
+}
+
+func TestConfigZeroOverridesFlags(t *testing.T) {
+	timeout := 5 * time.Second
+	interval := 15 * time.Second
+	grace := time.Minute
+	maxDialogs := 1000
+	timestamps := true
+
+	path := writeConfig(t, map[string]string{
+		"kamailio_exporter.yml": "collect_interval: 0s\ngrace_period: 0s\ndlg_list_max_dialogs: 0\ncollect_timestamps: false\n",
+	})
+
+	loader := NewConfigLoader(path, Config{
+		ScrapeURI:         "unix:/var/run/kamailio/kamailio_ctl",
+		Methods:           []string{"tm.stats"},
+		Timeout:           &timeout,
+		CollectInterval:   &interval,
+		GracePeriod:       &grace,
+		DlgListMaxDialogs: &maxDialogs,
+		CollectTimestamps: &timestamps,
+	})
+
+	config, _, err := loader.load()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := config.NewCollector()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Interval != 0 || c.GracePeriod != 0 || c.DlgListMaxDialogs != 0 || c.Timestamps {
+		t.Errorf("got collect_interval %s, grace_period %s, dlg_list_max_dialogs %d, collect_timestamps %t, want zero values",
+			c.Interval, c.GracePeriod, c.DlgListMaxDialogs, c.Timestamps)
+	}
+
+	if c.Timeout != timeout {
+		t.Errorf("got timeout %s, want the flag %s", c.Timeout, timeout)
+	}
+}
+
+func TestConfigMergeZero(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"kamailio_exporter.yml": "grace_period: 1m\ninclude_dir: conf.d\n",
+		"conf.d/zero.yml":       "grace_period: 0s\n",
+	})
+
+	_, _, err := LoadConfigFile(path)
+
+	if err == nil || !strings.Contains(err.Error(), "grace_period is already set to 1m0s") {
+		t.Errorf("got error %v, want grace_period is already set", err)
+	}
+}
+
+func TestConfigInvalidTimeout(t *testing.T) {
+	timeout := time.Duration(0)
+	config := Config{ScrapeURI: "unix:/var/run/kamailio/kamailio_ctl", Methods: []string{"tm.stats"}, Timeout: &timeout}
+
+	if _, err := config.NewCollector(); err == nil {
+		t.Error("timeout 0: no error")
+	}
+}
+
+func TestConfigMissingIncludeDir(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"kamailio_exporter.yml": "include_dir: conf.d\n",
+	})
+
+	_, _, err := LoadConfigFile(path)
+
+	if err == nil || !strings.Contains(err.Error(), "no such directory") {
+		t.Errorf("got error %v, want no such directory", err)
+	}
+}
+
+func TestConfigReloaded(t *testing.T) {
+	path := writeConfig(t, map[string]string{
+		"kamailio_exporter.yml": "scrape_uri: unix:/var/run/kamailio/kamailio_ctl\nmethods: [core.uptime]\ntimeout: 5s\n",
+	})
+
+	loader := NewConfigLoader(path, Config{})
+
+	if _, err := loader.Collector(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-loader.Reloaded()
+
+	// like SIGHUP and /-/reload
+	if err := loader.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-loader.Reloaded():
+	default:
+		t.Error("reload not notified")
+	}
+}
+
+func TestWatchDirsReloaded(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+
+	var mutex sync.Mutex
+	dirs := []string{first}
+	rearmed := make(chan struct{}, 1)
+
+	reloaded := make(chan struct{})
+	reloads := make(chan struct{}, 10)
+
+	err := WatchDirs(func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if dirs[0] == second {
+			select {
+			case rearmed <- struct{}{}:
+			default:
+			}
+		}
+
+		return dirs
+	}, reloaded, 10*time.Millisecond, func() { reloads <- struct{}{} })
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a reload that was not triggered by the watcher changes the include_dir
+	mutex.Lock()
+	dirs = []string{second}
+	mutex.Unlock()
+
+	reloaded <- struct{}{}
+
+	select {
+	case <-rearmed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("directories not watched again after the reload")
+	}
+
+	if err := os.WriteFile(filepath.Join(second, "snippet.yml"), []byte("methods: [tm.stats]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-reloads:
+	case <-time.After(5 * time.Second):
+		t.Error("change of the new directory not watched")
+	}
+}
+
+func TestConfigIncludedMethods(t *testing.T) {
+	timeout := 5 * time.Second
+	defaults := Config{ScrapeURI: "unix:/var/run/kamailio/kamailio_ctl", Methods: []string{"tm.stats", "sl.stats"}, Timeout: &timeout}
+
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"added to the flags", "include_dir: conf.d\n", "tm.stats,sl.stats,dlg.list"},
+		{"added to the file", "include_dir: conf.d\nmethods: [core.uptime]\n", "core.uptime,dlg.list,tm.stats"},
+	}
+
+	for _, test := range tests {
+		path := writeConfig(t, map[string]string{
+			"kamailio_exporter.yml": test.file,
+			"conf.d/dialogs.yml":    "methods: [dlg.list, tm.stats]\n",
+		})
+
+		loader := NewConfigLoader(path, defaults)
+
+		if _, err := loader.Collector(); err != nil {
+			t.Fatal(err)
+		}
+
+		if methods := strings.Join(loader.Config().Methods, ","); methods != test.want {
+			t.Errorf("%s: got methods %s, want %s", test.name, methods, test.want)
+		}
+	}
+
+	if methods := strings.Join(defaults.Methods, ","); methods != "tm.stats,sl.stats" {
+		t.Errorf("methods of the flags changed: %s", methods)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxConfigSize bounds the size of the configurations sent to /api/v1/config.
+const maxConfigSize = 1 << 20
+
+// Apply validates the configuration with override applied over the file, and applies it to the
+// Collector. On error, the previous configuration is kept. The override is kept across reloads of
+// the file, until it is replaced, or removed with a nil override.
+func (l *ConfigLoader) Apply(override *Config) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if override != nil && override.IncludeDir != "" {
+		return errors.New("include_dir can only be set in the configuration file")
+	}
+
+	// targets hold credentials, and their URIs are not restricted
+	if override != nil && override.Targets != nil {
+		return errors.New("targets can only be set in the configuration file, or with /api/v1/targets")
+	}
+
+	// exec: and fifo: URIs would let the callers of the API run commands and create files
+	if override != nil && override.ScrapeURI != "" {
+		if err := validateProbeTarget(override.ScrapeURI); err != nil {
+			return fmt.Errorf("scrape_uri: %w", err)
+		}
+	}
+
+	previous := l.override
+	l.override = override
+
+	if err := l.apply(); err != nil {
+		l.override = previous
+		return err
+	}
+
+	return nil
+}
+
+// apply loads the configuration and applies it to the Collector. l.mutex must be held.
+func (l *ConfigLoader) apply() error {
+	config, hash, err := l.load()
+
+	var c *Collector
+
+	if err == nil {
+		c, err = config.NewCollector()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	l.collector.Reconfigure(c)
+	l.success(config, hash)
+
+	return nil
+}
+
+// AddTarget adds target to the targets of /probe, or replaces the target of the API with the same
+// name, and applies the configuration. Targets of the API are kept across reloads of the file, but
+// not across restarts. Since they come from the request, their URIs are restricted like the ones
+// of /probe, and they cannot read local files.
+func (l *ConfigLoader) AddTarget(target ProbeTargetConfig) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := validateProbeTarget(target.URI); err != nil {
+		return err
+	}
+
+	if target.TLSConfig != nil {
+		return errors.New("tls_config can only be set in the configuration file")
+	}
+
+	if target.BasicAuth != nil && target.BasicAuth.PasswordFile != "" {
+		return errors.New("password_file can only be set in the configuration file")
+	}
+
+	if t := l.findTarget(target.Name); t != nil && !t.api {
+		return fmt.Errorf(`target "%s" is set in the configuration file`, target.Name)
+	}
+
+	previous := l.targets
+	l.targets = make([]ProbeTargetConfig, 0, len(previous)+1)
+
+	for _, t := range previous {
+		if t.Name != target.Name {
+			l.targets = append(l.targets, t)
+		}
+	}
+
+	l.targets = append(l.targets, target)
+
+	if err := l.apply(); err != nil {
+		l.targets = previous
+		return err
+	}
+
+	return nil
+}
+
+// RemoveTarget removes the target of the API named name, and applies the configuration.
+func (l *ConfigLoader) RemoveTarget(name string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if findProbeTarget(l.targets, name) == nil {
+		return fmt.Errorf(`%w: "%s"`, errUnknownTarget, name)
+	}
+
+	previous := l.targets
+	l.targets = make([]ProbeTargetConfig, 0, len(previous))
+
+	for _, t := range previous {
+		if t.Name != name {
+			l.targets = append(l.targets, t)
+		}
+	}
+
+	if err := l.apply(); err != nil {
+		l.targets = previous
+		return err
+	}
+
+	return nil
+}
+
+// findTarget returns the target of the configuration named name, or nil. l.mutex must be held.
+func (l *ConfigLoader) findTarget(name string) *ProbeTargetConfig {
+	if l.config == nil {
+		return nil
+	}
+
+	return findProbeTarget(l.config.Targets, name)
+}
+
+// errUnknownTarget is returned when removing a target that was not added with the API.
+var errUnknownTarget = errors.New("no target added with the API")
+
+// Targets returns the targets of /probe, from the file and from the API.
+func (l *ConfigLoader) Targets() []configuredTarget {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.config == nil {
+		return []configuredTarget{}
+	}
+
+	targets := make([]configuredTarget, 0, len(l.config.Targets))
+
+	for _, t := range l.config.Targets {
+		target := configuredTarget{Name: t.Name, URI: redactURIs(t.URI), Methods: t.Methods, Labels: t.Labels, Source: "file"}
+
+		if t.api {
+			target.Source = "api"
+		}
+
+		if target.Methods == nil {
+			target.Methods = []string{}
+		}
+
+		if target.Labels == nil {
+			target.Labels = map[string]string{}
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets
+}
+
+// configHandler returns a handler of the configuration: GET returns the effective configuration in YAML,
+// PUT applies the configuration of the body (YAML or JSON, with the keys of the configuration file) over
+// the file, and DELETE removes it. Changes are logged for auditing. It is only served with an admin token.
+//
+//	curl -X PUT --data-binary '{"methods": ["tm.stats", "dispatcher.list"], "labels": {"role": "edge"}}' http://localhost:9494/api/v1/config
+func configHandler(loader *ConfigLoader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			b, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("cannot read configuration: %w", err))
+				return
+			}
+
+			var override Config
+
+			// JSON is valid YAML
+			if err := yaml.UnmarshalStrict(b, &override); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid configuration: %w", err))
+				return
+			}
+
+			if err := loader.Apply(&override); err != nil {
+				log.Printf("[audit] configuration update from %s rejected: %s", r.RemoteAddr, err)
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+
+			log.Printf("[audit] configuration updated from %s", r.RemoteAddr)
+		case http.MethodDelete:
+			if err := loader.Apply(nil); err != nil {
+				log.Printf("[audit] configuration reset from %s failed: %s", r.RemoteAddr, err)
+				writeJSONError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			log.Printf("[audit] configuration reset to the file from %s", r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("only GET, PUT or DELETE requests allowed"))
+			return
+		}
+
+		b, err := marshalConfig(loader.Config())
+
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(b)
+	})
+}
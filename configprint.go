@@ -0,0 +1,315 @@
+package main
+
+import (
+	"io"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// exampleConfig is a commented example of the configuration file, printed by the example-config command.
+// Every key is optional: unset keys fall back on the command line flags.
+const exampleConfig = `# Configuration file of kamailio_exporter, loaded with --config.file.
+# Every key is optional: unset keys fall back on the command line flags.
+# It is reloaded on SIGHUP, on /-/reload with --web.enable-lifecycle, or when
+# it changes with --config.watch.
+
+# URI of the ctl module of kamailio (--kamailio.scrape-uri). Several URIs can
+# be given, separated by commas, to fall back on the next ones.
+scrape_uri: "tcp://localhost:2049"
+
+# Methods to call on each scrape (--kamailio.methods).
+methods:
+  - tm.stats
+  - sl.stats
+  - core.shmmem
+  - dispatcher.list
+
+# Timeout of a whole scrape (--kamailio.timeout).
+timeout: 5s
+
+# Cache duration of the addresses of tcp:// URIs, 0 to resolve them on
+# every scrape (--kamailio.dns-ttl).
+dns_ttl: 30s
+
+# Size of the cookie of BINRPC requests: "fixed" or "compact"
+# (--kamailio.binrpc-cookie).
+binrpc_cookie: fixed
+
+# Write all the requests of a scrape before reading the responses
+# (--kamailio.pipeline).
+pipeline: true
+
+# Keep the connection to kamailio open between scrapes, and check it with
+# core.echo when idle (--kamailio.persistent-connection and
+# --kamailio.keepalive-interval).
+persistent_connection: true
+keepalive_interval: 30s
+
+# Collect in the background at this interval, and serve the last values on
+# /metrics (--kamailio.collect-interval). 0 scrapes on each request.
+collect_interval: 15s
+
+# Per-method intervals of background collection
+# (--kamailio.method-intervals).
+method_intervals:
+  core.shmmem: 5s
+  dispatcher.list: 60s
+
+# Export the samples of background collection with the time of their
+# collection (--kamailio.collect-timestamps).
+collect_timestamps: false
+
+# Serve the last successful values, with kamailio_data_stale set to 1, when
+# scrapes fail for less than this period (--kamailio.grace-period).
+grace_period: 30s
+
+# Log the scrapes lasting longer than this duration
+# (--kamailio.slow-scrape-threshold).
+slow_scrape_threshold: 2s
+
+# Mount point of the proc filesystem of the host of kamailio
+# (--kamailio.procfs-path).
+procfs_path: /host/proc
+
+# Regexes of the tables exported by htable.stats (--kamailio.htable-include
+# and --kamailio.htable-exclude).
+htable_exclude: "tmp_.*"
+
+# Skip dlg.list when there are more active dialogs
+# (--kamailio.dlg-list-max-dialogs).
+dlg_list_max_dialogs: 5000
+
+# Count the active dialogs by a label extracted from their URIs
+# (--kamailio.dialog-label and --kamailio.dialog-label-max-values).
+dialog_label:
+  name: to_domain
+  field: to_uri # or from_uri
+  regex: "@([^;>:]+)"
+  max_values: 50
+
+# Dialog profiles of dlg.profile_get_size, with the values to count for
+# profiles with values (--kamailio.dialog-profiles), exported as
+# kamailio_dlg_profile_size{profile,value}.
+dialog_profiles:
+  trunk: [carrier-a, carrier-b]
+  inbound: []
+
+# Statistics groups of stats.fetch, "all" for every statistic
+# (--kamailio.stats-groups).
+stats_groups:
+  - registrar
+  - usrloc
+  - shmem
+
+# Level of detail of the shared memory of mod.stats: "module", "function" or
+# "line" (--kamailio.mod-stats-level).
+mod_stats_level: function
+
+# Normalization of the URIs of dispatcher targets
+# (--kamailio.dispatcher-uri-normalize).
+dispatcher_uri_normalize:
+  - strip-params
+  - lowercase-host
+
+# Export an info series per domain of domain.dump (--kamailio.domain-info).
+domain_info: true
+
+# Export the string values of the responses by string_info metrics
+# (--kamailio.string-values).
+string_values: false
+
+# Information about the target: "off", "metric" or "labels"
+# (--kamailio.target-info).
+target_info: labels
+
+# Name of the target, e.g. in notifications (--kamailio.target-name).
+target_name: proxy-1
+
+# Labels added to every kamailio metric (--kamailio.labels).
+labels:
+  datacenter: par1
+  role: edge
+
+# Synthetic REGISTER probe, run on each scrape of /metrics.
+register_probe:
+  server: "10.0.0.1:5060"
+  domain: example.com
+  user: probe
+  password_file: /etc/kamailio_exporter/probe_password
+  expires: 60
+  timeout: 5s
+
+# Synthetic INVITE probe, run on each scrape of /metrics.
+invite_probe:
+  server: "10.0.0.1:5060"
+  domain: example.com
+  user: probe
+  password_file: /etc/kamailio_exporter/probe_password
+  destination: "sip:echo@example.com"
+
+# Probes of the backends of kamailio, run on each scrape of /metrics: "tcp",
+# "mysql", "postgres" or "redis".
+dependencies:
+  - name: usrloc-db
+    type: mysql
+    address: "10.0.0.5:3306"
+  - name: topos-redis
+    type: redis
+    address: "127.0.0.1:6379"
+    timeout: 2s
+
+# Instances scraped with /probe?target=<name> (--web.enable-probe) or
+# /metrics/<name> (--web.enable-target-metrics), with their own credentials,
+# TLS settings for the jsonrpcs module, methods and labels.
+targets:
+  - name: tenant-a-sbc
+    uri: "https://sbc.tenant-a.example.net:5061/RPC"
+    basic_auth:
+      username: exporter
+      password_file: /etc/kamailio_exporter/tenant-a_password
+    tls_config:
+      ca_file: /etc/kamailio_exporter/tenant-a_ca.pem
+    methods: [tm.stats, sl.stats, dispatcher.list, tls.info]
+  - name: tenant-b-registrar
+    uri: "tcp://10.2.0.1:2049"
+    methods: [tm.stats, sl.stats, ul.dump, dmq.list_nodes]
+    labels:
+      role: registrar
+
+# Directory of *.yml and *.yaml files merged into this file, relative to it. It
+# must exist.
+include_dir: conf.d
+`
+
+// WriteExampleConfig writes the commented example of the configuration file to w.
+func WriteExampleConfig(w io.Writer) error {
+	_, err := io.WriteString(w, exampleConfig)
+
+	return err
+}
+
+// WriteEffectiveConfig loads the configuration file merged with the flags, checks it,
+// and writes it to w in YAML. Passwords are redacted.
+func (l *ConfigLoader) WriteEffectiveConfig(w io.Writer) error {
+	l.mutex.Lock()
+	config, _, err := l.load()
+	l.mutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// sets the default values, such as the timeouts of probes
+	if _, err := config.NewCollector(); err != nil {
+		return err
+	}
+
+	b, err := marshalConfig(config)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+
+	return err
+}
+
+// marshalConfig returns config in YAML. Passwords are redacted.
+func marshalConfig(config *Config) ([]byte, error) {
+	return yaml.Marshal(configYAML(reflect.ValueOf(*config)))
+}
+
+// configYAML returns v in a form marshaled like the configuration file is parsed:
+// keys named after the yaml tags, durations as strings, and unset values omitted.
+func configYAML(v reflect.Value) interface{} {
+	if d, ok := v.Interface().(time.Duration); ok {
+		return d.String()
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		return configYAML(v.Elem())
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+
+		for i := range items {
+			items[i] = configYAML(v.Index(i))
+		}
+
+		return items
+	case reflect.Map:
+		items := make(map[string]interface{}, v.Len())
+
+		for _, key := range v.MapKeys() {
+			items[key.String()] = configYAML(v.MapIndex(key))
+		}
+
+		return items
+	case reflect.Struct:
+		return configYAMLFields(v)
+	}
+
+	return v.Interface()
+}
+
+// redactURIs returns the comma-separated URIs of uris without the passwords of their user information,
+// like the logs and labels of the collector.
+func redactURIs(uris string) string {
+	redacted := strings.Split(uris, ",")
+
+	for i, uri := range redacted {
+		if u, err := url.Parse(uri); err == nil {
+			redacted[i] = u.Redacted()
+		}
+	}
+
+	return strings.Join(redacted, ",")
+}
+
+// configYAMLFields returns the fields of the struct v, in order.
+func configYAMLFields(v reflect.Value) yaml.MapSlice {
+	var fields yaml.MapSlice
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+
+		if field.PkgPath != "" || name == "-" {
+			// unexported
+			continue
+		}
+
+		value := v.Field(i)
+
+		if options == "inline" {
+			fields = append(fields, configYAMLFields(value)...)
+			continue
+		}
+
+		switch value.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			if value.IsNil() {
+				continue
+			}
+		}
+
+		if name == "password" && value.String() != "" {
+			fields = append(fields, yaml.MapItem{Key: name, Value: "<secret>"})
+			continue
+		}
+
+		if name == "scrape_uri" || name == "uri" {
+			fields = append(fields, yaml.MapItem{Key: name, Value: redactURIs(value.String())})
+			continue
+		}
+
+		fields = append(fields, yaml.MapItem{Key: name, Value: configYAML(value)})
+	}
+
+	return fields
+}
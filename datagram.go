@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// maxDatagramSize is the maximum size of a BINRPC reply over a datagram socket.
+const maxDatagramSize = 65535
+
+// datagramSockets numbers the local sockets of unixgram connections.
+var datagramSockets uint64
+
+// datagramConn adapts a datagram connection (unixgram or udp) to the readers of responses,
+// which read a stream: every datagram is a whole BINRPC packet, and is buffered entirely,
+// since the part of a datagram that does not fit in the buffer of a read is lost.
+type datagramConn struct {
+	net.Conn
+
+	local   string // path of the local unixgram socket, removed on Close
+	buf     []byte
+	pending []byte // rest of the last datagram
+}
+
+// Read implements io.Reader.
+func (c *datagramConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.buf == nil {
+			c.buf = make([]byte, maxDatagramSize)
+		}
+
+		n, err := c.Conn.Read(c.buf)
+
+		if err != nil {
+			return 0, err
+		}
+
+		c.pending = c.buf[:n]
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+
+	return n, nil
+}
+
+// Close implements net.Conn.
+func (c *datagramConn) Close() error {
+	err := c.Conn.Close()
+
+	if c.local != "" {
+		os.Remove(c.local)
+	}
+
+	return err
+}
+
+// dialDatagram connects to address over network, "unixgram" or "udp".
+// Unlike stream sockets, unixgram sockets need a local address for kamailio to reply to,
+// which is created in the temporary directory, like kamcmd does.
+func dialDatagram(ctx context.Context, network string, address string) (net.Conn, error) {
+	if network == "udp" {
+		dialer := net.Dialer{}
+
+		conn, err := dialer.DialContext(ctx, network, address)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &datagramConn{Conn: conn}, nil
+	}
+
+	local := filepath.Join(os.TempDir(), fmt.Sprintf("kamailio_exporter_%d_%d.sock", os.Getpid(), atomic.AddUint64(&datagramSockets, 1)))
+
+	// a socket left by a crashed process with the same pid
+	os.Remove(local)
+
+	conn, err := net.DialUnix(network, &net.UnixAddr{Name: local, Net: network}, &net.UnixAddr{Name: address, Net: network})
+
+	if err != nil {
+		os.Remove(local)
+		return nil, err
+	}
+
+	return &datagramConn{Conn: conn, local: local}, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// debugRPCHandler returns a handler that calls the method given in the "method" query parameter
+// and returns the decoded records as JSON. Only implemented methods can be called.
+func debugRPCHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := r.URL.Query().Get("method")
+		found := false
+
+		for _, m := range availableMethods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			http.Error(w, `missing or invalid "method" parameter`, http.StatusBadRequest)
+			return
+		}
+
+		records, err := c.Call(method)
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		values := make([]any, 0, len(records))
+
+		for _, record := range records {
+			values = append(values, recordToJSON(record))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(values)
+	})
+}
+
+// recordToJSON converts record to a value that can be marshaled to JSON.
+// Structs may contain the same key several times, so they are converted to a list of single-key objects.
+func recordToJSON(record binrpc.Record) any {
+	items, err := record.StructItems()
+
+	if err != nil {
+		return record.Value
+	}
+
+	list := make([]map[string]any, 0, len(items))
+
+	for _, item := range items {
+		list = append(list, map[string]any{item.Key: recordToJSON(item.Value)})
+	}
+
+	return list
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> corex.debug
+{
+	IDX: 0
+	PID: 20541
+	DEBUG: 2
+}
+{
+	IDX: 1
+	PID: 20542
+	DEBUG: 4
+}
+*/
+
+// scrapeDebugLevels passes the debug level of each process of kamailio, by rank, to fn, so that a debug level
+// raised in production and forgotten is caught. Levels are those of the core: 2 is info, 3 is debug.
+func (c *Collector) scrapeDebugLevels(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	return c.streamBINRPC(ctx, "corex.debug", func(d *rpcDecoder) error {
+		return streamStructs(d, "corex.debug", func(fields map[string]binrpc.Record) error {
+			if _, found := fields["DEBUG"]; !found {
+				return nil
+			}
+
+			return fn("level", MetricValue{
+				Value:  float64(intField(fields, "DEBUG")),
+				Labels: map[string]string{"rank": strconv.Itoa(intField(fields, "IDX"))},
+			})
+		})
+	})
+}
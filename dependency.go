@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Types of dependency probes. Database probes check that the server speaks its protocol,
+// without authenticating, so that no credentials are needed.
+const (
+	dependencyTCP      = "tcp"      // the connection is accepted
+	dependencyMySQL    = "mysql"    // the server sends its handshake
+	dependencyPostgres = "postgres" // the server answers an SSL request
+	dependencyRedis    = "redis"    // the server answers PING
+)
+
+// DependencyProbeConfig is the configuration of a probe of a backend of kamailio, such as its database.
+type DependencyProbeConfig struct {
+	Name    string        `yaml:"name"`    // exported as the dependency label
+	Type    string        `yaml:"type"`    // "tcp", "mysql", "postgres" or "redis"
+	Address string        `yaml:"address"` // "host:port"
+	Timeout time.Duration `yaml:"timeout"` // 5s if not set
+}
+
+// validate checks c and sets its default values.
+func (c *DependencyProbeConfig) validate() error {
+	if c.Name == "" || c.Address == "" {
+		return errors.New("dependencies: name and address are required")
+	}
+
+	switch c.Type {
+	case dependencyTCP, dependencyMySQL, dependencyPostgres, dependencyRedis:
+	default:
+		return fmt.Errorf(`dependencies: invalid type "%s" of "%s", expected "tcp", "mysql", "postgres" or "redis"`, c.Type, c.Name)
+	}
+
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf(`dependencies: invalid address of "%s": %w`, c.Name, err)
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second
+	}
+
+	return nil
+}
+
+// validateDependencies checks dependencies, whose names must be unique.
+func validateDependencies(dependencies []DependencyProbeConfig) error {
+	names := make(map[string]bool)
+
+	for i := range dependencies {
+		if err := dependencies[i].validate(); err != nil {
+			return err
+		}
+
+		if names[dependencies[i].Name] {
+			return fmt.Errorf(`dependencies: duplicate name "%s"`, dependencies[i].Name)
+		}
+
+		names[dependencies[i].Name] = true
+	}
+
+	return nil
+}
+
+// probeDependency connects to the dependency of config, and checks that it speaks its protocol.
+func probeDependency(config DependencyProbeConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", config.Address)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	switch config.Type {
+	case dependencyMySQL:
+		return probeMySQL(conn)
+	case dependencyPostgres:
+		return probePostgres(conn)
+	case dependencyRedis:
+		return probeRedis(conn)
+	}
+
+	return nil
+}
+
+// probeMySQL reads the initial handshake packet of a MySQL server.
+// Errors, such as too many connections, are sent instead of the handshake.
+func probeMySQL(conn net.Conn) error {
+	header := make([]byte, 4) // payload length on 3 bytes, sequence ID
+
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return err
+	}
+
+	if length == 0 {
+		return errors.New("mysql: empty handshake")
+	}
+
+	switch payload[0] {
+	case 0x0a: // protocol version 10
+		return nil
+	case 0xff:
+		// error code on 2 bytes, then the message
+		if length > 3 {
+			return fmt.Errorf("mysql: %s", payload[3:])
+		}
+	}
+
+	return fmt.Errorf("mysql: unexpected handshake 0x%02x", payload[0])
+}
+
+// probePostgres sends an SSL request to a PostgreSQL server, which answers 'S' or 'N'.
+func probePostgres(conn net.Conn) error {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], 80877103) // SSL request code
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	response := make([]byte, 1)
+
+	if _, err := io.ReadFull(conn, response); err != nil {
+		return err
+	}
+
+	if response[0] != 'S' && response[0] != 'N' {
+		return fmt.Errorf("postgres: unexpected response to SSL request 0x%02x", response[0])
+	}
+
+	return nil
+}
+
+// probeRedis sends PING to a Redis server. A server requiring authentication answers
+// NOAUTH, which still proves that it is up.
+func probeRedis(conn net.Conn) error {
+	if _, err := conn.Write([]byte("PING\r\n")); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+
+	if err != nil {
+		return err
+	}
+
+	line = strings.TrimSpace(line)
+
+	if line == "+PONG" || strings.HasPrefix(line, "-NOAUTH") {
+		return nil
+	}
+
+	return fmt.Errorf("redis: unexpected response to PING %q", line)
+}
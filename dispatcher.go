@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Normalization steps of the URIs of dispatcher targets, used as labels.
+// They keep label values stable when destinations are re-added with slightly different parameters.
+const (
+	uriStripParams   = "strip-params"   // remove the URI parameters and headers, e.g. ";transport=tcp"
+	uriStripPort     = "strip-port"     // remove the port
+	uriLowercaseHost = "lowercase-host" // lowercase the scheme and host
+	uriHash          = "hash"           // replace the URI by a hash, applied after the other steps
+)
+
+// dispatcherStates are the states of dispatcher targets, from the first letter of their flags.
+// They are exported as an OpenMetrics StateSet: one series per state, exactly one of them set to 1.
+var dispatcherStates = map[byte]string{
+	'A': "active",
+	'I': "inactive",
+	'D': "disabled",
+	'T': "trying",
+}
+
+// dispatcherStateMetricName is the name of the target_state metric, which is also the name of its label,
+// as required for OpenMetrics StateSets.
+const dispatcherStateMetricName = namespace + "_dispatcher_list_target_state"
+
+// dispatcherWeight returns the weight of the attributes of a target, such as "weight=50;duid=a", 0 if not set.
+func dispatcherWeight(attrs string) int {
+	for _, attr := range strings.Split(attrs, ";") {
+		name, value, found := strings.Cut(attr, "=")
+
+		if found && strings.TrimSpace(name) == "weight" {
+			weight, _ := strconv.Atoi(strings.TrimSpace(value))
+			return weight
+		}
+	}
+
+	return 0
+}
+
+// validateURINormalize checks the normalization steps of steps.
+func validateURINormalize(steps []string) error {
+	for _, step := range steps {
+		switch step {
+		case uriStripParams, uriStripPort, uriLowercaseHost, uriHash:
+		default:
+			return fmt.Errorf(`invalid dispatcher URI normalization "%s", expected "%s", "%s", "%s" or "%s"`,
+				step, uriStripParams, uriStripPort, uriLowercaseHost, uriHash,
+			)
+		}
+	}
+
+	return nil
+}
+
+// normalizeURI applies steps to uri, a SIP URI such as "sip:user@host:port;params".
+func normalizeURI(uri string, steps []string) string {
+	if len(steps) == 0 {
+		return uri
+	}
+
+	has := func(step string) bool {
+		for _, s := range steps {
+			if s == step {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	scheme, rest, found := strings.Cut(uri, ":")
+
+	if !found {
+		scheme, rest = "", uri
+	}
+
+	userinfo := ""
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userinfo, rest = rest[:i+1], rest[i+1:]
+	}
+
+	// rest is now "host:port;params?headers", with IPv6 hosts between brackets
+	hostport, params := rest, ""
+	if i := strings.IndexAny(rest, ";?"); i >= 0 {
+		hostport, params = rest[:i], rest[i:]
+	}
+
+	host, port := hostport, ""
+	if i := strings.LastIndex(hostport, ":"); i >= 0 && i > strings.LastIndex(hostport, "]") {
+		host, port = hostport[:i], hostport[i:]
+	}
+
+	if has(uriStripParams) {
+		params = ""
+	}
+
+	if has(uriStripPort) {
+		port = ""
+	}
+
+	if has(uriLowercaseHost) {
+		scheme = strings.ToLower(scheme)
+		host = strings.ToLower(host)
+	}
+
+	normalized := userinfo + host + port + params
+	if found {
+		normalized = scheme + ":" + normalized
+	}
+
+	if has(uriHash) {
+		sum := sha256.Sum256([]byte(normalized))
+
+		return hex.EncodeToString(sum[:8])
+	}
+
+	return normalized
+}
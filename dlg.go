@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// timeNow returns the time from which the age of dialogs is computed. Tests replace it.
+var timeNow = time.Now
+
+/* Sample output (only used keys are shown)
+
+kamcmd> dlg.list
+{
+	h_entry: 1234
+	h_id: 5678
+	call-id: 4f1b3b6c@10.0.0.1
+	from_uri: sip:alice@example.com
+	to_uri: sip:bob@example.net
+	state: 4
+	start_ts: 1700000000
+	caller: {
+		...
+	}
+}
+{
+	...
+}
+*/
+
+// dialogDurationBuckets are the upper bounds of the dialog duration histograms, in seconds.
+var dialogDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+
+// Dialog is a dialog of the dialog module.
+type Dialog struct {
+	ID      string // h_entry:h_id
+	CallID  string
+	FromURI string
+	ToURI   string
+	State   int
+	StartTS int // 0 until the dialog is answered
+}
+
+// DialogLabel is a rule extracting a label from the active dialogs, to count them by
+// carrier or destination domain.
+type DialogLabel struct {
+	Name      string `yaml:"name"`
+	Field     string `yaml:"field"`      // "to_uri" or "from_uri"
+	Regex     string `yaml:"regex"`      // the first group, or the whole match, is the value of the label
+	MaxValues int    `yaml:"max_values"` // less frequent values are counted as "other"
+
+	regex *regexp.Regexp
+}
+
+// dialogLabelOther is the value of the label of the dialogs exceeding DialogLabel.MaxValues.
+const dialogLabelOther = "other"
+
+// ParseDialogLabel parses a rule in the form "name=field:regex".
+func ParseDialogLabel(s string, maxValues int) (*DialogLabel, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	name, rule, found := strings.Cut(s, "=")
+
+	if !found {
+		return nil, fmt.Errorf(`invalid dialog label "%s", expected "name=field:regex"`, s)
+	}
+
+	field, regex, found := strings.Cut(rule, ":")
+
+	if !found {
+		return nil, fmt.Errorf(`invalid dialog label "%s", expected "name=field:regex"`, s)
+	}
+
+	return &DialogLabel{Name: name, Field: field, Regex: regex, MaxValues: maxValues}, nil
+}
+
+// compile validates l and compiles its regex.
+func (l *DialogLabel) compile() error {
+	if !labelNameRegex.MatchString(l.Name) {
+		return fmt.Errorf(`invalid dialog label name "%s"`, l.Name)
+	}
+
+	for _, reserved := range reservedLabels {
+		if l.Name == reserved {
+			return fmt.Errorf(`dialog label name "%s" is reserved`, l.Name)
+		}
+	}
+
+	if l.Field != "to_uri" && l.Field != "from_uri" {
+		return fmt.Errorf(`invalid dialog label field "%s", expected "to_uri" or "from_uri"`, l.Field)
+	}
+
+	if l.MaxValues <= 0 {
+		return fmt.Errorf("invalid dialog label max values: %d", l.MaxValues)
+	}
+
+	regex, err := regexp.Compile(l.Regex)
+
+	if err != nil {
+		return fmt.Errorf("invalid dialog label regex: %w", err)
+	}
+
+	l.regex = regex
+
+	return nil
+}
+
+// value returns the label of dialog, or an empty string if the regex does not match.
+func (l *DialogLabel) value(dialog Dialog) string {
+	field := dialog.ToURI
+	if l.Field == "from_uri" {
+		field = dialog.FromURI
+	}
+
+	match := l.regex.FindStringSubmatch(field)
+
+	switch {
+	case len(match) > 1:
+		return match[1]
+	case len(match) == 1:
+		return match[0]
+	}
+
+	return ""
+}
+
+// top returns counts with at most l.MaxValues values: the less frequent values are merged into "other".
+func (l *DialogLabel) top(counts map[string]int) map[string]int {
+	if len(counts) <= l.MaxValues {
+		return counts
+	}
+
+	values := make([]string, 0, len(counts))
+
+	for value := range counts {
+		values = append(values, value)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+
+		return values[i] < values[j]
+	})
+
+	top := make(map[string]int)
+
+	for i, value := range values {
+		if i < l.MaxValues-1 {
+			top[value] = counts[value]
+		} else {
+			top[dialogLabelOther] += counts[value]
+		}
+	}
+
+	return top
+}
+
+// dialogTracker remembers the dialogs of the previous dlg.list call, to estimate
+// the duration of the dialogs that completed in between.
+type dialogTracker struct {
+	last    time.Time
+	started map[string]int // start_ts by dialog ID, nil if the previous call was skipped
+
+	completed MetricValue // cumulative histogram
+}
+
+// scrapeDialogs passes the histograms of the ages of active dialogs, and of the
+// durations of the dialogs that completed since the last call, to fn.
+//
+// Since dlg.list is costly for kamailio, it is not called if dlg.stats_active reports
+// more than c.DlgListMaxDialogs dialogs.
+func (c *Collector) scrapeDialogs(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	if c.DlgListMaxDialogs > 0 {
+		stats, err := c.parseMethod(ctx, "dlg.stats_active")
+
+		if err != nil {
+			return err
+		}
+
+		if all := stats["all"]; len(all) > 0 && all[0].Value > float64(c.DlgListMaxDialogs) {
+			log.Printf("[warning] %d active dialogs, more than %d: dlg.list skipped", int(all[0].Value), c.DlgListMaxDialogs)
+
+			// the next call cannot tell which dialogs completed
+			c.dialogs.started = nil
+
+			return nil
+		}
+	}
+
+	now := timeNow()
+	started := make(map[string]int)
+	age := newHistogramValue()
+	counts := make(map[string]int) // by DialogLabel
+
+	err := c.streamBINRPC(ctx, "dlg.list", func(d *rpcDecoder) error {
+		return streamDialogs(d, func(dialog Dialog) error {
+			if c.DialogLabel != nil {
+				counts[c.DialogLabel.value(dialog)]++
+			}
+
+			if dialog.StartTS == 0 {
+				return nil
+			}
+
+			started[dialog.ID] = dialog.StartTS
+			age.observe(now.Sub(time.Unix(int64(dialog.StartTS), 0)).Seconds())
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if c.dialogs.completed.Buckets == nil {
+		c.dialogs.completed = newHistogramValue()
+	}
+
+	// dialogs are seen for the last time at the previous call: their duration is underestimated
+	// by up to the interval between two calls
+	for id, startTS := range c.dialogs.started {
+		if _, found := started[id]; !found {
+			c.dialogs.completed.observe(c.dialogs.last.Sub(time.Unix(int64(startTS), 0)).Seconds())
+		}
+	}
+
+	c.dialogs.last = now
+	c.dialogs.started = started
+
+	if err := fn("age_seconds", age); err != nil {
+		return err
+	}
+
+	if c.DialogLabel != nil {
+		for value, count := range c.DialogLabel.top(counts) {
+			err := fn("active", MetricValue{
+				Value:  float64(count),
+				Labels: map[string]string{c.DialogLabel.Name: labelValues.String(value)},
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// the histogram keeps its buckets: they would change under metrics still being gathered,
+	// such as the ones of background collection or of the grace period
+	return fn("completed_duration_seconds", c.dialogs.completed.snapshot())
+}
+
+// newHistogramValue returns an empty histogram with dialogDurationBuckets.
+func newHistogramValue() MetricValue {
+	value := MetricValue{Buckets: make(map[float64]uint64)}
+
+	for _, bucket := range dialogDurationBuckets {
+		value.Buckets[bucket] = 0
+	}
+
+	return value
+}
+
+// observe adds v to the histogram m.
+func (m *MetricValue) observe(v float64) {
+	if v < 0 {
+		v = 0
+	}
+
+	m.Count++
+	m.Value += v
+
+	for bucket := range m.Buckets {
+		if v <= bucket {
+			m.Buckets[bucket]++
+		}
+	}
+}
+
+// snapshot returns a copy of the histogram m, not changed by later observations.
+func (m MetricValue) snapshot() MetricValue {
+	buckets := make(map[float64]uint64, len(m.Buckets))
+
+	for bucket, count := range m.Buckets {
+		buckets[bucket] = count
+	}
+
+	m.Buckets = buckets
+
+	return m
+}
+
+// streamDialogs decodes a "dlg.list" response and calls fn for each dialog.
+func streamDialogs(d *rpcDecoder, fn func(dialog Dialog) error) error {
+	// call IDs and tags are unique, they would only churn the table of label values
+	d.intern = false
+
+	return streamStructs(d, "dlg.list", func(fields map[string]binrpc.Record) error {
+		return fn(Dialog{
+			ID:      strconv.Itoa(intField(fields, "h_entry")) + ":" + strconv.Itoa(intField(fields, "h_id")),
+			CallID:  stringField(fields, "call-id"),
+			FromURI: stringField(fields, "from_uri"),
+			ToURI:   stringField(fields, "to_uri"),
+			State:   intField(fields, "state"),
+			StartTS: intField(fields, "start_ts"),
+		})
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dialogsFixture returns the result of dlg.list with n dialogs, started an hour ago, whose
+// IDs begin at first.
+func dialogsFixture(first int, n int) string {
+	dialogs := make([]string, n)
+
+	for i := range dialogs {
+		dialogs[i] = fmt.Sprintf(`{"h_entry": %d, "h_id": 1, "call-id": "c%d", "state": 4, "start_ts": %d}`,
+			first+i, first+i, time.Now().Add(-time.Hour).Unix())
+	}
+
+	return "[" + strings.Join(dialogs, ", ") + "]"
+}
+
+// TestCompletedDialogsGather gathers the histogram of completed dialogs while background
+// collection observes new ones: run with -race.
+func TestCompletedDialogsGather(t *testing.T) {
+	k := newFakeKamailio(t, map[string]string{"dlg.list": dialogsFixture(0, 20)})
+	c, err := NewCollector(k.URI, 5*time.Second, "dlg.list")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Interval = time.Millisecond
+	c.Start()
+
+	defer func() {
+		c.mutex.Lock()
+		c.Interval = 0
+		c.mutex.Unlock()
+	}()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	stop := make(chan struct{})
+	defer close(stop)
+
+	// the dialogs of the previous call complete on each call
+	go func() {
+		for i := 1; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Microsecond):
+				k.set(t, "dlg.list", dialogsFixture(i*20, 20))
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+
+	for count := uint64(0); count < 200; {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d completed dialogs gathered, want 200", count)
+		}
+
+		families, err := registry.Gather()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, family := range families {
+			if family.GetName() == "kamailio_dlg_list_completed_duration_seconds" {
+				count = family.GetMetric()[0].GetHistogram().GetSampleCount()
+			}
+		}
+	}
+}
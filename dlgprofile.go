@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> dlg.profile_get_size trunk carrier-a
+{
+	profile: trunk
+	value: carrier-a
+	count: 3
+}
+*/
+
+// ParseDialogProfiles parses a list of dialog profiles in the form "profile=value|value,profile".
+// Profiles without values are counted as a whole.
+func ParseDialogProfiles(s string) (map[string][]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	profiles := make(map[string][]string)
+
+	for _, item := range strings.Split(s, ",") {
+		profile, values, found := strings.Cut(item, "=")
+
+		if profile == "" || (found && values == "") {
+			return nil, fmt.Errorf(`invalid dialog profile "%s", expected "profile" or "profile=value|value"`, item)
+		}
+
+		profiles[profile] = nil
+
+		if found {
+			profiles[profile] = strings.Split(values, "|")
+		}
+	}
+
+	return profiles, nil
+}
+
+// scrapeDialogProfiles passes the number of dialogs of each profile of c.DialogProfiles to fn.
+// kamailio cannot list the values of a profile, so the dialogs of profiles with values are counted
+// for each configured value, e.g. the concurrent calls of each trunk.
+func (c *Collector) scrapeDialogProfiles(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	profiles := make([]string, 0, len(c.DialogProfiles))
+
+	for profile := range c.DialogProfiles {
+		profiles = append(profiles, profile)
+	}
+
+	sort.Strings(profiles)
+
+	for _, profile := range profiles {
+		values := c.DialogProfiles[profile]
+
+		if len(values) == 0 {
+			// the profile has no values, or all of them are counted
+			values = []string{""}
+		}
+
+		for _, value := range values {
+			params := []any{profile}
+
+			if value != "" {
+				params = append(params, value)
+			}
+
+			size, err := c.fetchDialogProfileSize(ctx, params...)
+
+			if err != nil {
+				return err
+			}
+
+			err = fn("dialogs", MetricValue{
+				Value:  float64(size),
+				Labels: map[string]string{"profile": profile, "value": value},
+			})
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// fetchDialogProfileSize calls dlg.profile_get_size with params, the profile and an optional value.
+// Older versions of kamailio return the size alone, instead of a struct.
+func (c *Collector) fetchDialogProfileSize(ctx context.Context, params ...any) (int, error) {
+	records, err := c.fetchBINRPC(ctx, "dlg.profile_get_size", params...)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return 0, &RPCError{Method: "dlg.profile_get_size", Code: code, Message: message}
+	}
+
+	if len(records) != 1 {
+		return 0, fmt.Errorf(`invalid response for method "dlg.profile_get_size", expected 1 record, got %d`, len(records))
+	}
+
+	if records[0].Type == binrpc.TypeInt {
+		return records[0].Int()
+	}
+
+	items, err := records[0].StructItems()
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, item := range items {
+		if item.Key == "count" {
+			return item.Value.Int()
+		}
+	}
+
+	return 0, fmt.Errorf(`invalid response for method "dlg.profile_get_size": missing "count"`)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> dmq.list_nodes
+{
+	host: 10.0.0.2
+	port: 5060
+	resolved_ip: 10.0.0.2
+	status: active
+	last_notification: 0
+	local: 0
+}
+{
+	host: 10.0.0.1
+	port: 5060
+	resolved_ip: 10.0.0.1
+	status: active
+	last_notification: 0
+	local: 1
+}
+*/
+
+// dmqNodeStateMetricName is the name of the node_state metric, which is also the name of its label,
+// as required for OpenMetrics StateSets.
+const dmqNodeStateMetricName = namespace + "_dmq_list_nodes_node_state"
+
+// dmqStatuses are the statuses of DMQ nodes, always exported so that a count
+// dropping to 0 does not make the series disappear.
+var dmqStatuses = []string{"active", "timeout", "disabled", "pending"}
+
+// scrapeDMQNodes passes the number of DMQ nodes, in total and by status, and the state of each node, to fn.
+func (c *Collector) scrapeDMQNodes(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	total := 0
+	counts := make(map[string]int)
+
+	for _, status := range dmqStatuses {
+		counts[status] = 0
+	}
+
+	err := c.streamBINRPC(ctx, "dmq.list_nodes", func(d *rpcDecoder) error {
+		return streamStructs(d, "dmq.list_nodes", func(fields map[string]binrpc.Record) error {
+			total++
+			status := stringField(fields, "status")
+			_, known := counts[status]
+			counts[status]++
+
+			if resolved := stringField(fields, "resolved_ip"); c.StringValues && resolved != "" {
+				err := fn(stringInfo, stringValue("resolved_ip", resolved, map[string]string{
+					"host": stringField(fields, "host"),
+					"port": strconv.Itoa(intField(fields, "port")),
+				}))
+
+				if err != nil {
+					return err
+				}
+			}
+
+			// unknown statuses cannot be represented in the StateSet
+			if !known {
+				return nil
+			}
+
+			// a StateSet: one series per status, exactly one of them set to 1
+			for _, s := range dmqStatuses {
+				value := 0.0
+				if s == status {
+					value = 1
+				}
+
+				err := fn("node_state", MetricValue{
+					Value: value,
+					Labels: map[string]string{
+						"host":                 stringField(fields, "host"),
+						"port":                 strconv.Itoa(intField(fields, "port")),
+						dmqNodeStateMetricName: s,
+					},
+				})
+
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if err := fn("total", MetricValue{Value: float64(total)}); err != nil {
+		return err
+	}
+
+	for status, count := range counts {
+		err := fn("status", MetricValue{
+			Value:  float64(count),
+			Labels: map[string]string{"status": status},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// dnsCache caches the addresses of the hosts of tcp:// scrape URIs.
+type dnsCache struct {
+	mutex   sync.Mutex
+	entries map[string]dnsEntry // by host
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// lookup returns the cached addresses of host, or resolves them if the cache expired.
+func (d *dnsCache) lookup(ctx context.Context, host string, ttl time.Duration) ([]string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if entry, found := d.entries[host]; found && time.Now().Before(entry.expires) {
+		return entry.addrs, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.entries == nil {
+		d.entries = make(map[string]dnsEntry)
+	}
+
+	d.entries[host] = dnsEntry{addrs: addrs, expires: time.Now().Add(ttl)}
+
+	return addrs, nil
+}
+
+// invalidate forces the next lookup of host to resolve again.
+func (d *dnsCache) invalidate(host string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.entries, host)
+}
+
+// dialCached dials address (host:port) using the cached addresses of host.
+// Addresses are tried in order, and the cache is invalidated if none of them answers,
+// so that a target moved behind round-robin DNS is resolved again on the next scrape.
+func (c *Collector) dialCached(ctx context.Context, network string, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := net.Dialer{}
+
+	if net.ParseIP(host) != nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+
+	addrs, err := c.dns.lookup(ctx, host, c.DNSTTL)
+
+	if err != nil {
+		c.dnsErrors.Inc()
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		var conn net.Conn
+
+		conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	c.dns.invalidate(host)
+
+	return nil, err
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> domain.dump
+{
+	domain: example.com
+	did: example.com
+}
+{
+	domain: tenant-b.example.net
+	did: tenant-b
+}
+*/
+
+// scrapeDomains passes the number of domains loaded by the domain module to fn, and their
+// info series if c.DomainInfo is set. A failed reload of the domain table shows as a drop of the count.
+func (c *Collector) scrapeDomains(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	count := 0
+
+	err := c.streamBINRPC(ctx, "domain.dump", func(d *rpcDecoder) error {
+		return streamStructs(d, "domain.dump", func(fields map[string]binrpc.Record) error {
+			count++
+
+			if !c.DomainInfo {
+				return nil
+			}
+
+			return fn("domain_info", MetricValue{
+				Value: 1,
+				Labels: map[string]string{
+					"domain": stringField(fields, "domain"),
+					"did":    stringField(fields, "did"),
+				},
+			})
+		})
+	})
+
+	// kamailio returns nothing when no domain is loaded
+	if err != nil && !errors.Is(err, errEmptyResponse) {
+		return err
+	}
+
+	return fn("domains", MetricValue{Value: float64(count)})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+// dialExec returns a connection running the command of u, "exec:/path/to/command?arg=...&arg=...":
+// a last resort when the BINRPC socket cannot be used by the exporter (permissions, exotic socket
+// setups), but a local tool can. Each request is a command run with the method and its parameters as
+// arguments, which prints the result in JSON.
+func dialExec(u *url.URL) (net.Conn, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("invalid exec URI %q: the path of the command is required", u)
+	}
+
+	path, args := u.Path, u.Query()["arg"]
+
+	call := func(ctx context.Context, params []any) ([]byte, error) {
+		arguments := append([]string{}, args...)
+
+		for _, param := range params {
+			arguments = append(arguments, fmt.Sprint(param))
+		}
+
+		var stdout, stderr bytes.Buffer
+
+		cmd := exec.CommandContext(ctx, path, arguments...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("exec %s %v: %w", path, params[0], ctx.Err())
+			}
+
+			return nil, fmt.Errorf("exec %s %v: %w: %s", path, params[0], err, strings.TrimSpace(stderr.String()))
+		}
+
+		return stdout.Bytes(), nil
+	}
+
+	return &jsonConn{call: call, addr: execAddr(path)}, nil
+}
+
+// execAddr is the address of a connection of an "exec:" URI, the path of its command.
+type execAddr string
+
+// Network implements net.Addr.
+func (a execAddr) Network() string {
+	return "exec"
+}
+
+// String implements net.Addr.
+func (a execAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultFIFOReplyDir is the default "reply_dir" of the mi_fifo module.
+const defaultFIFOReplyDir = "/tmp"
+
+// fifoReplies numbers the reply FIFOs of MI commands.
+var fifoReplies uint64
+
+// miNode is a line of the reply of an MI command: "name:: value", indented by tabs for children.
+type miNode struct {
+	name  string
+	value string
+	level int
+}
+
+// miError is an error reply of an MI command, such as "500 command 'ds_list' not available".
+type miError struct {
+	code   int
+	reason string
+}
+
+func (e *miError) Error() string {
+	return fmt.Sprintf("%d %s", e.code, e.reason)
+}
+
+// fifoMethod is the MI command equivalent to an RPC method, and the translation of its reply
+// to the result of the RPC method.
+type fifoMethod struct {
+	command   string
+	params    []string // the parameters of the RPC call are passed if nil
+	translate func(nodes []miNode) (any, error)
+}
+
+// miUnsupported ends the message of the error of the methods without MI equivalent.
+const miUnsupported = "not supported over MI FIFO"
+
+// fifoMethods are the RPC methods supported by the "fifo:" scrape URIs.
+var fifoMethods = map[string]fifoMethod{
+	"core.uptime":          {command: "uptime", translate: miUptime},
+	"core.version":         {command: "version", translate: miVersion},
+	"core.flags":           {command: "version", translate: miFlags},
+	"core.shmmem":          {command: "get_statistics", params: []string{"shmem:"}, translate: miShmem},
+	"tm.stats":             {command: "get_statistics", params: []string{"tm:"}, translate: miTMStats},
+	"sl.stats":             {command: "get_statistics", params: []string{"sl:"}, translate: miSLStats},
+	"stats.fetch":          {command: "get_statistics", translate: miStatsFetch},
+	"stats.get_statistics": {command: "get_statistics", translate: miStatLines},
+}
+
+// dialFIFO returns a connection calling kamailio through the FIFO of the mi_fifo module, for older
+// versions of kamailio (and OpenSER) without RPC: "fifo:/tmp/kamailio_fifo?reply_dir=/tmp". Each
+// request is translated to the equivalent MI command, whose text reply is translated back to the
+// result of the RPC method, so that the same metrics are exported. Methods without MI equivalent
+// are rejected, like methods unknown to kamailio.
+func dialFIFO(u *url.URL) (net.Conn, error) {
+	if u.Path == "" {
+		return nil, fmt.Errorf("invalid fifo URI %q: the path of the FIFO is required", u)
+	}
+
+	path, replyDir := u.Path, u.Query().Get("reply_dir")
+
+	if replyDir == "" {
+		replyDir = defaultFIFOReplyDir
+	}
+
+	call := func(ctx context.Context, params []any) ([]byte, error) {
+		method := fmt.Sprint(params[0])
+		args := make([]string, 0, len(params)-1)
+
+		for _, param := range params[1:] {
+			args = append(args, fmt.Sprint(param))
+		}
+
+		var result any
+
+		if method == "system.listMethods" {
+			nodes, err := miCommand(ctx, path, replyDir, "which", nil)
+
+			if err != nil {
+				return miErrorResponse(err)
+			}
+
+			result = miMethods(nodes)
+		} else {
+			m, found := fifoMethods[method]
+
+			if !found {
+				return jsonResponse("error", map[string]any{
+					"code":    500,
+					"message": fmt.Sprintf("command %s %s", method, miUnsupported),
+				})
+			}
+
+			if m.params != nil {
+				args = m.params
+			}
+
+			nodes, err := miCommand(ctx, path, replyDir, m.command, args)
+
+			if err != nil {
+				return miErrorResponse(err)
+			}
+
+			if result, err = m.translate(nodes); err != nil {
+				return nil, fmt.Errorf("fifo %s %s: invalid reply: %w", path, m.command, err)
+			}
+		}
+
+		return jsonResponse("result", result)
+	}
+
+	return &jsonConn{call: call, addr: fifoAddr(path)}, nil
+}
+
+// jsonResponse returns a JSON-RPC response with value as key, "result" or "error".
+func jsonResponse(key string, value any) ([]byte, error) {
+	return json.Marshal(map[string]any{key: value})
+}
+
+// miErrorResponse returns err as the error of a JSON-RPC response if it is an error reply of kamailio,
+// and returns it otherwise.
+func miErrorResponse(err error) ([]byte, error) {
+	var e *miError
+
+	if errors.As(err, &e) {
+		return jsonResponse("error", map[string]any{"code": e.code, "message": e.reason})
+	}
+
+	return nil, err
+}
+
+// miCommand runs an MI command through the FIFO at path, and returns the nodes of its reply. kamailio
+// writes the reply to a FIFO created by the exporter in replyDir, the "reply_dir" of the mi_fifo module.
+func miCommand(ctx context.Context, path string, replyDir string, command string, args []string) ([]miNode, error) {
+	deadline, _ := ctx.Deadline()
+
+	// the name of the reply FIFO cannot contain dots or slashes
+	name := fmt.Sprintf("kamailio_exporter_%d_%d", os.Getpid(), atomic.AddUint64(&fifoReplies, 1))
+	replyPath := filepath.Join(replyDir, name)
+
+	// a FIFO left by a crashed process with the same pid
+	os.Remove(replyPath)
+
+	if err := mkfifo(replyPath); err != nil {
+		return nil, fmt.Errorf("fifo %s: cannot create the reply FIFO: %w", path, err)
+	}
+
+	defer os.Remove(replyPath)
+
+	// opened for writing too, so that the reads wait for the reply instead of returning EOF
+	reply, err := os.OpenFile(replyPath, os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, fmt.Errorf("fifo %s: %w", path, err)
+	}
+
+	defer reply.Close()
+
+	var request strings.Builder
+
+	fmt.Fprintf(&request, ":%s:%s\n", command, name)
+
+	for _, arg := range args {
+		request.WriteString(arg + "\n")
+	}
+
+	request.WriteString("\n")
+
+	// without reader, kamailio is not running: fail instead of waiting
+	fifo, err := os.OpenFile(path, os.O_WRONLY|fifoNonblock, 0)
+
+	if err != nil {
+		return nil, fmt.Errorf("fifo %s: %w", path, err)
+	}
+
+	fifo.SetWriteDeadline(deadline)
+	_, err = fifo.WriteString(request.String())
+	fifo.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("fifo %s %s: %w", path, command, err)
+	}
+
+	reply.SetReadDeadline(deadline)
+
+	nodes, err := readMIReply(bufio.NewReader(reply))
+
+	if err != nil {
+		return nil, fmt.Errorf("fifo %s %s: %w", path, command, err)
+	}
+
+	return nodes, nil
+}
+
+// readMIReply reads the reply of an MI command: a status line, such as "200 OK", followed by the nodes
+// of the reply, until an empty line.
+func readMIReply(r *bufio.Reader) ([]miNode, error) {
+	status, err := r.ReadString('\n')
+
+	if err != nil {
+		return nil, err
+	}
+
+	code, reason, _ := strings.Cut(strings.TrimSpace(status), " ")
+	c, err := strconv.Atoi(code)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid status line %q", status)
+	}
+
+	var nodes []miNode
+
+	for {
+		line, err := r.ReadString('\n')
+
+		if err != nil {
+			return nil, err
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" {
+			break
+		}
+
+		level := len(line) - len(strings.TrimLeft(line, "\t"))
+		name, value, found := strings.Cut(line[level:], ":: ")
+
+		if !found {
+			name, value = strings.TrimSuffix(line[level:], "::"), ""
+		}
+
+		nodes = append(nodes, miNode{name: name, value: value, level: level})
+	}
+
+	if c/100 != 2 {
+		return nil, &miError{code: c, reason: reason}
+	}
+
+	return nodes, nil
+}
+
+// miStats returns the statistics of the reply of get_statistics, "group:name = value" lines, by "group:name".
+func miStats(nodes []miNode) map[string]string {
+	stats := make(map[string]string, len(nodes))
+
+	for _, node := range nodes {
+		// the statistics are values of nodes without name in most versions
+		line := node.value
+		if line == "" {
+			line = node.name
+		}
+
+		if key, value, found := strings.Cut(line, " = "); found {
+			stats[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+
+	return stats
+}
+
+// miInt returns s as a number, or as a string if it is not an integer.
+func miInt(s string) any {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+
+	return s
+}
+
+// miUptime translates the reply of uptime ("Up time:: 604800 [sec]") to the result of core.uptime.
+func miUptime(nodes []miNode) (any, error) {
+	result := map[string]any{}
+
+	for _, node := range nodes {
+		switch node.name {
+		case "Now":
+			result["now"] = node.value
+		case "Up since":
+			result["up_since"] = node.value
+		case "Up time":
+			result["uptime"] = miInt(strings.TrimSuffix(node.value, " [sec]"))
+		}
+	}
+
+	if result["uptime"] == nil {
+		return nil, fmt.Errorf(`missing "Up time"`)
+	}
+
+	return result, nil
+}
+
+// miVersion translates the reply of version ("Server:: kamailio (4.4.7 (x86_64/linux))") to the result of
+// core.version ("kamailio 4.4.7 (x86_64/linux)").
+func miVersion(nodes []miNode) (any, error) {
+	for _, node := range nodes {
+		if node.name == "Server" {
+			name, version, found := strings.Cut(node.value, " (")
+
+			if !found {
+				return node.value, nil
+			}
+
+			return name + " " + strings.TrimSuffix(version, ")"), nil
+		}
+	}
+
+	return nil, fmt.Errorf(`missing "Server"`)
+}
+
+// miFlags translates the reply of version to the result of core.flags: its "Flags" node, which is missing
+// on some versions.
+func miFlags(nodes []miNode) (any, error) {
+	for _, node := range nodes {
+		if node.name == "Flags" {
+			return node.value, nil
+		}
+	}
+
+	return "", nil
+}
+
+// miShmem translates the shmem statistics to the result of core.shmmem.
+func miShmem(nodes []miNode) (any, error) {
+	stats := miStats(nodes)
+	result := map[string]any{}
+
+	for name, key := range map[string]string{
+		"total_size":     "total",
+		"free_size":      "free",
+		"used_size":      "used",
+		"real_used_size": "real_used",
+		"max_used_size":  "max_used",
+		"fragments":      "fragments",
+	} {
+		if value, found := stats["shmem:"+name]; found {
+			result[key] = miInt(value)
+		}
+	}
+
+	return result, nil
+}
+
+// miTMStats translates the tm statistics to the result of tm.stats. The statistics of the transactions
+// are not the same: totals are computed from the statistics of UAS and UAC transactions.
+func miTMStats(nodes []miNode) (any, error) {
+	stats := miStats(nodes)
+	result := map[string]any{}
+
+	value := func(name string) int64 {
+		i, _ := strconv.ParseInt(stats["tm:"+name], 10, 64)
+		return i
+	}
+
+	for name, key := range map[string]string{
+		"inuse_transactions": "current",
+		"received_replies":   "rpl_received",
+		"local_replies":      "rpl_generated",
+		"UAC_transactions":   "total_local",
+		"2xx_transactions":   "2xx",
+		"3xx_transactions":   "3xx",
+		"4xx_transactions":   "4xx",
+		"5xx_transactions":   "5xx",
+		"6xx_transactions":   "6xx",
+	} {
+		if _, found := stats["tm:"+name]; found {
+			result[key] = value(name)
+		}
+	}
+
+	if _, found := stats["tm:UAS_transactions"]; found {
+		result["total"] = value("UAS_transactions") + value("UAC_transactions")
+	}
+
+	if _, found := stats["tm:relayed_replies"]; found {
+		result["rpl_sent"] = value("relayed_replies") + value("local_replies")
+	}
+
+	return result, nil
+}
+
+// miSLStats translates the sl statistics ("sl:200_replies = 12") to the result of sl.stats ("200": 12).
+func miSLStats(nodes []miNode) (any, error) {
+	result := map[string]any{}
+
+	for key, value := range miStats(nodes) {
+		if code := strings.TrimSuffix(strings.TrimPrefix(key, "sl:"), "_replies"); codeRegex.MatchString(code) {
+			result[code] = miInt(value)
+		}
+	}
+
+	return result, nil
+}
+
+// miStatsFetch translates the statistics to the result of stats.fetch, by "group.name".
+func miStatsFetch(nodes []miNode) (any, error) {
+	result := map[string]any{}
+
+	for key, value := range miStats(nodes) {
+		result[strings.Replace(key, ":", ".", 1)] = value
+	}
+
+	return result, nil
+}
+
+// miStatLines translates the statistics to the result of stats.get_statistics, "group:name = value" lines.
+func miStatLines(nodes []miNode) (any, error) {
+	stats := miStats(nodes)
+	lines := make([]any, 0, len(stats))
+
+	for key, value := range stats {
+		lines = append(lines, key+" = "+value)
+	}
+
+	return lines, nil
+}
+
+// miMethods translates the reply of which, the MI commands, to the result of system.listMethods:
+// the RPC methods whose MI command is available.
+func miMethods(nodes []miNode) []any {
+	commands := make(map[string]bool, len(nodes))
+
+	for _, node := range nodes {
+		commands[node.name] = true
+		commands[node.value] = true
+	}
+
+	methods := []any{"system.listMethods"}
+
+	for method, m := range fifoMethods {
+		if commands[m.command] {
+			methods = append(methods, method)
+		}
+	}
+
+	return methods
+}
+
+// fifoAddr is the address of a connection of a "fifo:" URI, the path of the FIFO.
+type fifoAddr string
+
+// Network implements net.Addr.
+func (a fifoAddr) Network() string {
+	return "fifo"
+}
+
+// String implements net.Addr.
+func (a fifoAddr) String() string {
+	return string(a)
+}
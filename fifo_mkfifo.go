@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+import "syscall"
+
+// fifoNonblock opens the FIFO of kamailio without waiting for a reader.
+const fifoNonblock = syscall.O_NONBLOCK
+
+// mkfifo creates the FIFO at path, readable and writable by everyone but for the umask, like the
+// local sockets of unixgram URIs, so that kamailio can write the reply.
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0666)
+}
@@ -0,0 +1,11 @@
+package main
+
+import "errors"
+
+// fifoNonblock is not used on Windows, which has no FIFO.
+const fifoNonblock = 0
+
+// mkfifo fails on Windows, which has no FIFO.
+func mkfifo(path string) error {
+	return errors.New("FIFOs are not supported on Windows")
+}
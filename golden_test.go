@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var update = flag.Bool("update", false, "write the metrics of TestGolden to the golden files")
+
+// goldenTime is the time at which the fixtures were recorded.
+const goldenTime = 1792266000
+
+// goldenDialogProfiles are the dialog profiles of the scrapes of TestGolden.
+var goldenDialogProfiles = map[string][]string{
+	"trunk":   {"carrier-a", "carrier-b"},
+	"inbound": nil,
+}
+
+// TestGolden replays the responses of kamailio recorded in testdata/<version>/fixtures.json, by request,
+// and compares the metrics of each method with testdata/<version>/<method>.golden, so that changes of
+// the formats of kamailio between versions are caught. Golden files are rewritten with -update:
+//
+//	go test -run TestGolden -update
+func TestGolden(t *testing.T) {
+	// the ages of dialogs are computed from the time of the recording
+	timeNow = func() time.Time { return time.Unix(goldenTime, 0) }
+	defer func() { timeNow = time.Now }()
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "*", "fixtures.json"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range paths {
+		dir := filepath.Dir(path)
+		fixtures := readFixtures(t, path)
+		k := newFakeKamailio(t, fixtures)
+
+		for _, method := range availableMethods {
+			if !hasFixture(fixtures, method) {
+				continue
+			}
+
+			method := method
+
+			t.Run(filepath.Base(dir)+"/"+method, func(t *testing.T) {
+				got := goldenMetrics(t, k.URI, method)
+				golden := filepath.Join(dir, method+".golden")
+
+				if *update {
+					if err := os.WriteFile(golden, got, 0644); err != nil {
+						t.Fatal(err)
+					}
+
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+
+				if err != nil {
+					t.Fatalf("%s (run go test -run TestGolden -update to create it)", err)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Errorf("metrics differ from %s:\n%s", golden, diffLines(string(want), string(got)))
+				}
+			})
+		}
+	}
+}
+
+// readFixtures returns the results of the fixtures file path, by request.
+func readFixtures(t *testing.T, path string) map[string]string {
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var raw map[string]json.RawMessage
+
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("%s: %s", path, err)
+	}
+
+	fixtures := make(map[string]string, len(raw))
+
+	for request, result := range raw {
+		fixtures[request] = string(result)
+	}
+
+	return fixtures
+}
+
+// hasFixture returns true if fixtures have a response of method, with or without parameters.
+func hasFixture(fixtures map[string]string, method string) bool {
+	for request := range fixtures {
+		if request == method || strings.HasPrefix(request, method+" ") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// goldenMetrics scrapes method at uri, and returns the metrics in the text format, without the metrics
+// of the exporter itself, which hold durations and timestamps.
+func goldenMetrics(t *testing.T, uri string, method string) []byte {
+	c, err := NewCollector(uri, 5*time.Second, method)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the processes of the fixtures are not on this host
+	c.ProcfsPath = t.TempDir()
+	c.DialogProfiles = goldenDialogProfiles
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+
+	families, err := registry.Gather()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+
+	for _, family := range families {
+		if strings.HasPrefix(family.GetName(), "kamailio_exporter_") {
+			continue
+		}
+
+		if _, err := expfmt.MetricFamilyToText(&b, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// diffLines returns the lines of want missing from got, prefixed with "-", and the lines of got
+// missing from want, prefixed with "+".
+func diffLines(want string, got string) string {
+	wantLines := make(map[string]bool)
+	gotLines := make(map[string]bool)
+
+	for _, line := range strings.Split(want, "\n") {
+		wantLines[line] = true
+	}
+
+	for _, line := range strings.Split(got, "\n") {
+		gotLines[line] = true
+	}
+
+	var diff strings.Builder
+
+	for _, line := range strings.Split(want, "\n") {
+		if !gotLines[line] {
+			diff.WriteString("-" + line + "\n")
+		}
+	}
+
+	for _, line := range strings.Split(got, "\n") {
+		if !wantLines[line] {
+			diff.WriteString("+" + line + "\n")
+		}
+	}
+
+	return diff.String()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lastGood holds the metrics of the last successful scrape, served again when scrapes fail
+// during Collector.GracePeriod, so that brief restarts of kamailio do not blank dashboards.
+type lastGood struct {
+	metrics    map[string][]prometheus.Metric // per method
+	targetInfo prometheus.Metric
+	at         time.Time
+}
+
+// withinGrace returns true if a scrape succeeded at less than grace ago.
+func withinGrace(at time.Time, grace time.Duration) bool {
+	return grace > 0 && !at.IsZero() && time.Since(at) <= grace
+}
+
+// serveGrace sends the metrics collected by a scrape to ch, or the last good ones if the scrape
+// failed during the grace period. It returns true if the last good metrics were sent. c.mutex must be held.
+func (c *Collector) serveGrace(ch chan<- prometheus.Metric, collected map[string][]prometheus.Metric, ok bool) bool {
+	var targetInfo prometheus.Metric
+
+	if c.TargetInfo == targetInfoMetric {
+		targetInfo = c.targetInfo
+	}
+
+	stale := false
+
+	switch {
+	case ok:
+		c.lastGood = lastGood{metrics: collected, targetInfo: targetInfo, at: time.Now()}
+	case withinGrace(c.lastGood.at, c.GracePeriod):
+		collected, targetInfo, stale = c.lastGood.metrics, c.lastGood.targetInfo, true
+	default:
+		// partial metrics, as without grace period
+		c.lastGood = lastGood{}
+	}
+
+	for _, metrics := range collected {
+		for _, metric := range metrics {
+			ch <- metric
+		}
+	}
+
+	if targetInfo != nil {
+		ch <- targetInfo
+	}
+
+	return stale
+}
+
+// collectStale sends kamailio_data_stale to ch, if the grace period is enabled.
+func (c *Collector) collectStale(ch chan<- prometheus.Metric, grace time.Duration, stale bool) {
+	if grace == 0 {
+		return
+	}
+
+	v := 0.0
+	if stale {
+		v = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.staleDesc, prometheus.GaugeValue, v)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// heartbeatPinger requests a heartbeat URL after each successful scrape, a dead man's switch
+// for services such as Healthchecks.io or OpsGenie heartbeats: they alert when pings stop,
+// whether the exporter or kamailio is down.
+type heartbeatPinger struct {
+	URL    string
+	Method string // "GET" or "POST"
+
+	client  *http.Client
+	pending int32 // 1 while a ping is in progress, the next ones are skipped until it is done
+}
+
+// EnableHeartbeat makes c request url with method after each successful scrape.
+func (c *Collector) EnableHeartbeat(url string, method string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.heartbeat = &heartbeatPinger{
+		URL:    url,
+		Method: method,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// pingHeartbeat pings the heartbeat URL, if enabled, without waiting for the response.
+// c.mutex must be held.
+func (c *Collector) pingHeartbeat() {
+	h := c.heartbeat
+
+	if h == nil || !atomic.CompareAndSwapInt32(&h.pending, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&h.pending, 0)
+
+		h.ping()
+	}()
+}
+
+// ping requests the heartbeat URL.
+func (h *heartbeatPinger) ping() {
+	ctx, cancel := context.WithTimeout(context.Background(), h.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, h.Method, h.URL, nil)
+
+	if err != nil {
+		log.Println("[error] cannot ping heartbeat:", err)
+		return
+	}
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		log.Println("[error] cannot ping heartbeat:", err)
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[error] cannot ping heartbeat: %s replied %s", h.URL, resp.Status)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HEP3 chunks of the generic vendor used, see https://github.com/sipcapture/HEP.
+const (
+	hepChunkProtocolType = 0x000b
+	hepChunkPayload      = 0x000f
+
+	hepProtocolSIP = 1
+)
+
+// hepMethods are the SIP methods counted by name. Others are counted as "other", to bound cardinality.
+var hepMethods = map[string]bool{
+	"ACK": true, "BYE": true, "CANCEL": true, "INFO": true, "INVITE": true, "MESSAGE": true,
+	"NOTIFY": true, "OPTIONS": true, "PRACK": true, "PUBLISH": true, "REFER": true,
+	"REGISTER": true, "SUBSCRIBE": true, "UPDATE": true,
+}
+
+// HEPListener derives SIP traffic rates from HEP3 packets (Homer encapsulation), sent by the
+// siptrace module of kamailio, giving request and response rates without counters in the routing script.
+type HEPListener struct {
+	Address string
+
+	packets   prometheus.Counter
+	invalid   prometheus.Counter
+	requests  *prometheus.CounterVec
+	responses *prometheus.CounterVec
+}
+
+// NewHEPListener returns a new HEPListener on the UDP address.
+func NewHEPListener(address string) *HEPListener {
+	return &HEPListener{
+		Address: address,
+
+		packets: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hep_packets_total",
+			Help:      "Number of HEP packets received.",
+		}),
+		invalid: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hep_invalid_packets_total",
+			Help:      "Number of HEP packets that could not be decoded, or did not carry SIP.",
+		}),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hep_sip_requests_total",
+			Help:      "Number of SIP requests received over HEP, by method.",
+		}, []string{"method"}),
+		responses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "hep_sip_responses_total",
+			Help:      "Number of SIP responses received over HEP, by method and status code.",
+		}, []string{"method", "code"}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (h *HEPListener) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.packets.Desc()
+	ch <- h.invalid.Desc()
+	h.requests.Describe(ch)
+	h.responses.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (h *HEPListener) Collect(ch chan<- prometheus.Metric) {
+	ch <- h.packets
+	ch <- h.invalid
+	h.requests.Collect(ch)
+	h.responses.Collect(ch)
+}
+
+// Start listens on the address, and counts the packets received in the background.
+func (h *HEPListener) Start() error {
+	conn, err := net.ListenPacket("udp", h.Address)
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		buffer := make([]byte, 65535)
+
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+
+			if err != nil {
+				log.Println("[error] cannot read HEP packet:", err)
+				continue
+			}
+
+			h.packets.Inc()
+
+			if err := h.count(buffer[:n]); err != nil {
+				h.invalid.Inc()
+			}
+		}
+	}()
+
+	return nil
+}
+
+// count counts the SIP message carried by packet.
+func (h *HEPListener) count(packet []byte) error {
+	payload, err := hepPayload(packet)
+
+	if err != nil {
+		return err
+	}
+
+	// the first line is "INVITE sip:bob@example.com SIP/2.0" or "SIP/2.0 200 OK"
+	firstLine, headers, _ := bytes.Cut(payload, []byte("\n"))
+	fields := strings.Fields(string(firstLine))
+
+	if len(fields) < 2 {
+		return errors.New("invalid SIP message")
+	}
+
+	if !strings.HasPrefix(fields[0], "SIP/") {
+		h.requests.WithLabelValues(hepMethod(fields[0])).Inc()
+		return nil
+	}
+
+	code, err := strconv.Atoi(fields[1])
+
+	if err != nil || code < 100 || code > 699 {
+		return errors.New("invalid SIP status code")
+	}
+
+	h.responses.WithLabelValues(hepMethod(cseqMethod(headers)), fields[1]).Inc()
+
+	return nil
+}
+
+// hepPayload returns the SIP payload of a HEP3 packet.
+func hepPayload(packet []byte) ([]byte, error) {
+	if len(packet) < 6 || string(packet[:4]) != "HEP3" {
+		return nil, errors.New("not a HEP3 packet")
+	}
+
+	length := int(binary.BigEndian.Uint16(packet[4:6]))
+
+	if length > len(packet) {
+		return nil, errors.New("truncated HEP3 packet")
+	}
+
+	var payload []byte
+
+	for chunks := packet[6:length]; len(chunks) > 0; {
+		if len(chunks) < 6 {
+			return nil, errors.New("truncated HEP3 chunk")
+		}
+
+		vendor := binary.BigEndian.Uint16(chunks[0:2])
+		kind := binary.BigEndian.Uint16(chunks[2:4])
+		size := int(binary.BigEndian.Uint16(chunks[4:6]))
+
+		if size < 6 || size > len(chunks) {
+			return nil, errors.New("invalid HEP3 chunk length")
+		}
+
+		data := chunks[6:size]
+		chunks = chunks[size:]
+
+		if vendor != 0 {
+			continue
+		}
+
+		switch kind {
+		case hepChunkProtocolType:
+			if len(data) != 1 || data[0] != hepProtocolSIP {
+				return nil, errors.New("HEP3 packet does not carry SIP")
+			}
+		case hepChunkPayload:
+			payload = data
+		}
+	}
+
+	if payload == nil {
+		return nil, errors.New("HEP3 packet without payload")
+	}
+
+	return payload, nil
+}
+
+// hepMethod returns method, or "other" if it is not a common method.
+func hepMethod(method string) string {
+	if hepMethods[method] {
+		return method
+	}
+
+	return "other"
+}
+
+// cseqMethod returns the method of the CSeq header of headers, "" if not found.
+func cseqMethod(headers []byte) string {
+	for _, line := range strings.Split(string(headers), "\n") {
+		if strings.TrimSpace(line) == "" {
+			// end of the headers
+			break
+		}
+
+		name, value, found := strings.Cut(line, ":")
+
+		if !found || !strings.EqualFold(strings.TrimSpace(name), "CSeq") {
+			continue
+		}
+
+		if fields := strings.Fields(value); len(fields) == 2 {
+			return fields[1]
+		}
+
+		return ""
+	}
+
+	return ""
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// scrapeHistory keeps the last scrape attempts in a ring buffer, for /debug/scrapes.
+// It has its own mutex, so that the endpoint does not wait for a scrape in progress.
+type scrapeHistory struct {
+	mutex   sync.Mutex
+	records []scrapeRecord // ring buffer, empty if disabled
+	next    int            // index of the next record
+	full    bool
+}
+
+// scrapeRecord is a scrape attempt in the response of /debug/scrapes.
+type scrapeRecord struct {
+	Time             time.Time         `json:"time"`
+	Duration         float64           `json:"durationSeconds"`
+	URI              string            `json:"uri"` // that answered the connection attempt, "" if none did
+	SucceededMethods []string          `json:"succeededMethods"`
+	FailedMethods    map[string]string `json:"failedMethods,omitempty"` // errors by method
+	SkippedMethods   []string          `json:"skippedMethods,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	ErrorType        string            `json:"errorType,omitempty"`
+}
+
+// EnableScrapeHistory makes c keep its last size scrape attempts.
+func (c *Collector) EnableScrapeHistory(size int) {
+	c.history.mutex.Lock()
+	defer c.history.mutex.Unlock()
+
+	c.history.records = make([]scrapeRecord, size)
+	c.history.next = 0
+	c.history.full = false
+}
+
+// recordScrape records a scrape attempt started at start. failed holds the errors of the methods that failed, if any.
+func (c *Collector) recordScrape(start time.Time, succeeded []string, failed map[string]string, skipped []string, err error) {
+	c.health.mutex.Lock()
+	uri := c.health.activeURI
+	c.health.mutex.Unlock()
+
+	c.history.mutex.Lock()
+	defer c.history.mutex.Unlock()
+
+	if len(c.history.records) == 0 {
+		return
+	}
+
+	record := scrapeRecord{
+		Time:             start,
+		Duration:         time.Since(start).Seconds(),
+		URI:              uri,
+		SucceededMethods: succeeded,
+		FailedMethods:    failed,
+		SkippedMethods:   skipped,
+	}
+
+	if record.SucceededMethods == nil {
+		record.SucceededMethods = []string{}
+	}
+
+	if err != nil {
+		record.Error = err.Error()
+		record.ErrorType = scrapeErrorType(err)
+	}
+
+	c.history.records[c.history.next] = record
+	c.history.next = (c.history.next + 1) % len(c.history.records)
+	c.history.full = c.history.full || c.history.next == 0
+}
+
+// ScrapeHistory returns the recorded scrape attempts, the most recent first.
+func (c *Collector) ScrapeHistory() []scrapeRecord {
+	c.history.mutex.Lock()
+	defer c.history.mutex.Unlock()
+
+	n := c.history.next
+	if c.history.full {
+		n = len(c.history.records)
+	}
+
+	records := make([]scrapeRecord, 0, n)
+
+	for i := 1; i <= n; i++ {
+		records = append(records, c.history.records[(c.history.next-i+len(c.history.records))%len(c.history.records)])
+	}
+
+	return records
+}
+
+// scrapesHandler returns a handler listing the last scrape attempts as JSON, the most recent first,
+// to investigate intermittent failures after the fact.
+func scrapesHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("only GET requests allowed"))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, c.ScrapeHistory())
+	})
+}
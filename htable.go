@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> htable.stats
+{
+	name: ipban
+	slots: 256
+	all: 12
+	min: 0
+	max: 2
+}
+{
+	name: users
+	slots: 4096
+	all: 1530
+	min: 0
+	max: 3
+}
+*/
+
+// compileTableFilter compiles a regex matching whole table names. An empty regex returns nil.
+func compileTableFilter(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	regex, err := regexp.Compile("^(?:" + s + ")$")
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid htable filter: %w", err)
+	}
+
+	return regex, nil
+}
+
+// scrapeHTables passes the statistics of the hash tables to fn.
+// htable.stats returns every table, so tables added in the routing script are
+// collected without configuration, unless excluded by c.HTableInclude or c.HTableExclude.
+func (c *Collector) scrapeHTables(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	return c.streamBINRPC(ctx, "htable.stats", func(d *rpcDecoder) error {
+		return streamStructs(d, "htable.stats", func(fields map[string]binrpc.Record) error {
+			table := stringField(fields, "name")
+
+			if c.HTableInclude != nil && !c.HTableInclude.MatchString(table) {
+				return nil
+			}
+
+			if c.HTableExclude != nil && c.HTableExclude.MatchString(table) {
+				return nil
+			}
+
+			labels := map[string]string{"table": table}
+
+			for name, key := range map[string]string{
+				"slots":          "slots",
+				"items":          "all",
+				"slot_items_min": "min",
+				"slot_items_max": "max",
+			} {
+				if err := fn(name, MetricValue{Value: float64(intField(fields, key)), Labels: labels}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// values of Collector.TargetInfo
+const (
+	targetInfoOff    = "off"
+	targetInfoMetric = "metric" // exported by target_info
+	targetInfoLabels = "labels" // added to every metric of kamailio
+)
+
+// updateTargetLabels calls "core.version" and updates the labels identifying the target:
+// "version" and "target" if c.TargetName is set. c.mutex must be held.
+//
+// kamailio instances that do not answer core.version are exported with an empty version.
+func (c *Collector) updateTargetLabels(ctx context.Context) error {
+	version := ""
+
+	records, err := c.fetchBINRPC(ctx, "core.version")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		log.Printf("[warning] cannot get the version of kamailio: %v", records[1].Value)
+	} else if len(records) > 0 {
+		s, _ := records[0].String()
+		version = parseVersion(s)
+	}
+
+	labels := prometheus.Labels{"version": version}
+
+	if c.TargetName != "" {
+		labels["target"] = c.TargetName
+	}
+
+	if !equalLabels(labels, c.targetLabels) {
+		// descriptions contain the previous labels
+		c.descs = nil
+	}
+
+	c.targetLabels = labels
+
+	infoLabels := prometheus.Labels{}
+
+	for name, value := range c.Labels {
+		infoLabels[name] = value
+	}
+
+	for name, value := range labels {
+		infoLabels[name] = value
+	}
+
+	c.targetInfo = prometheus.MustNewConstMetric(
+		prometheus.NewDesc("target_info", "Information about the kamailio target, to be joined with its metrics.", nil, infoLabels),
+		prometheus.GaugeValue,
+		1,
+	)
+
+	return nil
+}
+
+// parseVersion returns the version of kamailio from the response of core.version, such as
+// "kamailio 5.6.2 (x86_64/linux) 2a4b6c".
+func parseVersion(s string) string {
+	if fields := strings.Fields(s); len(fields) > 1 {
+		return fields[1]
+	}
+
+	return s
+}
+
+// scrapeVersion passes the version of kamailio and its compile flags, from core.version and core.flags,
+// to fn as an info metric, so that the versions running across a fleet can be tracked. Unlike
+// c.TargetInfo, it is a method of its own, which may be called less often with c.MethodIntervals.
+func (c *Collector) scrapeVersion(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	labels := map[string]string{}
+
+	for _, method := range []string{"core.version", "core.flags"} {
+		records, err := c.fetchBINRPC(ctx, method)
+
+		if err != nil {
+			return err
+		}
+
+		if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+			code, _ := records[0].Int()
+			message, _ := records[1].String()
+
+			return &RPCError{Method: method, Code: code, Message: message}
+		}
+
+		if len(records) == 0 {
+			return fmt.Errorf(`invalid response for method "%s": %w`, method, errEmptyResponse)
+		}
+
+		s, err := records[0].String()
+
+		if err != nil {
+			return fmt.Errorf(`invalid response for method "%s": %w`, method, err)
+		}
+
+		if method == "core.version" {
+			labels["version"] = parseVersion(s)
+		} else {
+			labels["flags"] = s
+		}
+	}
+
+	return fn("info", MetricValue{Value: 1, Labels: labels})
+}
+
+// equalLabels returns true if a and b contain the same labels.
+func equalLabels(a, b prometheus.Labels) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for key, value := range a {
+		if other, found := b[key]; !found || other != value {
+			return false
+		}
+	}
+
+	return true
+}
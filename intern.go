@@ -0,0 +1,54 @@
+package main
+
+import "sync"
+
+// maxInterned bounds the number of interned strings. When reached, the table is reset,
+// so that a churn of unique values (e.g. dispatcher targets being replaced) cannot grow it forever.
+const maxInterned = 100000
+
+// interner deduplicates strings that repeat from one scrape to another, such as
+// codes, dispatcher URIs or hostnames used as label values.
+type interner struct {
+	mutex  sync.Mutex
+	values map[string]string
+}
+
+// labelValues is shared by all collectors.
+var labelValues interner
+
+// Bytes returns the string of b, reusing a previous string with the same content.
+// It does not allocate when b was already seen.
+func (i *interner) Bytes(b []byte) string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	// the compiler does not allocate for string(b) in a map lookup
+	if s, found := i.values[string(b)]; found {
+		return s
+	}
+
+	return i.store(string(b))
+}
+
+// String returns s, or a previous string with the same content.
+func (i *interner) String(s string) string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if v, found := i.values[s]; found {
+		return v
+	}
+
+	return i.store(s)
+}
+
+// store adds s to the table. i.mutex must be held.
+func (i *interner) store(s string) string {
+	if i.values == nil || len(i.values) >= maxInterned {
+		i.values = make(map[string]string)
+	}
+
+	i.values[s] = s
+
+	return s
+}
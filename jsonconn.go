@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// jsonConn is a connection to kamailio through calls returning JSON, for the "exec:" and "http:" scrape
+// URIs. Each request written is passed to call, with the method and its parameters, which returns the
+// result in JSON, like a JSON-RPC response of the jsonrpcs module. The result is translated to a BINRPC
+// response, read back by the usual decoders.
+type jsonConn struct {
+	call func(ctx context.Context, params []any) ([]byte, error)
+	addr net.Addr
+
+	deadline time.Time
+	requests bytes.Buffer // written, not yet complete
+	replies  bytes.Buffer // to be read
+}
+
+// Write implements io.Writer. Calls are made when their request is complete.
+func (c *jsonConn) Write(b []byte) (int, error) {
+	c.requests.Write(b)
+
+	for {
+		data := c.requests.Bytes()
+		reader := bytes.NewReader(data)
+		header, err := binrpc.ReadHeader(reader)
+
+		if err != nil || reader.Len() < header.PayloadLength {
+			// incomplete request
+			return len(b), nil
+		}
+
+		headerLength := len(data) - reader.Len()
+		payload := data[headerLength : headerLength+header.PayloadLength]
+
+		if err := c.run(header.Cookie, payload); err != nil {
+			return 0, err
+		}
+
+		c.requests.Next(headerLength + header.PayloadLength)
+	}
+}
+
+// run makes the call for the request of payload, and writes the response to c.replies.
+func (c *jsonConn) run(cookie uint32, payload []byte) error {
+	var params []any
+
+	for r := bytes.NewReader(payload); r.Len() > 0; {
+		record, err := binrpc.ReadRecord(r)
+
+		if err != nil {
+			return err
+		}
+
+		params = append(params, record.Value)
+	}
+
+	if len(params) == 0 {
+		return fmt.Errorf("%s: empty request", c.addr.Network())
+	}
+
+	ctx := context.Background()
+
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	output, err := c.call(ctx, params)
+
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+
+	if err := encodeJSONResponse(&body, output); err != nil {
+		return fmt.Errorf("%s %s %v: invalid output: %w", c.addr.Network(), c.addr, params[0], err)
+	}
+
+	length := body.Len()
+
+	c.replies.WriteByte(binrpc.BinRPCMagic<<4 | binrpc.BinRPCVersion)
+	c.replies.WriteByte(3<<2 | 3) // length and cookie on 4 bytes
+	c.replies.Write([]byte{byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length)})
+	c.replies.Write([]byte{byte(cookie >> 24), byte(cookie >> 16), byte(cookie >> 8), byte(cookie)})
+	body.WriteTo(&c.replies)
+
+	return nil
+}
+
+// Read implements io.Reader.
+func (c *jsonConn) Read(b []byte) (int, error) {
+	return c.replies.Read(b)
+}
+
+// encodeJSONResponse writes the BINRPC records of output, a JSON-RPC response or a bare result, to w.
+// Like the BINRPC responses of kamailio, error replies are a code followed by a message,
+// and a result made of several values (an array) is written as several records.
+func encodeJSONResponse(w *bytes.Buffer, output []byte) error {
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	result := json.RawMessage(output)
+
+	if err := json.Unmarshal(output, &response); err == nil {
+		if response.Error != nil {
+			if err := encodeRecord(w, binrpc.TypeInt, response.Error.Code); err != nil {
+				return err
+			}
+
+			return encodeRecord(w, binrpc.TypeString, response.Error.Message)
+		}
+
+		if response.Result != nil {
+			result = response.Result
+		}
+	}
+
+	d := json.NewDecoder(bytes.NewReader(result))
+	d.UseNumber()
+
+	token, err := d.Token()
+
+	if err != nil {
+		return err
+	}
+
+	if token != json.Delim('[') {
+		return encodeJSONValue(w, d, token)
+	}
+
+	for d.More() {
+		token, err := d.Token()
+
+		if err != nil {
+			return err
+		}
+
+		if err := encodeJSONValue(w, d, token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// encodeJSONValue writes the value starting with token to w, reading the rest of it from d.
+// The tokens are read in order, since the order of the keys of structs matters to some decoders.
+func encodeJSONValue(w *bytes.Buffer, d *json.Decoder, token json.Token) error {
+	switch v := token.(type) {
+	case json.Delim:
+		kind := binrpc.TypeStruct
+		if v == '[' {
+			kind = binrpc.TypeArray
+		}
+
+		w.WriteByte(kind)
+
+		for d.More() {
+			token, err := d.Token()
+
+			if err != nil {
+				return err
+			}
+
+			if kind == binrpc.TypeStruct {
+				if err := encodeRecord(w, binrpc.TypeAVP, token.(string)); err != nil {
+					return err
+				}
+
+				if token, err = d.Token(); err != nil {
+					return err
+				}
+			}
+
+			if err := encodeJSONValue(w, d, token); err != nil {
+				return err
+			}
+		}
+
+		// closing delimiter
+		if _, err := d.Token(); err != nil {
+			return err
+		}
+
+		w.WriteByte(1<<7 | kind)
+
+		return nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			encodeInt(w, binrpc.TypeInt, i)
+			return nil
+		}
+
+		f, err := v.Float64()
+
+		if err != nil {
+			return err
+		}
+
+		// doubles are implemented as int*1000
+		if math.Abs(f*1000) >= math.MaxInt64 {
+			return fmt.Errorf("number %s out of range", v)
+		}
+
+		encodeInt(w, binrpc.TypeDouble, int64(f*1000))
+
+		return nil
+	case string:
+		return encodeRecord(w, binrpc.TypeString, v)
+	case bool:
+		if v {
+			return encodeRecord(w, binrpc.TypeInt, 1)
+		}
+
+		return encodeRecord(w, binrpc.TypeInt, 0)
+	case nil:
+		return encodeRecord(w, binrpc.TypeString, "")
+	}
+
+	return fmt.Errorf("unexpected JSON token %v", token)
+}
+
+// encodeInt writes an int record, or a double record holding n/1000, to w. Values out of the 4 bytes of
+// the records of kamailio, such as the shared memory of large hosts, are written on 8 bytes, which the
+// decoders read in full, instead of being truncated.
+func encodeInt(w *bytes.Buffer, kind uint8, n int64) {
+	if n >= 0 && n <= math.MaxInt32 {
+		w.WriteByte(4<<4 | kind)
+		w.Write([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+
+		return
+	}
+
+	// the size does not fit in the 3 bits of the header: it follows it, on 1 byte
+	w.WriteByte(1<<7 | 1<<4 | kind)
+	w.WriteByte(8)
+
+	for shift := 56; shift >= 0; shift -= 8 {
+		w.WriteByte(byte(uint64(n) >> shift))
+	}
+}
+
+// encodeRecord writes a single record to w. AVP names are encoded like strings.
+func encodeRecord(w *bytes.Buffer, kind uint8, value any) error {
+	record := binrpc.Record{Type: kind, Value: value}
+
+	if kind == binrpc.TypeAVP {
+		record.Type = binrpc.TypeString
+	}
+
+	start := w.Len()
+
+	if err := record.Encode(w); err != nil {
+		return err
+	}
+
+	if kind == binrpc.TypeAVP {
+		b := w.Bytes()
+		b[start] = b[start]&0xF0 | binrpc.TypeAVP
+	}
+
+	return nil
+}
+
+// Close implements net.Conn.
+func (c *jsonConn) Close() error {
+	return nil
+}
+
+// LocalAddr implements net.Conn.
+func (c *jsonConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr implements net.Conn.
+func (c *jsonConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline implements net.Conn. The deadline bounds the calls made by later writes.
+func (c *jsonConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *jsonConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *jsonConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+func TestEncodeJSONNumbers(t *testing.T) {
+	tests := []struct {
+		json string
+		want any
+	}{
+		{`0`, 0},
+		{`42`, 42},
+		{`2147483647`, 2147483647},
+		{`2147483648`, 2147483648},
+		{`4294967296`, 4294967296},
+		{`5000000000`, 5000000000},
+		{`8589934592`, 8589934592},
+		{`-1`, -1},
+		{`-5000000000`, -5000000000},
+		{`0.25`, 0.25},
+		{`-0.25`, -0.25},
+		{`12345678.5`, 12345678.5},
+		{`8589934592.125`, 8589934592.125},
+	}
+
+	for _, test := range tests {
+		var w bytes.Buffer
+
+		if err := encodeJSONResponse(&w, []byte(`{"result": `+test.json+`}`)); err != nil {
+			t.Errorf("%s: %s", test.json, err)
+			continue
+		}
+
+		// the decoder of the library
+		record, err := binrpc.ReadRecord(bytes.NewReader(w.Bytes()))
+
+		if err != nil {
+			t.Errorf("%s: %s", test.json, err)
+		} else if record.Value != test.want {
+			t.Errorf("%s: binrpc.ReadRecord got %v, want %v", test.json, record.Value, test.want)
+		}
+
+		// the streaming decoder of the exporter
+		d, _ := newDecoder(bytes.NewReader(w.Bytes()), &binrpc.Header{PayloadLength: w.Len()})
+		next, err := d.Next()
+		releaseDecoder(d)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.json, err)
+		} else if next.Value != test.want {
+			t.Errorf("%s: rpcDecoder got %v, want %v", test.json, next.Value, test.want)
+		}
+	}
+}
+
+func TestEncodeJSONNumberOutOfRange(t *testing.T) {
+	var w bytes.Buffer
+
+	if err := encodeJSONResponse(&w, []byte(`{"result": 1e300}`)); err == nil {
+		t.Errorf("1e300: no error, got %x", w.Bytes())
+	}
+}
+
+func TestEncodeJSONStruct(t *testing.T) {
+	var w bytes.Buffer
+
+	output := `{"jsonrpc": "2.0", "result": {"total": 8589934592, "free": 6442450944, "used": 1024}, "id": 1}`
+
+	if err := encodeJSONResponse(&w, []byte(output)); err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := binrpc.ReadRecord(bytes.NewReader(w.Bytes()))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items, err := record.StructItems()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{"total": 8589934592, "free": 6442450944, "used": 1024}
+
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+
+	for _, item := range items {
+		if item.Value.Value != want[item.Key] {
+			t.Errorf("%s: got %v, want %d", item.Key, item.Value.Value, want[item.Key])
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// maxJSONRPCResponse bounds the size of the responses of the jsonrpcs module.
+const maxJSONRPCResponse = 64 << 20
+
+// jsonrpcClient makes the requests of the "http:" and "https:" scrape URIs, unless a target of /probe
+// has its own TLS settings. Its transport keeps the connections to kamailio open between scrapes.
+var jsonrpcClient = &http.Client{}
+
+// jsonrpcID is the id of the last JSON-RPC request.
+var jsonrpcID uint32
+
+// dialJSONRPC returns a connection calling kamailio through the jsonrpcs module, at u, e.g.
+// "https://kamailio:5060/RPC". Each request is POSTed as a JSON-RPC request with client, with the user
+// and password of u, if any, as basic authentication.
+func dialJSONRPC(u *url.URL, client *http.Client) *jsonConn {
+	endpoint := u.String()
+
+	call := func(ctx context.Context, params []any) ([]byte, error) {
+		request, err := json.Marshal(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  params[0],
+			"params":  params[1:],
+			"id":      atomic.AddUint32(&jsonrpcID, 1),
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(request))
+
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+
+		if err != nil {
+			// the error of the client contains the URL, with its password
+			var urlErr *url.Error
+
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+
+			return nil, fmt.Errorf("%s %s %v: %w", u.Scheme, u.Redacted(), params[0], err)
+		}
+
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxJSONRPCResponse))
+
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %v: %w", u.Scheme, u.Redacted(), params[0], err)
+		}
+
+		// jsonrpcs replies to failed calls with the code of the error as HTTP status, and the error in the body
+		if resp.StatusCode != http.StatusOK && !isJSONRPCError(body) {
+			return nil, fmt.Errorf("%s %s %v: %w", u.Scheme, u.Redacted(), params[0], &httpStatusError{resp.StatusCode, resp.Status})
+		}
+
+		return body, nil
+	}
+
+	return &jsonConn{call: call, addr: jsonrpcAddr{scheme: u.Scheme, url: u.Redacted()}}
+}
+
+// httpStatusError is an unexpected status of the HTTP server of kamailio, such as "401 Unauthorized".
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// isJSONRPCError returns true if body is a JSON-RPC response with an error.
+func isJSONRPCError(body []byte) bool {
+	var response struct {
+		Error *json.RawMessage `json:"error"`
+	}
+
+	return json.Unmarshal(body, &response) == nil && response.Error != nil
+}
+
+// jsonrpcAddr is the address of a connection of an "http:" or "https:" URI, without password.
+type jsonrpcAddr struct {
+	scheme string
+	url    string
+}
+
+// Network implements net.Addr.
+func (a jsonrpcAddr) Network() string {
+	return a.scheme
+}
+
+// String implements net.Addr.
+func (a jsonrpcAddr) String() string {
+	return a.url
+}
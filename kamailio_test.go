@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// fakeKamailio answers BINRPC requests on a unix socket, like the ctl module, with the results of
+// fixtures: JSON results, as printed by "kamctl rpc", by request ("pike.top ALL" for a method with
+// parameters). Keys of structs keep their order, and may repeat. Other methods are answered with
+// the error of kamailio for unknown commands, and system.listMethods lists the fixtures.
+type fakeKamailio struct {
+	URI string
+
+	mutex    sync.Mutex
+	payloads map[string][]byte
+	hangUp   string // request closing the connection without reply, like a crash of the process
+}
+
+// newFakeKamailio returns a fake kamailio serving fixtures until the end of tb.
+func newFakeKamailio(tb testing.TB, fixtures map[string]string) *fakeKamailio {
+	tb.Helper()
+
+	k := &fakeKamailio{payloads: make(map[string][]byte, len(fixtures)+1)}
+
+	methods := make([]string, 0, len(fixtures))
+
+	for request, result := range fixtures {
+		var payload bytes.Buffer
+
+		if err := encodeJSONResponse(&payload, []byte(result)); err != nil {
+			tb.Fatalf("fixture %s: %s", request, err)
+		}
+
+		k.payloads[request] = payload.Bytes()
+		method, _, _ := strings.Cut(request, " ")
+		methods = append(methods, `"`+method+`"`)
+	}
+
+	if _, found := k.payloads["system.listMethods"]; !found {
+		sort.Strings(methods)
+
+		var payload bytes.Buffer
+
+		encodeJSONResponse(&payload, []byte("["+strings.Join(methods, ",")+"]"))
+		k.payloads["system.listMethods"] = payload.Bytes()
+	}
+
+	path := filepath.Join(tb.TempDir(), "kamailio_ctl")
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go k.serve(conn)
+		}
+	}()
+
+	k.URI = "unix:" + path
+
+	return k
+}
+
+// set replaces the result of request with result, while k is serving.
+func (k *fakeKamailio) set(tb testing.TB, request string, result string) {
+	var payload bytes.Buffer
+
+	if err := encodeJSONResponse(&payload, []byte(result)); err != nil {
+		tb.Fatalf("fixture %s: %s", request, err)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	k.payloads[request] = payload.Bytes()
+}
+
+// serve answers the requests of conn until it is closed.
+func (k *fakeKamailio) serve(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		header, err := binrpc.ReadHeader(conn)
+
+		if err != nil {
+			return
+		}
+
+		payload := make([]byte, header.PayloadLength)
+
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return
+		}
+
+		var params []string
+
+		for r := bytes.NewReader(payload); r.Len() > 0; {
+			record, err := binrpc.ReadRecord(r)
+
+			if err != nil {
+				return
+			}
+
+			params = append(params, fmt.Sprint(record.Value))
+		}
+
+		k.mutex.Lock()
+		body, found := k.payloads[strings.Join(params, " ")]
+		hangUp := k.hangUp == strings.Join(params, " ")
+		k.mutex.Unlock()
+
+		if hangUp {
+			return
+		}
+
+		if !found {
+			var reply bytes.Buffer
+
+			encodeRecord(&reply, binrpc.TypeInt, 500)
+			encodeRecord(&reply, binrpc.TypeString, "command "+params[0]+" not found")
+			body = reply.Bytes()
+		}
+
+		if _, err := conn.Write(binrpcResponse(header.Cookie, body)); err != nil {
+			return
+		}
+	}
+}
+
+// binrpcResponse returns a BINRPC response to the request of cookie, with the records of body.
+func binrpcResponse(cookie uint32, body []byte) []byte {
+	length := len(body)
+
+	response := []byte{
+		binrpc.BinRPCMagic<<4 | binrpc.BinRPCVersion,
+		3<<2 | 3, // length and cookie on 4 bytes
+		byte(length >> 24), byte(length >> 16), byte(length >> 8), byte(length),
+		byte(cookie >> 24), byte(cookie >> 16), byte(cookie >> 8), byte(cookie),
+	}
+
+	return append(response, body...)
+}
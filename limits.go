@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is the mount point of the cgroup filesystem. In a container with its own cgroup
+// namespace, the files at its root are those of the container.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// memoryLimitRatio is the part of the memory limit of the cgroup used as Go memory limit with
+// --runtime.memory-limit=auto, leaving room for memory not managed by the Go runtime.
+const memoryLimitRatio = 0.9
+
+// SetMaxProcsFromCgroup sets GOMAXPROCS to the CPU limit of the cgroup, rounded down (at least 1),
+// so that a CPU-limited container does not run a thread per core of the host and get throttled.
+// It does nothing if the GOMAXPROCS environment variable is set, or if there is no limit.
+func SetMaxProcsFromCgroup() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return
+	}
+
+	quota, err := cgroupCPUQuota()
+
+	if err != nil {
+		log.Println("[warning] cannot read the CPU limit of the cgroup:", err)
+		return
+	}
+
+	if quota <= 0 {
+		return
+	}
+
+	procs := int(math.Floor(quota))
+	if procs < 1 {
+		procs = 1
+	}
+
+	if procs < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(procs)
+		log.Printf("[info] GOMAXPROCS set to %d, from the CPU limit of the cgroup", procs)
+	}
+}
+
+// cgroupCPUQuota returns the number of CPUs allowed by the cgroup (v2 or v1), 0 if there is no limit.
+func cgroupCPUQuota() (float64, error) {
+	// cgroup v2: "max 100000" or "200000 100000"
+	if b, err := os.ReadFile(cgroupRoot + "/cpu.max"); err == nil {
+		fields := strings.Fields(string(b))
+
+		if len(fields) != 2 {
+			return 0, fmt.Errorf("invalid cpu.max %q", b)
+		}
+
+		if fields[0] == "max" {
+			return 0, nil
+		}
+
+		return cpuQuota(fields[0], fields[1])
+	}
+
+	// cgroup v1: a quota of -1 means no limit
+	for _, dir := range []string{"/cpu", "/cpu,cpuacct"} {
+		quota, err := os.ReadFile(cgroupRoot + dir + "/cpu.cfs_quota_us")
+
+		if err != nil {
+			continue
+		}
+
+		period, err := os.ReadFile(cgroupRoot + dir + "/cpu.cfs_period_us")
+
+		if err != nil {
+			return 0, err
+		}
+
+		if strings.TrimSpace(string(quota)) == "-1" {
+			return 0, nil
+		}
+
+		return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+
+	return 0, nil
+}
+
+// cpuQuota returns quota/period, in number of CPUs.
+func cpuQuota(quota string, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid CPU quota: %w", err)
+	}
+
+	p, err := strconv.ParseFloat(period, 64)
+
+	if err != nil || p <= 0 {
+		return 0, fmt.Errorf("invalid CPU period %q", period)
+	}
+
+	return q / p, nil
+}
+
+// cgroupMemoryLimit returns the memory limit of the cgroup (v2 or v1) in bytes, 0 if there is no limit.
+func cgroupMemoryLimit() (int64, error) {
+	b, err := os.ReadFile(cgroupRoot + "/memory.max")
+
+	if err != nil {
+		if b, err = os.ReadFile(cgroupRoot + "/memory/memory.limit_in_bytes"); err != nil {
+			return 0, errors.New("no memory controller found in " + cgroupRoot)
+		}
+	}
+
+	value := strings.TrimSpace(string(b))
+
+	if value == "max" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory limit %q", value)
+	}
+
+	// cgroup v1 reports a huge value, rounded to the page size, when there is no limit
+	if limit >= 1<<60 {
+		return 0, nil
+	}
+
+	return limit, nil
+}
+
+// ParseMemoryLimit parses the value of --runtime.memory-limit: a size such as "256MiB" or "1GB",
+// or "auto" for a part of the memory limit of the cgroup. It returns 0 if s is empty, or if
+// there is no cgroup limit with "auto".
+func ParseMemoryLimit(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if s == "auto" {
+		limit, err := cgroupMemoryLimit()
+
+		if err != nil {
+			return 0, err
+		}
+
+		return int64(float64(limit) * memoryLimitRatio), nil
+	}
+
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+		{"B", 1},
+	}
+
+	for _, unit := range units {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+
+			if err != nil || n <= 0 {
+				break
+			}
+
+			return int64(n * float64(unit.size)), nil
+		}
+	}
+
+	return 0, fmt.Errorf(`invalid memory limit "%s", expected a size such as "256MiB", or "auto"`, s)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// logLevelRegex matches the level of kamailio log messages, e.g. " 0(12) ERROR: <core> [core/tcp_main.c:1233]: ...".
+var logLevelRegex = regexp.MustCompile(`\b(ALERT|BUG|CRITICAL|ERROR|WARNING):`)
+
+// logPollInterval is the interval at which the log file is checked for new lines and rotation.
+const logPollInterval = time.Second
+
+// logReason classifies log messages matching Regex, in the reason label.
+type logReason struct {
+	Name  string
+	Regex *regexp.Regexp
+}
+
+// ParseLogReasons parses reasons in the form "name=regex".
+func ParseLogReasons(reasons []string) ([]logReason, error) {
+	parsed := make([]logReason, 0, len(reasons))
+
+	for _, reason := range reasons {
+		name, expr, found := strings.Cut(reason, "=")
+
+		if !found || name == "" || expr == "" {
+			return nil, fmt.Errorf(`invalid log reason "%s", expected "name=regex"`, reason)
+		}
+
+		regex, err := regexp.Compile(expr)
+
+		if err != nil {
+			return nil, fmt.Errorf(`invalid regex of log reason "%s": %w`, name, err)
+		}
+
+		parsed = append(parsed, logReason{Name: name, Regex: regex})
+	}
+
+	return parsed, nil
+}
+
+// LogWatcher counts the warnings and errors of the log of kamailio, read from a file or journald.
+// Memory exhaustion and database failures often appear in the log minutes before they show in RPC stats.
+type LogWatcher struct {
+	File    string // path of the log file, or
+	Unit    string // systemd unit read with journalctl
+	Reasons []logReason
+
+	messages *prometheus.CounterVec
+}
+
+// NewLogWatcher returns a new LogWatcher reading file, or the journal of unit if file is empty.
+func NewLogWatcher(file string, unit string, reasons []logReason) *LogWatcher {
+	w := &LogWatcher{
+		File:    file,
+		Unit:    unit,
+		Reasons: reasons,
+
+		messages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_messages_total",
+			Help:      "Number of log messages of kamailio, by level and reason.",
+		}, []string{"level", "reason"}),
+	}
+
+	// initialize the series, so that increases from 0 are visible
+	for _, level := range []string{"alert", "bug", "critical", "error", "warning"} {
+		w.messages.WithLabelValues(level, "other")
+
+		for _, reason := range reasons {
+			w.messages.WithLabelValues(level, reason.Name)
+		}
+	}
+
+	return w
+}
+
+// Describe implements prometheus.Collector.
+func (w *LogWatcher) Describe(ch chan<- *prometheus.Desc) {
+	w.messages.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *LogWatcher) Collect(ch chan<- prometheus.Metric) {
+	w.messages.Collect(ch)
+}
+
+// Start starts reading the log in the background. Only new lines are counted.
+func (w *LogWatcher) Start() {
+	if w.File != "" {
+		go w.tailFile()
+	} else {
+		go w.tailJournal()
+	}
+}
+
+// count counts line, if it is a warning or an error.
+func (w *LogWatcher) count(line string) {
+	match := logLevelRegex.FindStringSubmatch(line)
+
+	if match == nil {
+		return
+	}
+
+	reason := "other"
+
+	for _, r := range w.Reasons {
+		if r.Regex.MatchString(line) {
+			reason = r.Name
+			break
+		}
+	}
+
+	w.messages.WithLabelValues(strings.ToLower(match[1]), reason).Inc()
+}
+
+// tailFile reads the lines appended to the log file, following rotations (renamed or truncated files).
+func (w *LogWatcher) tailFile() {
+	var (
+		file    *os.File
+		reader  *bufio.Reader
+		offset  int64
+		partial string // line being written
+		started bool
+	)
+
+	for {
+		if file == nil {
+			f, err := os.Open(w.File)
+
+			if err != nil {
+				log.Println("[error] cannot open log file:", err)
+				time.Sleep(10 * logPollInterval)
+				continue
+			}
+
+			// messages logged before the exporter started are not counted, but those of new files are
+			whence := io.SeekStart
+			if !started {
+				whence = io.SeekEnd
+				started = true
+			}
+
+			if offset, err = f.Seek(0, whence); err != nil {
+				log.Println("[error] cannot read log file:", err)
+				f.Close()
+				time.Sleep(10 * logPollInterval)
+				continue
+			}
+
+			file, reader, partial = f, bufio.NewReader(f), ""
+		}
+
+		line, err := reader.ReadString('\n')
+		offset += int64(len(line))
+		partial += line
+
+		if err == nil {
+			w.count(partial)
+			partial = ""
+			continue
+		}
+
+		if err != io.EOF {
+			log.Println("[error] cannot read log file:", err)
+		}
+
+		time.Sleep(logPollInterval)
+
+		if w.rotated(file, offset) {
+			// count the lines written to the old file since the last read
+			for {
+				line, err := reader.ReadString('\n')
+				partial += line
+
+				if err != nil {
+					break
+				}
+
+				w.count(partial)
+				partial = ""
+			}
+
+			file.Close()
+			file = nil
+		}
+	}
+}
+
+// rotated returns true if the log file is no longer file, or was truncated below offset.
+func (w *LogWatcher) rotated(file *os.File, offset int64) bool {
+	info, err := os.Stat(w.File)
+
+	if err != nil {
+		// being rotated, keep reading the old file until the new one appears
+		return false
+	}
+
+	current, err := file.Stat()
+
+	if err != nil {
+		return true
+	}
+
+	return !os.SameFile(info, current) || info.Size() < offset
+}
+
+// tailJournal reads the new messages of the unit with journalctl, restarting it if it exits.
+func (w *LogWatcher) tailJournal() {
+	for {
+		if err := w.readJournal(); err != nil {
+			log.Println("[error] cannot read journal:", err)
+		}
+
+		time.Sleep(10 * logPollInterval)
+	}
+}
+
+// readJournal runs journalctl until it exits, counting the messages of the unit.
+func (w *LogWatcher) readJournal() error {
+	cmd := exec.Command("journalctl", "--follow", "--lines=0", "--output=cat", "--unit="+w.Unit)
+
+	stdout, err := cmd.StdoutPipe()
+
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(stdout)
+
+	for scanner.Scan() {
+		w.count(scanner.Text())
+	}
+
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return err
+	}
+
+	return cmd.Wait()
+}
@@ -1,43 +1,444 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/exporter-toolkit/web"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 func main() {
 	var (
-		listenAddress = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Short('l').Default(":9494").String()
-		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
-		scrapeURI     = kingpin.Flag("kamailio.scrape-uri", `URI on which to scrape kamailio. E.g. "unix:/var/run/kamailio/kamailio_ctl" or "tcp://localhost:2049"`).Short('u').Default("unix:/var/run/kamailio/kamailio_ctl").String()
-		methods       = kingpin.Flag("kamailio.methods", `Comma-separated list of methods to call. E.g. "tm.stats,sl.stats". Implemented: `+strings.Join(availableMethods, ",")).Short('m').Default("tm.stats,sl.stats,core.shmmem,core.uptime,core.tcp_info").String()
-		timeout       = kingpin.Flag("kamailio.timeout", "Timeout for trying to get stats from kamailio.").Short('t').Default("5s").Duration()
+		configFile      = kingpin.Flag("config.file", "Path to the configuration file. Its values override the kamailio flags.").Default("").String()
+		watchConfig     = kingpin.Flag("config.watch", "Reload the configuration file automatically when it changes.").Default("false").Bool()
+		watchDelay      = kingpin.Flag("config.watch-delay", "Delay to wait for further changes before reloading a watched configuration file.").Default("2s").Duration()
+		listenAddress   = kingpin.Flag("web.listen-address", "Address to listen on for web interface and telemetry.").Short('l').Default(":9494").String()
+		webConfigFile   = kingpin.Flag("web.config.file", "Path to the web configuration file of the Prometheus exporter toolkit, enabling TLS (and client certificates) and basic authentication on every endpoint.").Default("").String()
+		metricsPath     = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		externalURL     = kingpin.Flag("web.external-url", "URL under which the exporter is externally reachable, e.g. behind a reverse proxy mounting it under a sub-path. Used for the links of the landing page, and as the default route prefix.").Default("").String()
+		routePrefix     = kingpin.Flag("web.route-prefix", "Prefix of the routes of the web endpoints. Defaults to the path of --web.external-url.").Default("").String()
+		enableLifecycle = kingpin.Flag("web.enable-lifecycle", "Enable shutdown and reload via HTTP request (PUT or POST on /-/quit and /-/reload). Requires --web.admin-token-file.").Default("false").Bool()
+		enableStatus    = kingpin.Flag("web.enable-status", "Enable the /status page, showing the last values collected for each method with their deltas.").Default("false").Bool()
+		enableProbe     = kingpin.Flag("web.enable-probe", "Enable /probe?target=tcp://host:2049, scraping the given kamailio instance like the blackbox exporter, with optional methods and timeout parameters.").Default("false").Bool()
+		enableTargets   = kingpin.Flag("web.enable-target-metrics", "Enable /metrics/<name>, scraping the target <name> of the targets block of the configuration file, like /probe?target=<name>.").Default("false").Bool()
+		enableDebug     = kingpin.Flag("web.enable-debug", "Enable debug endpoints, such as /debug/rpc?method=tm.stats. Requires --web.admin-token-file.").Default("false").Bool()
+		scrapeHistory   = kingpin.Flag("web.debug-scrape-history", "Number of scrape attempts listed by /debug/scrapes, with --web.enable-debug.").Default("50").Int()
+		rpcAllowlist    = kingpin.Flag("web.rpc-allowlist", "Comma-separated list of methods that can be called with POST on /api/v1/rpc. Empty disables the endpoint. Requires --web.admin-token-file.").Default("").String()
+		adminTokenFile  = kingpin.Flag("web.admin-token-file", "File containing a bearer token required by administrative endpoints.").Default("").String()
+		alertmanagerURL = kingpin.Flag("alertmanager.url", `URL of an Alertmanager to which an alert is posted when kamailio is down, for sites without a local Prometheus. E.g. "http://alertmanager:9093". Empty disables alerts.`).Default("").String()
+		alertThreshold  = kingpin.Flag("alertmanager.failure-threshold", "Number of scrapes failing in a row before posting the alert.").Default("3").Int()
+		heartbeatURL    = kingpin.Flag("heartbeat.url", `URL requested after each successful scrape, as a dead man's switch for services such as Healthchecks.io. Empty disables the heartbeat.`).Default("").String()
+		heartbeatMethod = kingpin.Flag("heartbeat.method", "HTTP method of the heartbeat requests.").Default("GET").Enum("GET", "POST")
+		webhookURLs     = kingpin.Flag("webhook.url", "URL to which state changes are posted as JSON: kamailio_up transitions, dispatcher target states and DMQ peers. Can be repeated.").Strings()
+		maintenanceFile = kingpin.Flag("maintenance.file", "File whose presence puts kamailio in maintenance: failed scrapes do not set kamailio_up to 0. Maintenance can also be toggled on /-/maintenance with --web.enable-lifecycle and --web.admin-token-file.").Default("").String()
+		mqttBroker      = kingpin.Flag("mqtt.broker", `URL of an MQTT broker to which snapshots of the metrics are published, e.g. "tcp://broker:1883" or "ssl://broker:8883". Empty disables publishing.`).Default("").String()
+		mqttTopic       = kingpin.Flag("mqtt.topic", `Topic of the snapshots, with "{target}" (--kamailio.target-name, or the host name) and "{method}" placeholders.`).Default("kamailio/{target}/{method}").String()
+		mqttInterval    = kingpin.Flag("mqtt.interval", "Interval between snapshots.").Default("1m").Duration()
+		mqttClientID    = kingpin.Flag("mqtt.client-id", "Client ID of the MQTT connection. Defaults to kamailio_exporter_<pid>.").Default("").String()
+		mqttUsername    = kingpin.Flag("mqtt.username", "Username of the MQTT connection.").Default("").String()
+		mqttPassword    = kingpin.Flag("mqtt.password-file", "File containing the password of the MQTT connection.").Default("").String()
+		mqttRetain      = kingpin.Flag("mqtt.retain", "Publish the snapshots as retained messages.").Default("false").Bool()
+		scrapeURI       = kingpin.Flag("kamailio.scrape-uri", `URI on which to scrape kamailio. E.g. "unix:/var/run/kamailio/kamailio_ctl" or "tcp://localhost:2049". Datagram sockets of the ctl module are supported with "unixgram:" and "udp://", the jsonrpcs module with "http://" and "https://", and the MI FIFO of older versions with "fifo:". Several comma-separated URIs are tried in order.`).Short('u').Default("unix:/var/run/kamailio/kamailio_ctl").String()
+		methods         = kingpin.Flag("kamailio.methods", `Comma-separated list of methods to call. E.g. "tm.stats,sl.stats". Implemented: `+strings.Join(availableMethods, ",")).Short('m').Default("tm.stats,sl.stats,core.shmmem,core.uptime,core.tcp_info").HintOptions(availableMethods...).String()
+		dnsTTL          = kingpin.Flag("kamailio.dns-ttl", "Cache the addresses of tcp:// scrape URIs for this duration, and resolve again when connections fail. 0 resolves on every connection.").Default("0s").Duration()
+		binrpcCookie    = kingpin.Flag("kamailio.binrpc-cookie", `Size of the cookie of BINRPC requests: "fixed" (4 bytes, like kamcmd) or "compact" (minimum size). Change it if kamailio replies with an unexpected cookie.`).Default("fixed").Enum("fixed", "compact")
+		pipeline        = kingpin.Flag("kamailio.pipeline", "Write the requests of all methods before reading the responses, so that a scrape costs one round trip instead of one per method. Methods decoded while reading their response are still called one by one.").Default("false").Bool()
+		persistent      = kingpin.Flag("kamailio.persistent-connection", "Keep the connection to kamailio open between scrapes, instead of connecting on each scrape. Stream sockets only.").Default("false").Bool()
+		keepalive       = kingpin.Flag("kamailio.keepalive-interval", "Check the persistent connection with core.echo when it has been idle for this duration, and reconnect if it is broken. 0 disables the check.").Default("30s").Duration()
+		waitStartup     = kingpin.Flag("kamailio.wait-startup", "Wait up to this duration for kamailio to accept connections before serving metrics. 0 disables waiting.").Default("0s").Duration()
+		selfTest        = kingpin.Flag("kamailio.self-test", `Call each method once at startup and report the results. "strict" refuses to start if a method fails.`).Default("off").Enum("off", "report", "strict")
+		collectInterval = kingpin.Flag("kamailio.collect-interval", "Collect metrics in the background at this interval, instead of on each scrape. 0 disables background collection.").Default("0s").Duration()
+		methodIntervals = kingpin.Flag("kamailio.method-intervals", `Comma-separated list of per-method collection intervals in background mode. E.g. "core.shmmem=5s,dispatcher.list=60s"`).Default("").String()
+		collectTimes    = kingpin.Flag("kamailio.collect-timestamps", "In background mode, export samples with the time of their collection instead of the time of the scrape.").Default("false").Bool()
+		gracePeriod     = kingpin.Flag("kamailio.grace-period", "Serve the last successful values, with kamailio_data_stale set to 1, when scrapes fail for less than this period, e.g. during restarts of kamailio. 0 disables the grace period.").Default("0s").Duration()
+		slowScrape      = kingpin.Flag("kamailio.slow-scrape-threshold", "Log the duration of each method of the scrapes lasting longer than this. 0 disables the log.").Default("0s").Duration()
+		procfsPath      = kingpin.Flag("kamailio.procfs-path", "Mount point of the procfs of the host of kamailio, used by core.psx to read the resources of its processes.").Default("/proc").String()
+		htableInclude   = kingpin.Flag("kamailio.htable-include", "Regex of the names of the hash tables collected by htable.stats. Empty collects every table.").Default("").String()
+		htableExclude   = kingpin.Flag("kamailio.htable-exclude", "Regex of the names of the hash tables excluded from htable.stats.").Default("").String()
+		dispatcherNorm  = kingpin.Flag("kamailio.dispatcher-uri-normalize", `Comma-separated list of normalizations of the URIs of dispatcher.list targets: "strip-params", "strip-port", "lowercase-host", "hash". Keeps label values stable when destinations are re-added with different parameters.`).Default("").String()
+		domainInfo      = kingpin.Flag("kamailio.domain-info", "Export an info series per domain of domain.dump, with its domain ID, in addition to the number of domains.").Default("false").Bool()
+		stringValues    = kingpin.Flag("kamailio.string-values", "Export the string values of the responses, such as the attributes of dispatcher targets, as string_info metrics with the value as a label, instead of dropping them.").Default("false").Bool()
+		dlgListMax      = kingpin.Flag("kamailio.dlg-list-max-dialogs", "Skip dlg.list when dlg.stats_active reports more active dialogs, since listing them is costly for kamailio. 0 disables the check.").Default("10000").Int()
+		dialogLabel     = kingpin.Flag("kamailio.dialog-label", `Count the active dialogs of dlg.list by a label extracted from their URIs, in the form "name=field:regex". The first group of the regex, or the whole match, is the value. E.g. "to_domain=to_uri:@([^;>:]+)"`).Default("").String()
+		dialogLabelMax  = kingpin.Flag("kamailio.dialog-label-max-values", `Maximum number of values of the dialog label. Less frequent values are counted as "other".`).Default("100").Int()
+		dialogProfiles  = kingpin.Flag("kamailio.dialog-profiles", `Comma-separated list of the dialog profiles of dlg.profile_get_size, with the values to count for profiles with values, exported as kamailio_dlg_profile_size. E.g. "trunk=carrier-a|carrier-b,inbound"`).Default("").String()
+		statsGroups     = kingpin.Flag("kamailio.stats-groups", `Comma-separated list of the statistics groups of stats.fetch, exported by group and name. "all" exports every statistic, "group:name" a single one. E.g. "registrar,usrloc,shmem"`).Default("all").String()
+		modStatsLevel   = kingpin.Flag("kamailio.mod-stats-level", `Level of detail of the shared memory of mod.stats: by "module", or also by "function" or allocation site ("line") of each module.`).Default("module").Enum("module", "function", "line")
+		targetInfo      = kingpin.Flag("kamailio.target-info", `Export the version of kamailio (and the target name) with a target_info metric ("metric"), or as labels of every kamailio metric ("labels").`).Default("off").Enum("off", "metric", "labels")
+		targetName      = kingpin.Flag("kamailio.target-name", `Name of the target, exported as the "target" label with --kamailio.target-info.`).Default("").String()
+		labels          = kingpin.Flag("kamailio.labels", `Comma-separated list of constant labels added to every kamailio metric. E.g. "datacenter=par1,role=edge"`).Default("").String()
+		xhttpPromURL    = kingpin.Flag("kamailio.xhttp-prom-url", `URL of the metrics exposed by the xhttp_prom module of kamailio, merged into the metrics of the exporter. E.g. "http://localhost:8080/metrics". Empty disables the merge.`).Default("").String()
+		xhttpPromSource = kingpin.Flag("kamailio.xhttp-prom-source-prefix", "Prefix removed from the names of the xhttp_prom metrics (xhttp_prom_pref of the module).").Default("kamailio_").String()
+		xhttpPromPrefix = kingpin.Flag("kamailio.xhttp-prom-prefix", "Prefix added to the names of the xhttp_prom metrics.").Default("kamailio_xhttp_prom_").String()
+		logFile         = kingpin.Flag("kamailio.log-file", "Log file of kamailio, whose warnings and errors are counted. Rotated files are followed.").Default("").String()
+		logUnit         = kingpin.Flag("kamailio.log-journal-unit", `Systemd unit of kamailio, whose warnings and errors are counted from journald with journalctl, unless --kamailio.log-file is set. E.g. "kamailio.service"`).Default("").String()
+		logReasons      = kingpin.Flag("kamailio.log-reason", `Reason of the counted log messages matching a regex, in the form "name=regex". Can be repeated, the first match wins. E.g. "memory=(no more|out of) (shm|pkg|private) mem"`).Strings()
+		hepAddress      = kingpin.Flag("hep.listen-address", `UDP address on which to receive the HEP3 packets sent by the siptrace module of kamailio, to count SIP requests and responses. E.g. ":9060". Empty disables the listener.`).Default("").String()
+		autoMaxProcs    = kingpin.Flag("runtime.gomaxprocs-from-cgroup", "Set GOMAXPROCS to the CPU limit of the cgroup (container), unless the GOMAXPROCS environment variable is set.").Default("true").Bool()
+		memoryLimit     = kingpin.Flag("runtime.memory-limit", `Soft memory limit of the Go runtime, like GOMEMLIMIT, e.g. "256MiB". "auto" uses 90% of the memory limit of the cgroup (container). Empty leaves GOMEMLIMIT in effect.`).Default("").String()
+		timeout         = kingpin.Flag("kamailio.timeout", "Timeout of a whole scrape of kamailio, including name resolution, connection and all method calls.").Short('t').Default("5s").Duration()
+		timeoutOffset   = kingpin.Flag("kamailio.timeout-offset", "Subtracted from the scrape timeout sent by Prometheus, which applies instead of --kamailio.timeout when shorter, to leave time to send the response.").Default("500ms").Duration()
 	)
 
-	kingpin.Parse()
+	kingpin.Command("serve", "Run the exporter. This is the default command.").Default()
 
-	c, err := NewCollector(*scrapeURI, *timeout, *methods)
+	completionCmd := kingpin.Command("completion", "Print the shell completion script of the flags and commands, e.g. `source <(kamailio_exporter completion bash)`.")
+	completionShell := completionCmd.Arg("shell", `Shell: "bash", "zsh" or "fish".`).Required().HintOptions("bash", "zsh", "fish").Enum("bash", "zsh", "fish")
+
+	printConfigCmd := kingpin.Command("print-config", "Print the effective configuration, merged from the flags and the configuration file, and exit.")
+	exampleConfigCmd := kingpin.Command("example-config", "Print a commented example of the configuration file, and exit.")
+	doctorCmd := kingpin.Command("doctor", "Check the connection to kamailio and each configured method, print a report with hints, and exit. The exit status is 1 if a problem is found.")
+
+	command := kingpin.Parse()
+
+	switch command {
+	case completionCmd.FullCommand():
+		if err := writeCompletionScript(os.Stdout, kingpin.CommandLine.Name, *completionShell); err != nil {
+			log.Fatalln("[error] cannot write completion script:", err)
+		}
+
+		return
+	case exampleConfigCmd.FullCommand():
+		if err := WriteExampleConfig(os.Stdout); err != nil {
+			log.Fatalln("[error] cannot write example config:", err)
+		}
+
+		return
+	}
+
+	if *autoMaxProcs {
+		SetMaxProcsFromCgroup()
+	}
+
+	if limit, err := ParseMemoryLimit(*memoryLimit); err != nil {
+		panic(err)
+	} else if limit > 0 {
+		SetMemoryLimit(limit)
+	}
+
+	intervals, err := ParseMethodIntervals(*methodIntervals)
+
+	if err != nil {
+		panic(err)
+	}
+
+	constLabels, err := ParseLabels(*labels)
+
+	if err != nil {
+		panic(err)
+	}
+
+	dlgLabel, err := ParseDialogLabel(*dialogLabel, *dialogLabelMax)
+
+	if err != nil {
+		panic(err)
+	}
+
+	dlgProfiles, err := ParseDialogProfiles(*dialogProfiles)
+
+	if err != nil {
+		panic(err)
+	}
+
+	var uriNormalize []string
+
+	if *dispatcherNorm != "" {
+		uriNormalize = strings.Split(*dispatcherNorm, ",")
+	}
+
+	loader := NewConfigLoader(*configFile, Config{
+		ScrapeURI:              *scrapeURI,
+		Methods:                strings.Split(*methods, ","),
+		Timeout:                timeout,
+		DNSTTL:                 dnsTTL,
+		BINRPCCookie:           *binrpcCookie,
+		Pipeline:               pipeline,
+		PersistentConnection:   persistent,
+		KeepaliveInterval:      keepalive,
+		DomainInfo:             domainInfo,
+		StringValues:           stringValues,
+		CollectInterval:        collectInterval,
+		MethodIntervals:        intervals,
+		CollectTimestamps:      collectTimes,
+		GracePeriod:            gracePeriod,
+		SlowScrapeThreshold:    slowScrape,
+		ProcfsPath:             *procfsPath,
+		HTableInclude:          *htableInclude,
+		HTableExclude:          *htableExclude,
+		DispatcherURINormalize: uriNormalize,
+		DlgListMaxDialogs:      dlgListMax,
+		DialogLabel:            dlgLabel,
+		DialogProfiles:         dlgProfiles,
+		StatsGroups:            strings.Split(*statsGroups, ","),
+		ModStatsLevel:          *modStatsLevel,
+		TargetInfo:             *targetInfo,
+		TargetName:             *targetName,
+		Labels:                 constLabels,
+	})
+
+	if command == printConfigCmd.FullCommand() {
+		if err := loader.WriteEffectiveConfig(os.Stdout); err != nil {
+			log.Fatalln("[error]", err)
+		}
+
+		return
+	}
+
+	if *webConfigFile != "" {
+		if err := web.Validate(*webConfigFile); err != nil {
+			log.Fatalln("[error] invalid web configuration file:", err)
+		}
+	}
+
+	c, err := loader.Collector()
+
+	if err != nil {
+		panic(err)
+	}
+
+	if command == doctorCmd.FullCommand() {
+		if !c.Doctor(os.Stdout) {
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	adminToken, err := readTokenFile(*adminTokenFile)
+
+	if err != nil {
+		panic(err)
+	}
+
+	// /debug/rpc calls kamailio on behalf of the caller, hence a token is required
+	if *enableDebug && adminToken == "" {
+		log.Fatalln("[error] --web.enable-debug requires --web.admin-token-file")
+	}
+
+	// /-/quit stops the exporter, and /-/reload and the other lifecycle endpoints change what is collected
+	if *enableLifecycle && adminToken == "" {
+		log.Fatalln("[error] --web.enable-lifecycle requires --web.admin-token-file")
+	}
+
+	// /api/v1/rpc is a remote kamcmd, which may change the state of kamailio
+	if *rpcAllowlist != "" && adminToken == "" {
+		log.Fatalln("[error] --web.rpc-allowlist requires --web.admin-token-file")
+	}
+
+	linkPrefix, err := externalPath(*externalURL)
 
 	if err != nil {
 		panic(err)
 	}
 
-	prometheus.MustRegister(c)
+	// the proxy may strip the path of the external URL, hence a separate route prefix
+	prefix := linkPrefix
+	if *routePrefix != "" {
+		prefix = normalizeRoutePrefix(*routePrefix)
+	}
+
+	if *waitStartup > 0 {
+		if err := c.WaitReady(*waitStartup); err != nil {
+			log.Println("[warning]", err)
+		}
+	}
+
+	if *selfTest != "off" {
+		if err := c.SelfTest(); err != nil {
+			if *selfTest == "strict" {
+				log.Fatalln("[error]", err)
+			}
+
+			log.Println("[warning]", err)
+		}
+	}
+
+	if *alertmanagerURL != "" {
+		c.EnableAlerts(*alertmanagerURL, *alertThreshold)
+	}
+
+	if *maintenanceFile != "" {
+		c.SetMaintenanceFile(*maintenanceFile)
+	}
+
+	if len(*webhookURLs) > 0 {
+		c.EnableWebhooks(*webhookURLs)
+	}
+
+	if *heartbeatURL != "" {
+		c.EnableHeartbeat(*heartbeatURL, *heartbeatMethod)
+	}
+
+	c.Start()
+
+	// c is collected on /metrics with the timeout of each scrape
+	collectorRegistry := prometheus.NewRegistry()
+	collectorRegistry.MustRegister(c)
+	if *configFile != "" {
+		prometheus.MustRegister(loader)
+		prometheus.MustRegister(NewProber(loader))
+	}
+	if *logFile != "" || *logUnit != "" {
+		reasons, err := ParseLogReasons(*logReasons)
+
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+
+		w := NewLogWatcher(*logFile, *logUnit, reasons)
+		prometheus.MustRegister(w)
+		w.Start()
+	}
+	if *hepAddress != "" {
+		h := NewHEPListener(*hepAddress)
+
+		if err := h.Start(); err != nil {
+			log.Fatalln("[error] cannot listen for HEP packets:", err)
+		}
 
-	http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		prometheus.MustRegister(h)
+	}
+	if *mqttBroker != "" {
+		p, err := NewMQTTPublisher(*mqttBroker, prometheus.Gatherers{prometheus.DefaultGatherer, collectorRegistry})
+
+		if err != nil {
+			log.Fatalln("[error]", err)
+		}
+
+		if *targetName != "" {
+			p.Target = *targetName
+		}
+
+		if *mqttClientID != "" {
+			p.ClientID = *mqttClientID
+		}
+
+		if p.Password, err = readTokenFile(*mqttPassword); err != nil {
+			log.Fatalln("[error] cannot read MQTT password:", err)
+		}
+
+		p.Topic = *mqttTopic
+		p.Interval = *mqttInterval
+		p.Username = *mqttUsername
+		p.Retain = *mqttRetain
+		p.Start()
+	}
+	if *xhttpPromURL != "" {
+		prometheus.MustRegister(NewXHTTPPromCollector(*xhttpPromURL, *timeout, *xhttpPromSource, *xhttpPromPrefix))
+	}
+
+	quit := make(chan struct{})
+
+	http.Handle(prefix+*metricsPath, metricsHandler(c, prometheus.DefaultGatherer, *timeoutOffset))
+	http.Handle(prefix+"/-/ready", readyHandler(c))
+
+	// like /api/v1/config, targets are changed with the lifecycle endpoints
+	targetsToken := ""
+	if *enableLifecycle {
+		targetsToken = adminToken
+	}
+
+	http.Handle(prefix+"/api/v1/targets", targetsHandler(c, loader, targetsToken))
+
+	if *enableLifecycle {
+		http.Handle(prefix+"/-/quit", requireToken(adminToken, quitHandler(quit)))
+		http.Handle(prefix+"/-/reload", requireToken(adminToken, reloadHandler(loader)))
+		http.Handle(prefix+"/-/maintenance", requireToken(adminToken, maintenanceHandler(c)))
+		http.Handle(prefix+"/api/v1/config", requireToken(adminToken, configHandler(loader)))
+	}
+
+	if *enableStatus {
+		c.EnableStatus()
+		http.Handle(prefix+"/status", statusHandler(c))
+	}
+	if *enableProbe {
+		http.Handle(prefix+"/probe", probeHandler(loader, *timeoutOffset))
+	}
+	if *enableTargets {
+		http.Handle(prefix+*metricsPath+"/", targetMetricsHandler(loader, prefix+*metricsPath+"/", *timeoutOffset))
+	}
+	if *enableDebug {
+		http.Handle(prefix+"/debug/rpc", requireToken(adminToken, debugRPCHandler(c)))
+
+		if *scrapeHistory > 0 {
+			c.EnableScrapeHistory(*scrapeHistory)
+			http.Handle(prefix+"/debug/scrapes", requireToken(adminToken, scrapesHandler(c)))
+		}
+	}
+	if *rpcAllowlist != "" {
+		http.Handle(prefix+"/api/v1/rpc", requireToken(adminToken, rpcHandler(c, strings.Split(*rpcAllowlist, ","))))
+	}
+	if prefix != "" {
+		http.Handle("/", rootRedirectHandler(prefix))
+	}
+	statusLink := ""
+	if *enableStatus {
+		statusLink = `<p><a href="` + linkPrefix + `/status">Status</a></p>`
+	}
+	http.HandleFunc(prefix+"/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Kamailio Exporter</title></head>
 			<body>
 			<h1>Kamailio Exporter</h1>
-			<p><a href="` + *metricsPath + `">Metrics</a></p>
+			<p><a href="` + linkPrefix + *metricsPath + `">Metrics</a></p>
+			<p><a href="` + linkPrefix + `/-/ready">Readiness</a></p>
+			` + statusLink + `
 			</body>
 			</html>`))
 	})
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+
+	srv := &http.Server{Addr: *listenAddress}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		for range hup {
+			if err := loader.Reload(); err != nil {
+				log.Println("[error] cannot reload configuration:", err)
+			}
+		}
+	}()
+
+	if *watchConfig && *configFile != "" {
+		err := WatchDirs(loader.WatchedDirs, loader.Reloaded(), *watchDelay, func() {
+			if err := loader.ReloadIfChanged(); err != nil {
+				log.Println("[error] cannot reload configuration:", err)
+			}
+		})
+
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	go func() {
+		<-quit
+		log.Println("[info] received termination request via web service, exiting gracefully...")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println("[error]", err)
+		}
+	}()
+
+	err = web.ListenAndServe(srv, &web.FlagConfig{
+		WebListenAddresses: &[]string{*listenAddress},
+		WebSystemdSocket:   new(bool),
+		WebConfigFile:      webConfigFile,
+	}, toolkitLogger{})
+
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maintenanceState tells whether kamailio is in maintenance, during planned restarts and upgrades.
+// It has its own mutex, so that the endpoint does not wait for a scrape in progress.
+type maintenanceState struct {
+	mutex sync.Mutex
+
+	enabled bool   // by the /-/maintenance endpoint
+	file    string // whose presence enables maintenance, "" if none
+}
+
+// SetMaintenanceFile makes the presence of path enable maintenance.
+func (c *Collector) SetMaintenanceFile(path string) {
+	c.maintenance.mutex.Lock()
+	defer c.maintenance.mutex.Unlock()
+
+	c.maintenance.file = path
+}
+
+// SetMaintenance enables or disables maintenance. The maintenance file, if present, still enables it.
+func (c *Collector) SetMaintenance(enabled bool) {
+	c.maintenance.mutex.Lock()
+	defer c.maintenance.mutex.Unlock()
+
+	c.maintenance.enabled = enabled
+}
+
+// InMaintenance returns true if kamailio is in maintenance.
+func (c *Collector) InMaintenance() bool {
+	c.maintenance.mutex.Lock()
+	defer c.maintenance.mutex.Unlock()
+
+	if c.maintenance.enabled {
+		return true
+	}
+
+	if c.maintenance.file == "" {
+		return false
+	}
+
+	_, err := os.Stat(c.maintenance.file)
+
+	return err == nil
+}
+
+// collectMaintenance sends kamailio_maintenance to ch.
+func (c *Collector) collectMaintenance(ch chan<- prometheus.Metric) {
+	v := 0.0
+	if c.InMaintenance() {
+		v = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.maintenanceDesc, prometheus.GaugeValue, v)
+}
+
+// maintenanceHandler returns a handler enabling maintenance on PUT or POST, disabling it on DELETE,
+// and reporting it on GET, as "1" or "0".
+func maintenanceHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPut, http.MethodPost:
+			c.SetMaintenance(true)
+			log.Printf("[audit] maintenance enabled from %s", r.RemoteAddr)
+		case http.MethodDelete:
+			c.SetMaintenance(false)
+			log.Printf("[audit] maintenance disabled from %s", r.RemoteAddr)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+			http.Error(w, "Only GET, PUT, POST or DELETE requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if c.InMaintenance() {
+			fmt.Fprintln(w, 1)
+		} else {
+			fmt.Fprintln(w, 0)
+		}
+	})
+}
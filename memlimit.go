@@ -0,0 +1,13 @@
+package main
+
+import (
+	"log"
+	"runtime/debug"
+)
+
+// SetMemoryLimit sets the soft memory limit of the Go runtime to limit bytes, like GOMEMLIMIT,
+// so that the garbage collector runs more often instead of exceeding the limit of a container.
+func SetMemoryLimit(limit int64) {
+	debug.SetMemoryLimit(limit)
+	log.Printf("[info] memory limit of the Go runtime set to %d bytes", limit)
+}
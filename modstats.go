@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> mod.stats all shm
+{
+	Module: core
+	shm: {
+		sip_msg_shm_clone(496): 4872
+		create_avp(175): 24608
+		xavp_new_value(106): 4240
+		Total: 33720
+	}
+}
+{
+	Module: usrloc
+	shm: {
+		new_ucontact(58): 81920
+		build_contact(120): 2048
+		Total: 83968
+	}
+}
+*/
+
+// values of Collector.ModStatsLevel, the level of detail of mod.stats
+const (
+	modStatsModule   = "module"   // total by module
+	modStatsFunction = "function" // and by function allocating memory
+	modStatsLine     = "line"     // and by allocation site, function and line
+)
+
+// modStatsSite is an allocation site of a module.
+type modStatsSite struct {
+	module string
+	site   string
+}
+
+// scrapeModStats passes the shared memory allocated by each module to fn, from "mod.stats all shm",
+// so that the module leaking memory can be found when core.shmmem only shows the total growing.
+// Depending on c.ModStatsLevel, the memory is also broken down by function or allocation site.
+func (c *Collector) scrapeModStats(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	records, err := c.fetchBINRPC(ctx, "mod.stats", "all", "shm")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return &RPCError{Method: "mod.stats", Code: code, Message: message}
+	}
+
+	totals := make(map[string]int)
+	sites := make(map[modStatsSite]int)
+
+	for _, record := range records {
+		items, err := record.StructItems()
+
+		if err != nil {
+			return fmt.Errorf(`invalid response for method "mod.stats": %w`, err)
+		}
+
+		module := ""
+
+		for _, item := range items {
+			if item.Key == "Module" {
+				module, _ = item.Value.String()
+			}
+		}
+
+		if module == "" {
+			continue
+		}
+
+		if totals[module], err = c.addModStats(module, items, sites); err != nil {
+			return err
+		}
+	}
+
+	for module, total := range totals {
+		if err := fn("shm_bytes", MetricValue{Value: float64(total), Labels: map[string]string{"module": module}}); err != nil {
+			return err
+		}
+	}
+
+	for site, size := range sites {
+		err := fn("shm_site_bytes", MetricValue{
+			Value:  float64(size),
+			Labels: map[string]string{"module": site.module, "site": site.site},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addModStats adds the allocation sites of items, the struct of module or a nested struct, to sites,
+// aggregated to c.ModStatsLevel. It returns the total of the module, or the sum of its sites if missing.
+func (c *Collector) addModStats(module string, items []binrpc.StructItem, sites map[modStatsSite]int) (int, error) {
+	sum, total := 0, -1
+
+	for _, item := range items {
+		if item.Key == "Module" {
+			continue
+		}
+
+		if item.Value.Type == binrpc.TypeStruct {
+			nested, err := item.Value.StructItems()
+
+			if err != nil {
+				return 0, fmt.Errorf(`invalid response for method "mod.stats": %w`, err)
+			}
+
+			size, err := c.addModStats(module, nested, sites)
+
+			if err != nil {
+				return 0, err
+			}
+
+			sum += size
+			continue
+		}
+
+		size, err := item.Value.Int()
+
+		if err != nil {
+			return 0, fmt.Errorf(`invalid size of "%s" of module "%s": %w`, item.Key, module, err)
+		}
+
+		if item.Key == "Total" {
+			total = size
+			continue
+		}
+
+		sum += size
+
+		switch c.ModStatsLevel {
+		case modStatsFunction:
+			function, _, _ := strings.Cut(item.Key, "(")
+			sites[modStatsSite{module, function}] += size
+		case modStatsLine:
+			sites[modStatsSite{module, item.Key}] += size
+		}
+	}
+
+	if total < 0 {
+		return sum, nil
+	}
+
+	return total, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// MQTTPublisher publishes snapshots of the metrics to an MQTT broker at a regular interval,
+// one message per method, for edge deployments whose only northbound channel is the broker.
+// Messages are published with QoS 0 over a connection opened for each snapshot, so that
+// no state survives a broker restart.
+type MQTTPublisher struct {
+	Broker   *url.URL // "tcp://host:1883", or "ssl://host:8883" for TLS
+	Topic    string   // with "{target}" and "{method}" placeholders
+	Target   string   // replaces "{target}"
+	ClientID string
+	Username string
+	Password string
+	Retain   bool
+	Interval time.Duration
+	Timeout  time.Duration
+
+	gatherer prometheus.Gatherer
+}
+
+// mqttSample is a sample of a snapshot.
+type mqttSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// mqttSnapshot is the payload of the messages.
+type mqttSnapshot struct {
+	Timestamp time.Time    `json:"timestamp"`
+	Target    string       `json:"target"`
+	Method    string       `json:"method"`
+	Samples   []mqttSample `json:"samples"`
+}
+
+// NewMQTTPublisher returns a new MQTTPublisher of the metrics of gatherer to broker.
+func NewMQTTPublisher(broker string, gatherer prometheus.Gatherer) (*MQTTPublisher, error) {
+	u, err := url.Parse(broker)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT broker: %w", err)
+	}
+
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts":
+	default:
+		return nil, fmt.Errorf(`invalid MQTT broker "%s": expected "tcp://host:port" or "ssl://host:port"`, broker)
+	}
+
+	if u.Port() == "" {
+		return nil, fmt.Errorf(`invalid MQTT broker "%s": the port is required`, broker)
+	}
+
+	hostname, _ := os.Hostname()
+
+	return &MQTTPublisher{
+		Broker:   u,
+		Topic:    "kamailio/{target}/{method}",
+		Target:   hostname,
+		ClientID: fmt.Sprintf("kamailio_exporter_%d", os.Getpid()),
+		Interval: time.Minute,
+		Timeout:  10 * time.Second,
+
+		gatherer: gatherer,
+	}, nil
+}
+
+// Start publishes the snapshots in the background.
+func (p *MQTTPublisher) Start() {
+	go func() {
+		for {
+			if err := p.publish(); err != nil {
+				log.Println("[error] cannot publish to MQTT broker:", err)
+			}
+
+			time.Sleep(p.Interval)
+		}
+	}()
+}
+
+// metricMethod returns the method of the metric name, or "exporter" for the metrics that do not
+// belong to a method. Metrics are matched by the prefix of their method, unless they have a FullName.
+func metricMethod(name string) string {
+	for method, metrics := range metricsList {
+		for _, metricDef := range metrics {
+			if metricDef.FullName != "" && metricDef.FullName == name {
+				return method
+			}
+		}
+	}
+
+	for _, m := range availableMethods {
+		if strings.HasPrefix(name, namespace+"_"+strings.ReplaceAll(m, ".", "_")+"_") {
+			return m
+		}
+	}
+
+	return "exporter"
+}
+
+// snapshots gathers the metrics of kamailio, grouped by method. Metrics that do not belong
+// to a method, such as kamailio_up, are in the "exporter" group.
+func (p *MQTTPublisher) snapshots() ([]mqttSnapshot, error) {
+	families, err := p.gatherer.Gather()
+
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+
+	now := time.Now()
+	groups := make(map[string]*mqttSnapshot)
+
+	for _, family := range families {
+		name := family.GetName()
+
+		if !strings.HasPrefix(name, namespace+"_") {
+			continue
+		}
+
+		method := metricMethod(name)
+
+		group, found := groups[method]
+
+		if !found {
+			group = &mqttSnapshot{Timestamp: now, Target: p.Target, Method: method}
+			groups[method] = group
+		}
+
+		for _, metric := range family.GetMetric() {
+			group.Samples = append(group.Samples, mqttSamples(name, metric)...)
+		}
+	}
+
+	snapshots := make([]mqttSnapshot, 0, len(groups))
+
+	for _, group := range groups {
+		snapshots = append(snapshots, *group)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Method < snapshots[j].Method
+	})
+
+	return snapshots, nil
+}
+
+// mqttSamples returns the samples of metric. Histograms and summaries are reduced to their sum and count.
+func mqttSamples(name string, metric *dto.Metric) []mqttSample {
+	var labels map[string]string
+
+	if len(metric.GetLabel()) > 0 {
+		labels = make(map[string]string, len(metric.GetLabel()))
+
+		for _, label := range metric.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+	}
+
+	switch {
+	case metric.Gauge != nil:
+		return []mqttSample{{Name: name, Labels: labels, Value: metric.GetGauge().GetValue()}}
+	case metric.Counter != nil:
+		return []mqttSample{{Name: name, Labels: labels, Value: metric.GetCounter().GetValue()}}
+	case metric.Untyped != nil:
+		return []mqttSample{{Name: name, Labels: labels, Value: metric.GetUntyped().GetValue()}}
+	case metric.Histogram != nil:
+		return []mqttSample{
+			{Name: name + "_sum", Labels: labels, Value: metric.GetHistogram().GetSampleSum()},
+			{Name: name + "_count", Labels: labels, Value: float64(metric.GetHistogram().GetSampleCount())},
+		}
+	case metric.Summary != nil:
+		return []mqttSample{
+			{Name: name + "_sum", Labels: labels, Value: metric.GetSummary().GetSampleSum()},
+			{Name: name + "_count", Labels: labels, Value: float64(metric.GetSummary().GetSampleCount())},
+		}
+	}
+
+	return nil
+}
+
+// publish publishes a snapshot of the metrics, one message per method.
+func (p *MQTTPublisher) publish() error {
+	snapshots, err := p.snapshots()
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+
+	conn, err := p.connect(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	for _, snapshot := range snapshots {
+		payload, err := json.Marshal(snapshot)
+
+		if err != nil {
+			return err
+		}
+
+		topic := strings.NewReplacer("{target}", p.Target, "{method}", snapshot.Method).Replace(p.Topic)
+
+		if _, err := conn.Write(mqttPublishPacket(topic, payload, p.Retain)); err != nil {
+			return err
+		}
+	}
+
+	// DISCONNECT, so that the broker does not wait for the keep alive
+	_, err = conn.Write([]byte{0xe0, 0x00})
+
+	return err
+}
+
+// connect opens a connection to the broker, and sends the MQTT 3.1.1 CONNECT packet.
+func (p *MQTTPublisher) connect(ctx context.Context) (net.Conn, error) {
+	var (
+		conn   net.Conn
+		err    error
+		dialer net.Dialer
+	)
+
+	switch p.Broker.Scheme {
+	case "ssl", "tls", "mqtts":
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: &tls.Config{ServerName: p.Broker.Hostname()}}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", p.Broker.Host)
+	default:
+		conn, err = dialer.DialContext(ctx, "tcp", p.Broker.Host)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(mqttConnectPacket(p.ClientID, p.Username, p.Password)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// CONNACK: packet type, remaining length 2, session present flag, return code
+	connack := make([]byte, 4)
+
+	if _, err := io.ReadFull(conn, connack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot read CONNACK: %w", err)
+	}
+
+	if connack[0] != 0x20 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet 0x%02x instead of CONNACK", connack[0])
+	}
+
+	if connack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused by the broker, return code %d", connack[3])
+	}
+
+	return conn, nil
+}
+
+// mqttConnectPacket returns a CONNECT packet, with a clean session.
+func mqttConnectPacket(clientID string, username string, password string) []byte {
+	var b bytes.Buffer
+
+	b.WriteByte(0x10)
+
+	var body bytes.Buffer
+
+	writeMQTTString(&body, "MQTT")
+	body.WriteByte(4) // protocol level 3.1.1
+
+	flags := byte(0x02) // clean session
+	if username != "" {
+		flags |= 0x80
+	}
+	if password != "" {
+		flags |= 0x40
+	}
+
+	body.WriteByte(flags)
+	body.Write([]byte{0, 60}) // keep alive, in seconds
+
+	writeMQTTString(&body, clientID)
+
+	if username != "" {
+		writeMQTTString(&body, username)
+	}
+
+	if password != "" {
+		writeMQTTString(&body, password)
+	}
+
+	writeMQTTLength(&b, body.Len())
+	body.WriteTo(&b)
+
+	return b.Bytes()
+}
+
+// mqttPublishPacket returns a PUBLISH packet with QoS 0.
+func mqttPublishPacket(topic string, payload []byte, retain bool) []byte {
+	var b bytes.Buffer
+
+	header := byte(0x30)
+	if retain {
+		header |= 0x01
+	}
+
+	b.WriteByte(header)
+	writeMQTTLength(&b, 2+len(topic)+len(payload))
+	writeMQTTString(&b, topic)
+	b.Write(payload)
+
+	return b.Bytes()
+}
+
+// writeMQTTString writes s prefixed by its length on 2 bytes.
+func writeMQTTString(b *bytes.Buffer, s string) {
+	b.Write([]byte{byte(len(s) >> 8), byte(len(s))})
+	b.WriteString(s)
+}
+
+// writeMQTTLength writes the remaining length of a packet, in the variable-length encoding of MQTT.
+func writeMQTTLength(b *bytes.Buffer, length int) {
+	for {
+		digit := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			digit |= 0x80
+		}
+
+		b.WriteByte(digit)
+
+		if length == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// values of Collector.BINRPCCookie
+const (
+	cookieFixed   = "fixed"   // always 4 bytes, like kamcmd
+	cookieCompact = "compact" // minimum number of bytes, like binrpc.WritePacket
+)
+
+// writePacket is like binrpc.WritePacket, but values may have different types (int, float64 or string),
+// since methods often take a mix of string and integer parameters.
+// The cookie is written on 4 bytes, unless cookieSize is cookieCompact.
+func writePacket(w io.Writer, cookieSize string, values ...any) (uint32, error) {
+	var payload bytes.Buffer
+
+	for _, v := range values {
+		var record *binrpc.Record
+		var err error
+
+		switch v := v.(type) {
+		case string:
+			record, err = binrpc.CreateRecord(v)
+		case int:
+			record, err = binrpc.CreateRecord(v)
+		case float64:
+			record, err = binrpc.CreateRecord(v)
+		default:
+			err = fmt.Errorf("type error: unsupported parameter type %T", v)
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		if err := record.Encode(&payload); err != nil {
+			return 0, err
+		}
+	}
+
+	cookie := rand.Uint32()
+	length := bigEndian(payload.Len())
+
+	if len(length) > binrpc.MaxSizeOfLength {
+		return 0, fmt.Errorf("packet length too big: %d/%d bytes", len(length), binrpc.MaxSizeOfLength)
+	}
+
+	cookieBytes := []byte{byte(cookie >> 24), byte(cookie >> 16), byte(cookie >> 8), byte(cookie)}
+
+	if cookieSize == cookieCompact {
+		cookieBytes = bigEndian(int(cookie))
+	}
+
+	header := make([]byte, 0, 2+len(length)+len(cookieBytes))
+	header = append(header, binrpc.BinRPCMagic<<4|binrpc.BinRPCVersion, byte((len(length)-1)<<2|(len(cookieBytes)-1)))
+	header = append(header, length...)
+	header = append(header, cookieBytes...)
+
+	// a single writev on network connections, without copying the payload after the header
+	packet := net.Buffers{header, payload.Bytes()}
+
+	if _, err := packet.WriteTo(w); err != nil {
+		return 0, &transportError{fmt.Errorf("cannot write packet: %w", err)}
+	}
+
+	return cookie, nil
+}
+
+// bigEndian returns n in big endian, on the minimum number of bytes (at least 1).
+func bigEndian(n int) []byte {
+	var b []byte
+
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+
+	if len(b) == 0 {
+		b = []byte{0}
+	}
+
+	return b
+}
+
+// transportError is an error writing a request, or reading the header of its response. Unlike
+// errors in the payload of a response, it leaves the connection unusable.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// readHeader reads the header of a response to the request identified by cookie.
+func readHeader(r *bufio.Reader, cookie uint32) (*binrpc.Header, error) {
+	header, err := readAnyHeader(r)
+
+	if err != nil {
+		return nil, &transportError{err}
+	}
+
+	if header.Cookie != cookie {
+		return nil, &transportError{fmt.Errorf("expected cookie %08X, got %08X: try --kamailio.binrpc-cookie=%s or %s", cookie, header.Cookie, cookieFixed, cookieCompact)}
+	}
+
+	return header, nil
+}
+
+// readAnyHeader reads the header of a response, whatever its cookie.
+// Unlike binrpc.ReadHeader, errors tell what is listening on the socket when it does not speak BINRPC v1.
+func readAnyHeader(r *bufio.Reader) (*binrpc.Header, error) {
+	first, err := r.Peek(1)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot read header: %w", err)
+	}
+
+	if magic := first[0] >> 4; magic != binrpc.BinRPCMagic {
+		return nil, fmt.Errorf("response is not BINRPC (magic %X instead of %X): is the socket a BINRPC socket of the ctl module?", magic, binrpc.BinRPCMagic)
+	}
+
+	if version := first[0] & 0x0F; version != binrpc.BinRPCVersion {
+		return nil, fmt.Errorf("unsupported BINRPC protocol version %d, only version %d is implemented", version, binrpc.BinRPCVersion)
+	}
+
+	return binrpc.ReadHeader(r)
+}
+
+// readPacket reads the response to the request identified by cookie.
+func readPacket(r io.Reader, cookie uint32) ([]binrpc.Record, error) {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+
+	defer func() {
+		reader.Reset(nil)
+		readerPool.Put(reader)
+	}()
+
+	header, err := readHeader(reader, cookie)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return readPayload(reader, header)
+}
+
+// readPayload reads the records of the response of header.
+// They are decoded as they are read, without copying the payload first.
+func readPayload(reader io.Reader, header *binrpc.Header) ([]binrpc.Record, error) {
+	d, payload := newDecoder(reader, header)
+	defer releaseDecoder(d)
+
+	var records []binrpc.Record
+
+	for {
+		record, err := d.Record()
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	if payload.N > 0 {
+		return nil, fmt.Errorf("truncated payload, %d bytes missing: %w", payload.N, io.ErrUnexpectedEOF)
+	}
+
+	return records, nil
+}
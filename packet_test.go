@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// dispatcherFixture returns the result of dispatcher.list for a set of n targets.
+func dispatcherFixture(n int) string {
+	targets := make([]string, n)
+
+	for i := range targets {
+		targets[i] = fmt.Sprintf(`"DEST": {"URI": "sip:10.0.%d.%d:5060", "FLAGS": "AP", "PRIORITY": %d, `+
+			`"ATTRS": {"BODY": "weight=50;duid=gw%d", "DUID": "gw%d", "MAXLOAD": 0, "WEIGHT": 50}}`, i/250, i%250, i%4, i, i)
+	}
+
+	return `{"NRSETS": 1, "RECORDS": {"SET": {"ID": 1, "TARGETS": {` + strings.Join(targets, ", ") + `}}}}`
+}
+
+// benchmarkFixtures are the results of a scrape with a large dispatcher.list.
+var benchmarkFixtures = map[string]string{
+	"tm.stats": `{"current": 1, "waiting": 0, "total": 9514528, "total_local": 2794613, "rpl_received": 19902190, ` +
+		`"rpl_generated": 4965793, "rpl_sent": 19908572, "6xx": 7782, "5xx": 2286589, "4xx": 961055, "3xx": 0, ` +
+		`"2xx": 6267549, "created": 9514528, "freed": 9514527, "delayed_free": 0}`,
+	"sl.stats":        `{"200": 666263, "4xx": 5621, "xxx": 0}`,
+	"core.shmmem":     `{"total": 67108864, "free": 61189608, "used": 2590984, "real_used": 5919256, "max_used": 13323296, "fragments": 44546}`,
+	"dispatcher.list": dispatcherFixture(3000),
+}
+
+// benchmarkPacket returns a response packet with the result of dispatcher.list.
+func benchmarkPacket(b *testing.B) []byte {
+	var body bytes.Buffer
+
+	if err := encodeJSONResponse(&body, []byte(dispatcherFixture(3000))); err != nil {
+		b.Fatal(err)
+	}
+
+	return binrpcResponse(1, body.Bytes())
+}
+
+// BenchmarkReadPacket reads a response with the pooled reader and decoder of the exporter.
+func BenchmarkReadPacket(b *testing.B) {
+	packet := benchmarkPacket(b)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := readPacket(bytes.NewReader(packet), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkReadPacketLibrary reads the same response with the library, as a baseline.
+func BenchmarkReadPacketLibrary(b *testing.B) {
+	packet := benchmarkPacket(b)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := binrpc.ReadPacket(bytes.NewReader(packet), 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDesc builds the descriptions of the metrics of a scrape from the cache of the collector.
+func BenchmarkDesc(b *testing.B) {
+	c := &Collector{}
+	labelKeys := []string{"uri", "setid", "flags"}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for _, metricDef := range metricsList["dispatcher.list"] {
+			c.desc(metricDef, labelKeys)
+		}
+	}
+}
+
+// BenchmarkNewDesc builds the same descriptions without cache, as a baseline.
+func BenchmarkNewDesc(b *testing.B) {
+	labelKeys := []string{"uri", "setid", "flags"}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for _, metricDef := range metricsList["dispatcher.list"] {
+			prometheus.NewDesc(metricDef.ExportedName(), metricDef.Help, labelKeys, nil)
+		}
+	}
+}
+
+// BenchmarkCollect scrapes a fake kamailio with a large dispatcher.list, from the request to the metrics.
+func BenchmarkCollect(b *testing.B) {
+	k := newFakeKamailio(b, benchmarkFixtures)
+	c, err := NewCollector(k.URI, 5*time.Second, "tm.stats,sl.stats,core.shmmem,dispatcher.list")
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ch := make(chan prometheus.Metric)
+		done := make(chan int)
+
+		go func() {
+			n := 0
+
+			for range ch {
+				n++
+			}
+
+			done <- n
+		}()
+
+		c.Collect(ch)
+		close(ch)
+
+		if n := <-done; n < 3000 {
+			b.Fatalf("got %d metrics", n)
+		}
+	}
+}
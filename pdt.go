@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> pdt.list
+{
+	SDOMAIN: *
+	RECORDS: {
+		ENTRY: {
+			DOMAIN: gw1.example.com
+			PREFIX: 0033
+		}
+		ENTRY: {
+			DOMAIN: gw2.example.com
+			PREFIX: 0044
+		}
+	}
+}
+*/
+
+// scrapePDT passes the number of prefix to domain mappings loaded by the pdt module, by source domain, to fn.
+// Source domains without mappings are not returned by kamailio.
+func (c *Collector) scrapePDT(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	var (
+		counts   = make(map[string]int)
+		order    []string // of the source domains, as returned
+		sdomain  string
+		prefixes int
+	)
+
+	err := c.streamBINRPC(ctx, "pdt.list", func(d *rpcDecoder) error {
+		return walkRecords(d, "pdt.list", func(path []string, key string, record binrpc.Record) error {
+			switch {
+			case len(path) == 0 && key == "SDOMAIN":
+				sdomain, _ = record.String()
+			case key == "PREFIX":
+				prefixes++
+			case len(path) == 0 && key == "" && record.Type == typeEnd:
+				if _, found := counts[sdomain]; !found {
+					order = append(order, sdomain)
+				}
+
+				counts[sdomain] += prefixes
+				sdomain, prefixes = "", 0
+			}
+
+			return nil
+		})
+	})
+
+	var rpcErr *RPCError
+
+	// kamailio returns nothing, or a 404 in some versions, when no mapping is loaded
+	if err != nil && !errors.Is(err, errEmptyResponse) && !(errors.As(err, &rpcErr) && rpcErr.Code == 404) {
+		return err
+	}
+
+	for _, sdomain := range order {
+		err := fn("prefixes", MetricValue{
+			Value:  float64(counts[sdomain]),
+			Labels: map[string]string{"sdomain": sdomain},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
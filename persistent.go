@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// bounds of the delay between connection attempts after failures, with a persistent connection
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// persistentConn is the connection to kamailio kept open between scrapes, if Collector.Persistent is set.
+// It is guarded by Collector.mutex.
+type persistentConn struct {
+	conn     net.Conn // nil if not connected
+	lastUsed time.Time
+	started  bool // keepalive goroutine
+
+	// after a failed connection attempt, scrapes fail with err until retryAt
+	backoff time.Duration
+	retryAt time.Time
+	err     error
+}
+
+// connect returns the persistent connection to kamailio, connecting if needed, or a new connection
+// if c.Persistent is not set. c.mutex must be held.
+func (c *Collector) connect(ctx context.Context) (net.Conn, error) {
+	if !c.Persistent {
+		return c.dial(ctx)
+	}
+
+	p := &c.persistent
+
+	if !p.started {
+		p.started = true
+		go c.keepalive()
+	}
+
+	if p.conn != nil {
+		return p.conn, nil
+	}
+
+	if time.Now().Before(p.retryAt) {
+		return nil, fmt.Errorf("not reconnecting before %s: %w", p.retryAt.Format(time.RFC3339), p.err)
+	}
+
+	conn, err := c.dial(ctx)
+
+	if err != nil {
+		if p.backoff *= 2; p.backoff < minReconnectBackoff {
+			p.backoff = minReconnectBackoff
+		} else if p.backoff > maxReconnectBackoff {
+			p.backoff = maxReconnectBackoff
+		}
+
+		p.retryAt = time.Now().Add(p.backoff)
+		p.err = err
+
+		return nil, err
+	}
+
+	p.backoff = 0
+	p.retryAt = time.Time{}
+	p.err = nil
+
+	// datagram sockets and commands are not worth keeping
+	switch conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		p.conn = conn
+		p.lastUsed = time.Now()
+	}
+
+	return conn, nil
+}
+
+// release closes conn, returned by connect, unless it is kept open. After an error, the response may
+// not have been read entirely: the connection is closed, except for error replies of kamailio.
+// c.mutex must be held.
+func (c *Collector) release(conn net.Conn, err error) {
+	p := &c.persistent
+
+	if conn != p.conn {
+		conn.Close()
+		return
+	}
+
+	var rpcErr *RPCError
+
+	if err != nil && !errors.As(err, &rpcErr) {
+		c.disconnect()
+		return
+	}
+
+	p.lastUsed = time.Now()
+}
+
+// disconnect closes the persistent connection, if any. c.mutex must be held.
+func (c *Collector) disconnect() {
+	if c.persistent.conn != nil {
+		c.persistent.conn.Close()
+		c.persistent.conn = nil
+	}
+}
+
+// keepalive checks the persistent connection when it has been idle for c.KeepaliveInterval,
+// with core.echo, and reconnects when it is broken, so that scrapes do not find it closed by
+// kamailio or a firewall. It closes the connection and returns if c.Persistent is unset by a reload.
+func (c *Collector) keepalive() {
+	for {
+		c.mutex.Lock()
+
+		interval := c.KeepaliveInterval
+
+		if !c.Persistent {
+			c.disconnect()
+			c.persistent.started = false
+			c.mutex.Unlock()
+
+			return
+		}
+
+		if interval > 0 && time.Since(c.persistent.lastUsed) >= interval {
+			c.checkConnection()
+		}
+
+		c.mutex.Unlock()
+
+		if interval <= 0 {
+			interval = time.Second
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// checkConnection calls core.echo on the persistent connection, reconnecting if it fails or if
+// there is no connection. c.mutex must be held.
+func (c *Collector) checkConnection() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if conn := c.persistent.conn; conn != nil {
+		c.conn = conn
+
+		_, err := c.fetchBINRPC(ctx, "core.echo")
+
+		if err == nil {
+			c.persistent.lastUsed = time.Now()
+			return
+		}
+
+		log.Printf("[warning] persistent connection to kamailio broken, reconnecting: %s", err)
+		c.disconnect()
+	}
+
+	if conn, err := c.connect(ctx); err == nil {
+		c.release(conn, nil)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> pike.top ALL
+{
+	idx: 0
+	ip_addr: 192.0.2.66
+	leaf_hits_prev: 41
+	leaf_hits_curr: 58
+	expires: 118
+	status: HOT
+}
+{
+	idx: 1
+	ip_addr: 198.51.100.7
+	leaf_hits_prev: 12
+	leaf_hits_curr: 9
+	expires: 92
+	status: WARM
+}
+*/
+
+// pikeStatuses are the statuses of the source addresses of pike that are counted: "hot" addresses exceed
+// the limit and are blocked, "warm" addresses are getting close to it.
+var pikeStatuses = []string{"hot", "warm"}
+
+// scrapePike passes the number of source addresses tracked by the pike module to fn, by status, so that
+// blocking spikes during floods can be alerted on. Addresses are not exported, only counted.
+func (c *Collector) scrapePike(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	records, err := c.fetchBINRPC(ctx, "pike.top", "ALL")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return &RPCError{Method: "pike.top", Code: code, Message: message}
+	}
+
+	counts := make(map[string]int, len(pikeStatuses))
+
+	for _, record := range records {
+		items, err := record.StructItems()
+
+		if err != nil {
+			return fmt.Errorf(`invalid response for method "pike.top": %w`, err)
+		}
+
+		for _, item := range items {
+			if item.Key == "status" {
+				status, _ := item.Value.String()
+				counts[strings.ToLower(status)]++
+			}
+		}
+	}
+
+	// both statuses are exported, so that alerts do not depend on series appearing
+	for _, status := range pikeStatuses {
+		if err := fn("ips", MetricValue{Value: float64(counts[status]), Labels: map[string]string{"status": status}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
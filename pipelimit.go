@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> pl.stats
+{
+	name: carrier-a
+	limit: 100
+	counter: 42
+	last_counter: 87
+}
+{
+	name: carrier-b
+	limit: 20
+	counter: 0
+	last_counter: 3
+}
+*/
+
+// scrapePipes passes the limit and the counters of the pipes of the pipelimit module to fn, by pipe id,
+// and their load: the share of the limit used during the last interval of the module.
+func (c *Collector) scrapePipes(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	err := c.streamBINRPC(ctx, "pl.stats", func(d *rpcDecoder) error {
+		return streamStructs(d, "pl.stats", func(fields map[string]binrpc.Record) error {
+			labels := map[string]string{"pipe": stringField(fields, "name")}
+
+			limit := float64(intField(fields, "limit"))
+			lastCounter := float64(intField(fields, "last_counter"))
+
+			values := map[string]float64{
+				"limit":        limit,
+				"counter":      float64(intField(fields, "counter")),
+				"last_counter": lastCounter,
+			}
+
+			if limit > 0 {
+				values["load"] = lastCounter / limit
+			}
+
+			for name, value := range values {
+				if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	// kamailio returns nothing when no pipe is defined
+	if errors.Is(err, errEmptyResponse) {
+		return nil
+	}
+
+	return err
+}
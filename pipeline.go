@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// streamedMethods are decoded while reading their response, depend on the response
+// of another method (dlg.list), or take parameters (siptrace.status, dlg.profile_get_size): they cannot be pipelined,
+// and are called after the others.
+var streamedMethods = map[string]bool{
+	"dispatcher.list":              true,
+	"dlg.list":                     true,
+	"dlg.profile_get_size":         true,
+	"dmq.list_nodes":               true,
+	"core.psx":                     true,
+	"htable.stats":                 true,
+	"domain.dump":                  true,
+	"pdt.list":                     true,
+	"cr.dump_routes":               true,
+	"userblocklist.dump_blocklist": true,
+	"siptrace.status":              true,
+	"corex.debug":                  true,
+	"stats.fetch":                  true,
+	"mod.stats":                    true,
+	"ul.dump":                      true,
+	"rtpengine.show":               true,
+	"uac.reg_dump":                 true,
+	"tls.list":                     true,
+	"ws.dump":                      true,
+	"core.version":                 true,
+	"core.sockets_list":            true,
+	"pike.top":                     true,
+	"pl.stats":                     true,
+}
+
+// pipelineOrder returns methods with the methods that can be pipelined first, and their number.
+func pipelineOrder(methods []string) ([]string, int) {
+	ordered := make([]string, 0, len(methods))
+
+	for _, method := range methods {
+		if !streamedMethods[method] {
+			ordered = append(ordered, method)
+		}
+	}
+
+	n := len(ordered)
+
+	for _, method := range methods {
+		if streamedMethods[method] {
+			ordered = append(ordered, method)
+		}
+	}
+
+	return ordered, n
+}
+
+// pipeline writes the requests of all methods before reading their responses, matched by cookie,
+// so that a scrape costs one round trip instead of one per method on high-latency links.
+// The responses are stored in c.pipelined, for parseMethod. c.mutex must be held.
+func (c *Collector) pipeline(ctx context.Context, methods []string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	cookies := make(map[uint32]string, len(methods))
+
+	for _, method := range methods {
+		cookie, err := writePacket(c.conn, c.BINRPCCookie, method)
+
+		if err != nil {
+			return err
+		}
+
+		cookies[cookie] = method
+	}
+
+	// a single reader, since it may buffer several responses
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(c.conn)
+
+	defer func() {
+		reader.Reset(nil)
+		readerPool.Put(reader)
+	}()
+
+	c.pipelined = make(map[string][]binrpc.Record, len(methods))
+
+	for range methods {
+		header, err := readAnyHeader(reader)
+
+		if err != nil {
+			return err
+		}
+
+		method, found := cookies[header.Cookie]
+
+		if !found {
+			return fmt.Errorf("unexpected cookie %08X in pipelined responses: try --kamailio.binrpc-cookie=%s or %s", header.Cookie, cookieFixed, cookieCompact)
+		}
+
+		delete(cookies, header.Cookie)
+
+		if c.pipelined[method], err = readPayload(reader, header); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
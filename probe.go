@@ -0,0 +1,476 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProbeAccount is the SIP account used by a probe.
+type ProbeAccount struct {
+	Server       string        `yaml:"server"` // "host:port" of kamailio, over UDP
+	Domain       string        `yaml:"domain"` // defaults to the host of Server
+	User         string        `yaml:"user"`
+	Password     string        `yaml:"password"`
+	PasswordFile string        `yaml:"password_file"`
+	Timeout      time.Duration `yaml:"timeout"` // 5s if not set
+}
+
+// validate checks a and sets its default values. name is the name of the probe, for errors.
+func (a *ProbeAccount) validate(name string) error {
+	if a.Server == "" || a.User == "" {
+		return fmt.Errorf("%s: server and user are required", name)
+	}
+
+	if a.Password != "" && a.PasswordFile != "" {
+		return fmt.Errorf("%s: password and password_file are mutually exclusive", name)
+	}
+
+	if a.Domain == "" {
+		host, _, found := strings.Cut(a.Server, ":")
+
+		if !found {
+			host = a.Server
+		}
+
+		a.Domain = host
+	}
+
+	if a.Timeout == 0 {
+		a.Timeout = 5 * time.Second
+	}
+
+	return nil
+}
+
+// password returns the password of a, reading PasswordFile if set.
+func (a *ProbeAccount) password() (string, error) {
+	if a.PasswordFile == "" {
+		return a.Password, nil
+	}
+
+	b, err := os.ReadFile(a.PasswordFile)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// RegisterProbeConfig is the configuration of the REGISTER probe.
+type RegisterProbeConfig struct {
+	ProbeAccount `yaml:",inline"`
+
+	Expires int `yaml:"expires"` // of the binding, 60 if not set
+}
+
+// validate checks c and sets its default values.
+func (c *RegisterProbeConfig) validate() error {
+	if err := c.ProbeAccount.validate("register_probe"); err != nil {
+		return err
+	}
+
+	if c.Expires == 0 {
+		c.Expires = 60
+	}
+
+	return nil
+}
+
+// InviteProbeConfig is the configuration of the INVITE probe.
+type InviteProbeConfig struct {
+	ProbeAccount `yaml:",inline"`
+
+	Destination string `yaml:"destination"` // SIP URI, or user in Domain
+}
+
+// validate checks c and sets its default values.
+func (c *InviteProbeConfig) validate() error {
+	if err := c.ProbeAccount.validate("invite_probe"); err != nil {
+		return err
+	}
+
+	if c.Destination == "" {
+		return fmt.Errorf("invite_probe: destination is required")
+	}
+
+	if !strings.HasPrefix(c.Destination, "sip:") && !strings.HasPrefix(c.Destination, "sips:") {
+		c.Destination = fmt.Sprintf("sip:%s@%s", c.Destination, c.Domain)
+	}
+
+	return nil
+}
+
+// Prober runs synthetic SIP probes against kamailio on each scrape, as configured
+// in the configuration file. Unlike RPC metrics, probes validate the SIP paths end-to-end.
+type Prober struct {
+	loader *ConfigLoader
+
+	registerSuccess  prometheus.Gauge
+	registerCode     prometheus.Gauge
+	registerDuration prometheus.Gauge
+
+	inviteSuccess       prometheus.Gauge
+	inviteCode          prometheus.Gauge
+	invitePostDialDelay prometheus.Gauge
+
+	dependencyUp       *prometheus.Desc
+	dependencyDuration *prometheus.Desc
+}
+
+// NewProber returns a new Prober running the probes of the configuration of loader.
+func NewProber(loader *ConfigLoader) *Prober {
+	return &Prober{
+		loader: loader,
+
+		registerSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_register_success",
+			Help:      "Whether the last REGISTER probe succeeded.",
+		}),
+		registerCode: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_register_status_code",
+			Help:      "Final response code of the last REGISTER probe, 0 if there was no response.",
+		}),
+		registerDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_register_duration_seconds",
+			Help:      "Duration of the last REGISTER probe, including the authentication challenge.",
+		}),
+
+		inviteSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_invite_success",
+			Help:      "Whether the last INVITE probe received a provisional response above 100 or a 2xx.",
+		}),
+		inviteCode: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_invite_status_code",
+			Help:      "First response code above 100 of the last INVITE probe, 0 if there was none.",
+		}),
+		invitePostDialDelay: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "probe_invite_post_dial_delay_seconds",
+			Help:      "Post-dial delay of the last INVITE probe: time from the INVITE to its first response above 100.",
+		}),
+
+		dependencyUp: prometheus.NewDesc(
+			namespace+"_dependency_up",
+			"Whether the last probe of the backend of kamailio succeeded.",
+			[]string{"dependency", "type"}, nil,
+		),
+		dependencyDuration: prometheus.NewDesc(
+			namespace+"_dependency_probe_duration_seconds",
+			"Duration of the last probe of the backend of kamailio.",
+			[]string{"dependency", "type"}, nil,
+		),
+	}
+}
+
+// Describe implements prometheus.Collector.
+// Nothing is sent, since probes may be added or removed by a reload.
+func (p *Prober) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector.
+func (p *Prober) Collect(ch chan<- prometheus.Metric) {
+	config := p.loader.Config()
+
+	if config.RegisterProbe != nil {
+		p.probeRegister(config.RegisterProbe)
+
+		ch <- p.registerSuccess
+		ch <- p.registerCode
+		ch <- p.registerDuration
+	}
+
+	if config.InviteProbe != nil {
+		p.probeInvite(config.InviteProbe)
+
+		ch <- p.inviteSuccess
+		ch <- p.inviteCode
+		ch <- p.invitePostDialDelay
+	}
+
+	p.probeDependencies(config.Dependencies, ch)
+}
+
+// probeDependencies probes the backends of kamailio concurrently, and sends their metrics to ch.
+func (p *Prober) probeDependencies(dependencies []DependencyProbeConfig, ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+
+	for _, dependency := range dependencies {
+		wg.Add(1)
+
+		go func(dependency DependencyProbeConfig) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := probeDependency(dependency)
+			duration := time.Since(start).Seconds()
+
+			up := 1.0
+			if err != nil {
+				log.Printf("[error] dependency probe %s: %s", dependency.Name, err)
+				up = 0
+			}
+
+			ch <- prometheus.MustNewConstMetric(p.dependencyUp, prometheus.GaugeValue, up, dependency.Name, dependency.Type)
+			ch <- prometheus.MustNewConstMetric(p.dependencyDuration, prometheus.GaugeValue, duration, dependency.Name, dependency.Type)
+		}(dependency)
+	}
+
+	wg.Wait()
+}
+
+// probeRegister registers to kamailio, answering its authentication challenge, and updates the metrics.
+func (p *Prober) probeRegister(config *RegisterProbeConfig) {
+	start := time.Now()
+
+	code, err := register(config)
+
+	p.registerDuration.Set(time.Since(start).Seconds())
+	p.registerCode.Set(float64(code))
+
+	if err != nil {
+		log.Println("[error] register probe:", err)
+		p.registerSuccess.Set(0)
+	} else {
+		p.registerSuccess.Set(1)
+	}
+}
+
+// register sends a REGISTER to kamailio, and returns the final response code.
+func register(config *RegisterProbeConfig) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	password, err := config.password()
+
+	if err != nil {
+		return 0, err
+	}
+
+	client, err := dialSIP(ctx, config.Server)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer client.Close()
+
+	uri := "sip:" + config.Domain
+	aor := fmt.Sprintf("sip:%s@%s", config.User, config.Domain)
+
+	req := sipRequest{
+		Method: "REGISTER",
+		URI:    uri,
+		Headers: []string{
+			fmt.Sprintf("From: <%s>;tag=%s", aor, client.fromTag),
+			fmt.Sprintf("To: <%s>", aor),
+			fmt.Sprintf("Contact: <sip:%s@%s>", config.User, client.LocalAddr()),
+			fmt.Sprintf("Expires: %d", config.Expires),
+		},
+	}
+
+	resp, err := client.Send(ctx, req, nil)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.Code == 401 || resp.Code == 407 {
+		auth, err := digestAuthorization(resp, req.Method, uri, config.User, password)
+
+		if err != nil {
+			return resp.Code, err
+		}
+
+		req.Headers = append(req.Headers, auth)
+
+		if resp, err = client.Send(ctx, req, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	if resp.Code < 200 || resp.Code >= 300 {
+		return resp.Code, fmt.Errorf("REGISTER failed: %d %s", resp.Code, resp.Reason)
+	}
+
+	return resp.Code, nil
+}
+
+// probeInvite calls the destination through kamailio, cancelling the call on the first
+// provisional response above 100, and updates the metrics.
+func (p *Prober) probeInvite(config *InviteProbeConfig) {
+	code, pdd, err := invite(config)
+
+	p.inviteCode.Set(float64(code))
+	p.invitePostDialDelay.Set(pdd.Seconds())
+
+	if err != nil {
+		log.Println("[error] invite probe:", err)
+		p.inviteSuccess.Set(0)
+	} else {
+		p.inviteSuccess.Set(1)
+	}
+}
+
+// invite sends an INVITE to the destination, cancels it on the first provisional response
+// above 100 (or hangs up if answered), and returns the code of this response and the post-dial delay.
+func invite(config *InviteProbeConfig) (int, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+	defer cancel()
+
+	password, err := config.password()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	client, err := dialSIP(ctx, config.Server)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer client.Close()
+
+	host, _, _ := strings.Cut(client.LocalAddr(), ":")
+	from := fmt.Sprintf("From: <sip:%s@%s>;tag=%s", config.User, config.Domain, client.fromTag)
+	to := fmt.Sprintf("To: <%s>", config.Destination)
+
+	req := sipRequest{
+		Method: "INVITE",
+		URI:    config.Destination,
+		Headers: []string{
+			from,
+			to,
+			fmt.Sprintf("Contact: <sip:%s@%s>", config.User, client.LocalAddr()),
+			"Content-Type: application/sdp",
+		},
+		// no media is ever sent: the call is cancelled before being answered
+		Body: fmt.Sprintf("v=0\r\no=- 0 0 IN IP4 %s\r\ns=kamailio_exporter\r\nc=IN IP4 %s\r\nt=0 0\r\nm=audio 9 RTP/AVP 0\r\na=sendrecv\r\n", host, host),
+	}
+
+	start := time.Now()
+	ringing := func(resp *sipResponse) bool {
+		return resp.Code > 100
+	}
+
+	resp, err := client.Send(ctx, req, ringing)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if resp.Code == 401 || resp.Code == 407 {
+		if err := client.Ack(ackRequest(req, client, resp)); err != nil {
+			return resp.Code, 0, err
+		}
+
+		auth, err := digestAuthorization(resp, req.Method, req.URI, config.User, password)
+
+		if err != nil {
+			return resp.Code, 0, err
+		}
+
+		req.Headers = append(req.Headers, auth)
+
+		if resp, err = client.Send(ctx, req, ringing); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	// the challenge round trip is part of the post-dial delay experienced by users
+	pdd := time.Since(start)
+	inviteCSeq, inviteBranch := client.cseq, client.branch
+
+	if resp.Code < 200 {
+		cancelReq := sipRequest{
+			Method:  "CANCEL",
+			URI:     req.URI,
+			Headers: []string{from, to},
+			CSeq:    inviteCSeq,
+			Branch:  inviteBranch,
+		}
+
+		if _, err := client.Send(ctx, cancelReq, nil); err != nil {
+			return resp.Code, pdd, err
+		}
+
+		// the 487 of the INVITE, or a 2xx if the call was answered in the meantime
+		final, err := client.Receive(ctx, req.Method, inviteBranch)
+
+		if err != nil {
+			return resp.Code, pdd, err
+		}
+
+		return resp.Code, pdd, hangUp(ctx, client, req, final)
+	}
+
+	if resp.Code >= 300 {
+		client.Ack(ackRequest(req, client, resp))
+
+		return resp.Code, pdd, fmt.Errorf("INVITE failed: %d %s", resp.Code, resp.Reason)
+	}
+
+	return resp.Code, pdd, hangUp(ctx, client, req, resp)
+}
+
+// ackRequest returns the ACK of resp, a non-2xx final response to req, the last transaction of client.
+func ackRequest(req sipRequest, client *sipClient, resp *sipResponse) sipRequest {
+	return sipRequest{
+		URI:     req.URI,
+		Headers: []string{req.Headers[0], "To: " + resp.Header.Get("To")},
+		CSeq:    client.cseq,
+		Branch:  client.branch,
+	}
+}
+
+// hangUp acknowledges final, the final response to the INVITE req, and sends a BYE if the call was answered.
+func hangUp(ctx context.Context, client *sipClient, req sipRequest, final *sipResponse) error {
+	if final.Code >= 300 {
+		return client.Ack(ackRequest(req, client, final))
+	}
+
+	// a 2xx is acknowledged in a new transaction, along the route set of the dialog
+	headers := []string{req.Headers[0], "To: " + final.Header.Get("To")}
+	routes := final.Header.Values("Record-Route")
+
+	for i := len(routes) - 1; i >= 0; i-- {
+		headers = append(headers, "Route: "+routes[i])
+	}
+
+	uri := req.URI
+
+	if contact := final.Header.Get("Contact"); contact != "" {
+		if start, end := strings.Index(contact, "<"), strings.Index(contact, ">"); start >= 0 && end > start {
+			uri = contact[start+1 : end]
+		}
+	}
+
+	if err := client.Ack(sipRequest{URI: uri, Headers: headers, CSeq: client.cseq}); err != nil {
+		return err
+	}
+
+	resp, err := client.Send(ctx, sipRequest{Method: "BYE", URI: uri, Headers: headers}, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.Code >= 300 {
+		return fmt.Errorf("BYE failed: %d %s", resp.Code, resp.Reason)
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> core.psx
+{
+	IDX: 0
+	PID: 20541
+	DSC: main process - attendant
+}
+{
+	IDX: 1
+	PID: 20542
+	DSC: udp receiver child=0 sock=127.0.0.1:5060
+}
+*/
+
+// userHZ is the unit of the CPU times of /proc/<pid>/stat. It is 100 on all the
+// architectures supported by kamailio, and cannot be read without cgo.
+const userHZ = 100
+
+// procStat is the usage of the resources of a process, read from procfs.
+type procStat struct {
+	CPUSeconds float64
+	RSSBytes   float64
+	Threads    float64
+	OpenFDs    float64
+}
+
+// scrapeProcesses lists the processes of kamailio with "core.psx", and passes their number by type
+// and an info series per process to fn, so that missing workers can be alerted on. Their CPU time,
+// memory, threads and file descriptors are read from c.ProcfsPath, which requires the exporter
+// to run on the same host (and PID namespace) as kamailio.
+func (c *Collector) scrapeProcesses(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	failed := 0
+	types := make(map[string]int)
+
+	err := c.streamBINRPC(ctx, "core.psx", func(d *rpcDecoder) error {
+		return streamStructs(d, "core.psx", func(fields map[string]binrpc.Record) error {
+			pid := intField(fields, "PID")
+
+			labels := map[string]string{
+				"rank":        strconv.Itoa(intField(fields, "IDX")),
+				"description": stringField(fields, "DSC"),
+			}
+
+			types[processType(labels["description"])]++
+
+			err := fn("process_info", MetricValue{
+				Value:  1,
+				Labels: map[string]string{"rank": labels["rank"], "description": labels["description"], "pid": strconv.Itoa(pid)},
+			})
+
+			if err != nil {
+				return err
+			}
+
+			stat, err := readProcStat(c.ProcfsPath, pid)
+
+			if err != nil {
+				failed++
+				return nil
+			}
+
+			for name, value := range map[string]float64{
+				"cpu_seconds":           stat.CPUSeconds,
+				"resident_memory_bytes": stat.RSSBytes,
+				"threads":               stat.Threads,
+				"open_fds":              stat.OpenFDs,
+			} {
+				if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	if failed > 0 {
+		log.Printf("[warning] cannot read %d kamailio processes in %s: is the exporter running on the same host?", failed, c.ProcfsPath)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for t, count := range types {
+		if err := fn("processes", MetricValue{Value: float64(count), Labels: map[string]string{"type": t}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// processType returns the type of a process of kamailio from its description, without the
+// parameters of the process: "udp receiver child=0 sock=127.0.0.1:5060" is an "udp receiver".
+func processType(description string) string {
+	var words []string
+
+	for _, word := range strings.Fields(description) {
+		if strings.Contains(word, "=") {
+			break
+		}
+
+		words = append(words, word)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// readProcStat reads the resources used by the process pid in procfs.
+func readProcStat(procfs string, pid int) (*procStat, error) {
+	dir := filepath.Join(procfs, strconv.Itoa(pid))
+
+	b, err := os.ReadFile(filepath.Join(dir, "stat"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the command name is between parentheses, and may contain spaces
+	i := strings.LastIndexByte(string(b), ')')
+
+	if i < 0 {
+		return nil, fmt.Errorf("invalid %s/stat", dir)
+	}
+
+	// fields from the state (3rd field of stat)
+	fields := strings.Fields(string(b[i+1:]))
+
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid %s/stat: %d fields", dir, len(fields))
+	}
+
+	var values [4]float64
+
+	// utime, stime, num_threads and rss
+	for j, index := range []int{11, 12, 17, 21} {
+		if values[j], err = strconv.ParseFloat(fields[index], 64); err != nil {
+			return nil, fmt.Errorf("invalid %s/stat: %w", dir, err)
+		}
+	}
+
+	stat := procStat{
+		CPUSeconds: (values[0] + values[1]) / userHZ,
+		Threads:    values[2],
+		RSSBytes:   values[3] * float64(os.Getpagesize()),
+	}
+
+	fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat.OpenFDs = float64(len(fds))
+
+	return &stat, nil
+}
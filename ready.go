@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// Ready connects to kamailio. If deep is true, it also checks with "system.listMethods"
+// that the configured methods still exist, e.g. after an upgrade of kamailio or a module
+// removed from its configuration.
+func (c *Collector) Ready(deep bool) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	var err error
+
+	c.conn, err = c.dial(ctx)
+
+	if err != nil {
+		return fmt.Errorf("cannot connect to kamailio: %w", err)
+	}
+
+	defer c.conn.Close()
+
+	if !deep {
+		return nil
+	}
+
+	// listing the methods is cheap, unlike calling them
+	records, err := c.fetchBINRPC(ctx, "system.listMethods")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		return fmt.Errorf(`invalid response for method "system.listMethods": [%v] %v`, records[0].Value, records[1].Value)
+	}
+
+	available := make(map[string]bool, len(records))
+
+	for _, record := range records {
+		if name, err := record.String(); err == nil {
+			available[name] = true
+		}
+	}
+
+	var missing []string
+
+	for _, method := range c.Methods {
+		if !available[method] {
+			missing = append(missing, method)
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("methods not available in kamailio: %s", strings.Join(missing, ","))
+	}
+
+	return nil
+}
+
+// readyHandler returns a handler that reports whether kamailio can be scraped.
+// With "?deep=1", it also checks that the configured methods exist.
+func readyHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deep := false
+
+		if value := r.URL.Query().Get("deep"); value != "" {
+			var err error
+
+			if deep, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, fmt.Sprintf("invalid deep parameter: %s", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		if err := c.Ready(deep); err != nil {
+			http.Error(w, fmt.Sprintf("Kamailio exporter is not ready: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		fmt.Fprintln(w, "Kamailio exporter is ready.")
+	})
+}
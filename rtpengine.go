@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> rtpengine.show all
+{
+	url: udp:192.0.2.20:22222
+	set: 0
+	index: 0
+	weight: 1
+	disabled: 0
+	recheck_ticks: 0
+}
+{
+	url: udp:192.0.2.21:22222
+	set: 0
+	index: 1
+	weight: 1
+	disabled: 1
+	recheck_ticks: 42
+}
+*/
+
+// scrapeRTPEngines passes the state of the RTPEngine nodes of the rtpengine module to fn, by URL and set,
+// so that a node disabled after failing to answer is noticed before calls lack media.
+func (c *Collector) scrapeRTPEngines(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	records, err := c.fetchBINRPC(ctx, "rtpengine.show", "all")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return &RPCError{Method: "rtpengine.show", Code: code, Message: message}
+	}
+
+	for _, record := range records {
+		items, err := record.StructItems()
+
+		if err != nil {
+			return fmt.Errorf(`invalid response for method "rtpengine.show": %w`, err)
+		}
+
+		fields := make(map[string]binrpc.Record, len(items))
+
+		for _, item := range items {
+			fields[item.Key] = item.Value
+		}
+
+		labels := map[string]string{
+			"url": stringField(fields, "url"),
+			"set": strconv.Itoa(intField(fields, "set")),
+		}
+
+		enabled, permanent := rtpengineDisabled(fields["disabled"])
+
+		for name, value := range map[string]float64{
+			"enabled":              boolValue(enabled),
+			"disabled_permanently": boolValue(permanent),
+			"weight":               float64(intField(fields, "weight")),
+			"recheck_ticks":        float64(intField(fields, "recheck_ticks")),
+		} {
+			if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rtpengineDisabled decodes the "disabled" field of a node: 0 or 1, as an int or a string, with
+// "(permanent)" for nodes disabled by rtpengine.enable, which are not checked again.
+func rtpengineDisabled(record binrpc.Record) (enabled bool, permanent bool) {
+	if i, err := record.Int(); err == nil {
+		return i == 0, false
+	}
+
+	s, _ := record.String()
+
+	return strings.HasPrefix(s, "0"), strings.Contains(s, "permanent")
+}
+
+// boolValue returns 1 if b is true, 0 otherwise.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
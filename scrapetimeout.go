@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeTimeoutHeader is the header in which Prometheus sends the timeout of its scrapes.
+const scrapeTimeoutHeader = "X-Prometheus-Scrape-Timeout-Seconds"
+
+// scrapeTimeout returns the timeout of Prometheus for the scrape r, minus offset to leave time to send
+// the response, or 0 if the header is missing or invalid.
+func scrapeTimeout(r *http.Request, offset time.Duration) time.Duration {
+	seconds, err := strconv.ParseFloat(r.Header.Get(scrapeTimeoutHeader), 64)
+
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - offset
+
+	// the scrape still gets a chance, rather than failing immediately
+	if timeout <= 0 {
+		timeout = time.Duration(seconds * float64(time.Second) / 2)
+	}
+
+	return timeout
+}
+
+// timeoutCollector collects a Collector within the timeout of a scrape.
+// It is registered without descriptions, since Collector.Describe would scrape kamailio.
+type timeoutCollector struct {
+	collector *Collector
+	limit     time.Duration
+}
+
+// Describe implements prometheus.Collector.
+func (t timeoutCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector.
+func (t timeoutCollector) Collect(ch chan<- prometheus.Metric) {
+	t.collector.collect(ch, t.limit)
+}
+
+// metricsHandler returns a handler serving the metrics of gatherer and c, where c is collected
+// within the timeout of the scrape sent by Prometheus, if shorter than its own timeout, so that
+// the metrics collected so far are returned before Prometheus gives up.
+func metricsHandler(c *Collector, gatherer prometheus.Gatherer, offset time.Duration) http.Handler {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(timeoutCollector{c, scrapeTimeout(r, offset)})
+
+		promhttp.HandlerFor(prometheus.Gatherers{gatherer, registry}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handler)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// SelfTest calls each configured method once and logs whether it succeeded, was
+// rejected by kamailio (e.g. module not loaded), or returned no known metric.
+// It returns an error if any method did not produce metrics.
+func (c *Collector) SelfTest() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	var err error
+
+	c.conn, err = c.connect(ctx)
+
+	if err != nil {
+		return fmt.Errorf("self-test: cannot connect to kamailio: %w", err)
+	}
+
+	defer func() {
+		// nil if reconnecting after a failed method failed
+		if c.conn != nil {
+			c.release(c.conn, nil)
+		}
+	}()
+
+	var failed []string
+
+	for _, method := range c.Methods {
+		count := 0
+
+		err := c.scrapeMethod(ctx, method, func(name string, value MetricValue) error {
+			if _, found := findMetric(method, name); found {
+				count++
+			}
+
+			return nil
+		})
+
+		var rpcErr *RPCError
+
+		switch {
+		case errors.As(err, &rpcErr):
+			log.Printf("[error] self-test: %s: kamailio replied [%d] %s", method, rpcErr.Code, rpcErr.Message)
+		case err != nil:
+			log.Printf("[error] self-test: %s: %s", method, err)
+		case count == 0:
+			log.Printf("[error] self-test: %s: no parsable metrics in response", method)
+		default:
+			log.Printf("[info] self-test: %s: ok, %d metrics", method, count)
+			continue
+		}
+
+		failed = append(failed, method)
+
+		if err == nil {
+			continue
+		}
+
+		// like scrape, the response may have been read partially, and would be read by the next method
+		if err := c.redial(ctx, err); err != nil {
+			return fmt.Errorf("self-test: cannot reconnect to kamailio after %s: %w", method, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("self-test: %d/%d methods failed: %v", len(failed), len(c.Methods), failed)
+	}
+
+	return nil
+}
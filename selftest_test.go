@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfTestRedial(t *testing.T) {
+	k := newFakeKamailio(t, map[string]string{
+		"core.uptime": `{"now": 1792266000, "up_since": 1792262400, "uptime": 3600}`,
+		"core.shmmem": `{"total": 268435456, "free": 201326592, "used": 50331648, "real_used": 67108864, "max_used": 67108864, "fragments": 12}`,
+	})
+
+	k.mutex.Lock()
+	k.hangUp = "core.shmmem"
+	k.mutex.Unlock()
+
+	c, err := NewCollector(k.URI, 5*time.Second, "core.shmmem,core.uptime")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// core.uptime is called on a new connection
+	err = c.SelfTest()
+
+	if err == nil || !strings.Contains(err.Error(), "1/2 methods failed: [core.shmmem]") {
+		t.Errorf("got error %v, want core.shmmem failed alone", err)
+	}
+}
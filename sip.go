@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sipT1 is the initial retransmission interval of requests over UDP (RFC 3261 timer T1).
+const sipT1 = 500 * time.Millisecond
+
+// sipResponse is a SIP response, with the headers used by the probes.
+type sipResponse struct {
+	Code   int
+	Reason string
+	Header textproto.MIMEHeader
+}
+
+// Method returns the method of the request answered by r, from its CSeq.
+func (r *sipResponse) Method() string {
+	_, method, _ := strings.Cut(strings.TrimSpace(r.Header.Get("Cseq")), " ")
+
+	return strings.TrimSpace(method)
+}
+
+// sipRequest is a SIP request to be sent by a sipClient.
+type sipRequest struct {
+	Method  string
+	URI     string
+	Headers []string // in addition to Via, Max-Forwards, Call-ID, CSeq and Content-Length
+	Body    string
+
+	// CSeq and Branch are set to those of the INVITE for CANCEL and ACK of non-2xx responses,
+	// and left empty to start a new transaction.
+	CSeq   int
+	Branch string
+}
+
+// sipClient sends SIP requests over UDP, within a single dialog or registration (same Call-ID and tags).
+type sipClient struct {
+	conn    net.Conn
+	callID  string
+	fromTag string
+	cseq    int
+	branch  string                  // of the last transaction started
+	pending map[string]*sipResponse // final responses received for other transactions, by transaction
+}
+
+// dialSIP returns a client sending requests to server ("host:port") over UDP.
+func dialSIP(ctx context.Context, server string) (*sipClient, error) {
+	dialer := net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "udp", server)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &sipClient{
+		conn:    conn,
+		callID:  randomToken() + "@kamailio_exporter",
+		fromTag: randomToken(),
+	}, nil
+}
+
+// Close closes the socket of s.
+func (s *sipClient) Close() error {
+	return s.conn.Close()
+}
+
+// LocalAddr returns the address of s, as "host:port", for Via and Contact headers.
+func (s *sipClient) LocalAddr() string {
+	return s.conn.LocalAddr().String()
+}
+
+// randomToken returns a random string for tags, branches and Call-IDs.
+func randomToken() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// Send sends req, retransmitting it until a response is received, and returns the final response.
+// Provisional responses are passed to provisional if not nil; if it returns true, Send returns
+// this response without waiting for the final response, which can then be read with Receive.
+func (s *sipClient) Send(ctx context.Context, req sipRequest, provisional func(resp *sipResponse) bool) (*sipResponse, error) {
+	packet := s.build(&req)
+
+	return s.receive(ctx, req.Method, req.Branch, packet, provisional)
+}
+
+// Receive waits for the final response of the transaction of branch.
+func (s *sipClient) Receive(ctx context.Context, method string, branch string) (*sipResponse, error) {
+	return s.receive(ctx, method, branch, nil, nil)
+}
+
+// Ack sends req, an ACK, which has no response.
+func (s *sipClient) Ack(req sipRequest) error {
+	req.Method = "ACK"
+
+	_, err := s.conn.Write(s.build(&req))
+
+	return err
+}
+
+// build sets the CSeq and branch of req if not set, starting a new transaction, and returns req as a packet.
+func (s *sipClient) build(req *sipRequest) []byte {
+	if req.CSeq == 0 {
+		s.cseq++
+		req.CSeq = s.cseq
+	}
+
+	if req.Branch == "" {
+		req.Branch = "z9hG4bK" + randomToken()
+
+		if req.Method != "ACK" {
+			s.branch = req.Branch
+		}
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s SIP/2.0\r\n", req.Method, req.URI)
+	fmt.Fprintf(&b, "Via: SIP/2.0/UDP %s;branch=%s;rport\r\n", s.LocalAddr(), req.Branch)
+	fmt.Fprintf(&b, "Max-Forwards: 70\r\n")
+	fmt.Fprintf(&b, "Call-ID: %s\r\n", s.callID)
+	fmt.Fprintf(&b, "CSeq: %d %s\r\n", req.CSeq, req.Method)
+	fmt.Fprintf(&b, "User-Agent: kamailio_exporter\r\n")
+
+	for _, header := range req.Headers {
+		fmt.Fprintf(&b, "%s\r\n", header)
+	}
+
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n%s", len(req.Body), req.Body)
+
+	return []byte(b.String())
+}
+
+// receive reads the responses of the transaction of branch, retransmitting packet if not nil
+// until a response is received, and returns the final response, or the provisional response
+// for which provisional returned true.
+func (s *sipClient) receive(ctx context.Context, method string, branch string, packet []byte, provisional func(resp *sipResponse) bool) (*sipResponse, error) {
+	// a CANCEL has the branch of the INVITE it cancels: transactions are identified by both
+	transaction := branch + " " + method
+
+	// received while waiting for another transaction, e.g. a 487 before the 200 of a CANCEL
+	if resp, found := s.pending[transaction]; found {
+		delete(s.pending, transaction)
+
+		return resp, nil
+	}
+
+	interval := sipT1
+	buf := make([]byte, 65535)
+
+	for {
+		// once a provisional response is received, requests are no longer retransmitted
+		if packet != nil {
+			if _, err := s.conn.Write(packet); err != nil {
+				return nil, err
+			}
+		}
+
+		deadline := time.Now().Add(interval)
+
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+
+		if err := s.conn.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+
+		n, err := s.conn.Read(buf)
+
+		var netErr net.Error
+
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("no response to %s: %w", method, ctx.Err())
+			}
+
+			if interval *= 2; interval > 4*time.Second {
+				interval = 4 * time.Second
+			}
+
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		resp, respBranch, err := parseSIPResponse(buf[:n])
+
+		if err != nil {
+			continue
+		}
+
+		if respTransaction := respBranch + " " + resp.Method(); respTransaction != transaction {
+			// a response to another transaction of this client, or a late response to a previous one
+			if resp.Code >= 200 {
+				if s.pending == nil {
+					s.pending = make(map[string]*sipResponse)
+				}
+
+				s.pending[respTransaction] = resp
+			}
+
+			continue
+		}
+
+		if resp.Code >= 200 {
+			return resp, nil
+		}
+
+		packet = nil
+
+		if provisional != nil && provisional(resp) {
+			return resp, nil
+		}
+	}
+}
+
+// parseSIPResponse parses a SIP response, and returns it with the branch of its top Via.
+func parseSIPResponse(b []byte) (*sipResponse, string, error) {
+	reader := textproto.NewReader(bufio.NewReader(strings.NewReader(string(b))))
+
+	line, err := reader.ReadLine()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	version, status, found := strings.Cut(line, " ")
+
+	if !found || version != "SIP/2.0" {
+		return nil, "", fmt.Errorf("invalid SIP response: %q", line)
+	}
+
+	codeStr, reason, _ := strings.Cut(status, " ")
+	code, err := strconv.Atoi(codeStr)
+
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid SIP response code: %q", line)
+	}
+
+	header, err := reader.ReadMIMEHeader()
+
+	if err != nil && len(header) == 0 {
+		return nil, "", err
+	}
+
+	// compact form of Via
+	via := header.Get("Via")
+	if via == "" {
+		via = header.Get("V")
+	}
+
+	branch := ""
+
+	for _, param := range strings.Split(strings.SplitN(via, ",", 2)[0], ";") {
+		if name, value, found := strings.Cut(strings.TrimSpace(param), "="); found && strings.EqualFold(name, "branch") {
+			branch = value
+		}
+	}
+
+	return &sipResponse{Code: code, Reason: reason, Header: header}, branch, nil
+}
+
+// digestAuthorization returns the Authorization (or Proxy-Authorization) header answering
+// the challenge of resp, a 401 or 407 response to a request method sent to uri.
+func digestAuthorization(resp *sipResponse, method string, uri string, user string, password string) (string, error) {
+	header, challenge := "Authorization", resp.Header.Get("Www-Authenticate")
+
+	if resp.Code == 407 {
+		header, challenge = "Proxy-Authorization", resp.Header.Get("Proxy-Authenticate")
+	}
+
+	scheme, paramsStr, _ := strings.Cut(challenge, " ")
+
+	if !strings.EqualFold(scheme, "Digest") {
+		return "", fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	params := make(map[string]string)
+
+	for _, param := range splitDigestParams(paramsStr) {
+		if name, value, found := strings.Cut(param, "="); found {
+			params[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+
+	realm, nonce := params["realm"], params["nonce"]
+
+	ha1 := md5Hex(user + ":" + realm + ":" + password)
+	ha2 := md5Hex(method + ":" + uri)
+
+	auth := fmt.Sprintf(`%s: Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5`, header, user, realm, nonce, uri)
+
+	qop := ""
+
+	for _, q := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(q) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	if qop != "" {
+		cnonce := randomToken()
+		nc := "00000001"
+
+		auth += fmt.Sprintf(`, response="%s", qop=auth, nc=%s, cnonce="%s"`, md5Hex(ha1+":"+nonce+":"+nc+":"+cnonce+":"+qop+":"+ha2), nc, cnonce)
+	} else {
+		auth += fmt.Sprintf(`, response="%s"`, md5Hex(ha1+":"+nonce+":"+ha2))
+	}
+
+	if opaque, found := params["opaque"]; found {
+		auth += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+
+	return auth, nil
+}
+
+// splitDigestParams splits the parameters of a challenge on commas outside of quotes.
+func splitDigestParams(s string) []string {
+	var (
+		params []string
+		quoted bool
+		start  int
+	)
+
+	for i, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			params = append(params, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(params, s[start:])
+}
+
+// md5Hex returns the MD5 of s in hexadecimal.
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> siptrace.status check
+Enabled
+*/
+
+// scrapeSiptrace passes whether SIP tracing is enabled to fn, so that tracing left enabled
+// after a debugging session can be alerted on before it fills the storage.
+func (c *Collector) scrapeSiptrace(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	// without the "check" parameter, the method would change the status
+	records, err := c.fetchBINRPC(ctx, "siptrace.status", "check")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return &RPCError{Method: "siptrace.status", Code: code, Message: message}
+	}
+
+	if len(records) != 1 {
+		return fmt.Errorf(`invalid response for method "siptrace.status", expected 1 record, got %d`, len(records))
+	}
+
+	status, err := records[0].String()
+
+	if err != nil {
+		return err
+	}
+
+	value := 0.0
+
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "enabled":
+		value = 1
+	case "disabled":
+	default:
+		return fmt.Errorf(`invalid response for method "siptrace.status": unexpected status "%s"`, status)
+	}
+
+	return fn("enabled", MetricValue{Value: value})
+}
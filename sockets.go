@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> core.sockets_list
+{
+	socket: {
+		proto: udp
+		address: 192.0.2.1
+		port: 5060
+		mcast: no
+		mhomed: no
+		advertise: sip.example.com:5060
+	}
+	socket: {
+		proto: tls
+		address: 192.0.2.1
+		port: 5061
+		mcast: no
+		mhomed: no
+	}
+}
+*/
+
+// scrapeSockets passes the listening sockets of kamailio to fn, as info metrics by protocol, address, port
+// and advertised address, and their number by protocol, so that missing listeners and configuration drift
+// across a fleet are noticed.
+func (c *Collector) scrapeSockets(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	records, err := c.fetchBINRPC(ctx, "core.sockets_list")
+
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return &RPCError{Method: "core.sockets_list", Code: code, Message: message}
+	}
+
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+
+	for _, record := range records {
+		items, err := record.StructItems()
+
+		if err != nil {
+			return fmt.Errorf(`invalid response for method "core.sockets_list": %w`, err)
+		}
+
+		for _, item := range items {
+			if item.Key != "socket" {
+				continue
+			}
+
+			socket, err := item.Value.StructItems()
+
+			if err != nil {
+				return fmt.Errorf(`invalid response for method "core.sockets_list": %w`, err)
+			}
+
+			fields := make(map[string]binrpc.Record, len(socket))
+
+			for _, field := range socket {
+				fields[field.Key] = field.Value
+			}
+
+			labels := map[string]string{
+				"proto":     stringField(fields, "proto"),
+				"address":   stringField(fields, "address"),
+				"port":      socketPort(fields["port"]),
+				"advertise": stringField(fields, "advertise"),
+			}
+
+			counts[labels["proto"]]++
+
+			key := labels["proto"] + "\xff" + labels["address"] + "\xff" + labels["port"] + "\xff" + labels["advertise"]
+
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			if err := fn("socket", MetricValue{Value: 1, Labels: labels}); err != nil {
+				return err
+			}
+		}
+	}
+
+	for proto, count := range counts {
+		if err := fn("sockets", MetricValue{Value: float64(count), Labels: map[string]string{"proto": proto}}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// socketPort returns the port of a socket, a string or an int depending on the version of kamailio.
+func socketPort(record binrpc.Record) string {
+	if i, err := record.Int(); err == nil {
+		return strconv.Itoa(i)
+	}
+
+	s, _ := record.String()
+
+	return s
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> stats.fetch registrar: shmem:
+{
+	registrar.accepted_regs: 1520
+	registrar.default_expire: 3600
+	registrar.rejected_regs: 3
+	shmem.fragments: 12
+	shmem.free_size: 60382928
+	...
+}
+
+On older versions, without stats.fetch:
+
+kamcmd> stats.get_statistics registrar: shmem:
+registrar:accepted_regs = 1520
+registrar:default_expire = 3600
+...
+*/
+
+// statsGroupAll is the group of stats.fetch requesting every statistic.
+const statsGroupAll = "all"
+
+// statsGroupRegex matches the groups of Collector.StatsGroups: "all", a group, or "group:name" for a single statistic.
+var statsGroupRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+(:[a-zA-Z0-9_.-]+)?$`)
+
+// validateStatsGroups checks the groups of stats.fetch.
+func validateStatsGroups(groups []string) error {
+	for _, group := range groups {
+		if !statsGroupRegex.MatchString(group) {
+			return fmt.Errorf(`invalid statistics group "%s", expected "all", "group" or "group:name"`, group)
+		}
+	}
+
+	return nil
+}
+
+// statsParams returns the parameters of stats.fetch for groups, every statistic if empty. Whole groups
+// are requested as "group:", since a parameter without colon is the name of a statistic for kamailio.
+func statsParams(groups []string) []any {
+	if len(groups) == 0 {
+		return []any{statsGroupAll}
+	}
+
+	params := make([]any, 0, len(groups))
+
+	for _, group := range groups {
+		if group != statsGroupAll && !strings.Contains(group, ":") {
+			group += ":"
+		}
+
+		params = append(params, group)
+	}
+
+	return params
+}
+
+// scrapeStats passes the statistics of the groups of c.StatsGroups to fn, by group and name, so that the
+// statistics of any module are exported without dedicated support in the exporter. Older versions of
+// kamailio, without stats.fetch, are queried with stats.get_statistics.
+//
+// kamailio does not tell counters from gauges: every statistic is a gauge, and rate() applies to counters.
+func (c *Collector) scrapeStats(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	params := statsParams(c.StatsGroups)
+
+	stats, err := c.fetchStats(ctx, "stats.fetch", params)
+
+	var rpcErr *RPCError
+
+	if errors.As(err, &rpcErr) && rpcErr.methodNotFound() {
+		stats, err = c.fetchStats(ctx, "stats.get_statistics", params)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// overlapping groups, such as "all,registrar", would export a statistic twice
+	seen := make(map[string]bool, len(stats))
+
+	for _, stat := range stats {
+		key := stat.group + ":" + stat.name
+
+		if seen[key] {
+			continue
+		}
+
+		seen[key] = true
+
+		err := fn("value", MetricValue{
+			Value:  stat.value,
+			Labels: map[string]string{"group": stat.group, "name": stat.name},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// statistic is a statistic of kamailio.
+type statistic struct {
+	group string
+	name  string
+	value float64
+}
+
+// fetchStats calls method, stats.fetch or stats.get_statistics, with params and returns the statistics.
+func (c *Collector) fetchStats(ctx context.Context, method string, params []any) ([]statistic, error) {
+	records, err := c.fetchBINRPC(ctx, method, params...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 2 && records[0].Type == binrpc.TypeInt {
+		code, _ := records[0].Int()
+		message, _ := records[1].String()
+
+		return nil, &RPCError{Method: method, Code: code, Message: message}
+	}
+
+	if method == "stats.get_statistics" {
+		return parseStatLines(records)
+	}
+
+	var stats []statistic
+
+	for _, record := range records {
+		items, err := record.StructItems()
+
+		if err != nil {
+			return nil, fmt.Errorf(`invalid response for method "%s": %w`, method, err)
+		}
+
+		for _, item := range items {
+			group, name, found := strings.Cut(item.Key, ".")
+
+			if !found {
+				continue
+			}
+
+			value, err := statValue(item.Value)
+
+			if err != nil {
+				return nil, fmt.Errorf(`invalid value of statistic "%s": %w`, item.Key, err)
+			}
+
+			stats = append(stats, statistic{group, name, value})
+		}
+	}
+
+	return stats, nil
+}
+
+// parseStatLines parses the response of stats.get_statistics, one "group:name = value" string per statistic.
+func parseStatLines(records []binrpc.Record) ([]statistic, error) {
+	var stats []statistic
+
+	for _, record := range records {
+		line, err := record.String()
+
+		if err != nil {
+			return nil, fmt.Errorf(`invalid response for method "stats.get_statistics": %w`, err)
+		}
+
+		key, value, found := strings.Cut(line, " = ")
+		group, name, ok := strings.Cut(key, ":")
+
+		if !found || !ok {
+			return nil, fmt.Errorf(`invalid statistic "%s", expected "group:name = value"`, line)
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+
+		if err != nil {
+			return nil, fmt.Errorf(`invalid value of statistic "%s": %w`, key, err)
+		}
+
+		stats = append(stats, statistic{group, name, v})
+	}
+
+	return stats, nil
+}
+
+// statValue returns the value of a statistic of stats.fetch: a string on most versions, an int or a double.
+func statValue(record binrpc.Record) (float64, error) {
+	switch record.Type {
+	case binrpc.TypeInt:
+		i, err := record.Int()
+		return float64(i), err
+	case binrpc.TypeDouble:
+		return record.Double()
+	}
+
+	s, err := record.String()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(s, 64)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statusRecorder keeps the values of the last two calls of each method, for the /status page.
+// It has its own mutex, so that the page does not wait for a scrape in progress.
+type statusRecorder struct {
+	mutex   sync.Mutex
+	enabled bool
+
+	pending  map[string][]statusSeries // values of the calls of the scrape in progress, by method
+	current  map[string]statusCall     // last successful call, by method
+	previous map[string]statusCall
+}
+
+// statusCall is the values returned by a call of a method.
+type statusCall struct {
+	Time   time.Time
+	Series []statusSeries
+}
+
+// statusSeries is the value of a series. For histograms, Value is the number of observations.
+type statusSeries struct {
+	Name   string
+	Labels string
+	Value  float64
+}
+
+// key returns the identity of s, to compute deltas.
+func (s statusSeries) key() string {
+	return s.Name + "{" + s.Labels + "}"
+}
+
+// EnableStatus starts recording the values collected, for the /status page.
+func (c *Collector) EnableStatus() {
+	c.status.mutex.Lock()
+	defer c.status.mutex.Unlock()
+
+	c.status.enabled = true
+}
+
+// recordStatus records the value of metricDef being collected. c.mutex must be held.
+func (c *Collector) recordStatus(metricDef Metric, value MetricValue) {
+	c.status.mutex.Lock()
+	defer c.status.mutex.Unlock()
+
+	if !c.status.enabled {
+		return
+	}
+
+	labels := make([]string, 0, len(value.Labels))
+
+	for _, key := range value.LabelKeys() {
+		labels = append(labels, key+`="`+value.Labels[key]+`"`)
+	}
+
+	v := value.Value
+	if metricDef.Buckets != nil {
+		v = float64(value.Count)
+	}
+
+	if c.status.pending == nil {
+		c.status.pending = make(map[string][]statusSeries)
+	}
+
+	c.status.pending[metricDef.Method] = append(c.status.pending[metricDef.Method], statusSeries{
+		Name:   metricDef.ExportedName(),
+		Labels: strings.Join(labels, ","),
+		Value:  v,
+	})
+}
+
+// commitStatus makes the values recorded for method the current ones, if ok, or drops them.
+// c.mutex must be held.
+func (c *Collector) commitStatus(method string, ok bool) {
+	c.status.mutex.Lock()
+	defer c.status.mutex.Unlock()
+
+	if !c.status.enabled {
+		return
+	}
+
+	series := c.status.pending[method]
+	delete(c.status.pending, method)
+
+	if !ok {
+		return
+	}
+
+	if c.status.current == nil {
+		c.status.current = make(map[string]statusCall)
+		c.status.previous = make(map[string]statusCall)
+	}
+
+	if current, found := c.status.current[method]; found {
+		c.status.previous[method] = current
+	}
+
+	c.status.current[method] = statusCall{Time: time.Now(), Series: series}
+}
+
+// statusRow is a row of the tables of the /status page.
+type statusRow struct {
+	Name     string
+	Labels   string
+	Value    float64
+	Delta    float64
+	HasDelta bool
+}
+
+// statusTable is the table of a method on the /status page.
+type statusTable struct {
+	Method   string
+	Time     time.Time
+	Interval time.Duration // since the previous call, 0 if there is none
+	Rows     []statusRow
+}
+
+// statusTables returns the tables of the /status page, by method.
+func (c *Collector) statusTables() []statusTable {
+	c.status.mutex.Lock()
+	defer c.status.mutex.Unlock()
+
+	tables := make([]statusTable, 0, len(c.status.current))
+
+	for method, call := range c.status.current {
+		table := statusTable{Method: method, Time: call.Time}
+		previous := make(map[string]float64)
+
+		if p, found := c.status.previous[method]; found {
+			table.Interval = call.Time.Sub(p.Time)
+
+			for _, s := range p.Series {
+				previous[s.key()] = s.Value
+			}
+		}
+
+		for _, s := range call.Series {
+			row := statusRow{Name: s.Name, Labels: s.Labels, Value: s.Value}
+
+			if p, found := previous[s.key()]; found {
+				row.Delta = s.Value - p
+				row.HasDelta = true
+			}
+
+			table.Rows = append(table.Rows, row)
+		}
+
+		sort.Slice(table.Rows, func(i, j int) bool {
+			if table.Rows[i].Name != table.Rows[j].Name {
+				return table.Rows[i].Name < table.Rows[j].Name
+			}
+
+			return table.Rows[i].Labels < table.Rows[j].Labels
+		})
+
+		tables = append(tables, table)
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Method < tables[j].Method
+	})
+
+	return tables
+}
+
+// formatNumber formats v without exponent, since counters are easier to compare this way.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// formatDelta formats v like formatNumber, with its sign.
+func formatDelta(v float64) string {
+	if v > 0 {
+		return "+" + formatNumber(v)
+	}
+
+	return formatNumber(v)
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"number": formatNumber,
+	"delta":  formatDelta,
+}).Parse(`<html>
+<head>
+<title>Kamailio Exporter status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 2px 8px; text-align: left; }
+td.number { text-align: right; font-family: monospace; }
+.down { color: #c00; }
+</style>
+</head>
+<body>
+<h1>Kamailio Exporter status</h1>
+{{with .Target}}
+<p>Target: {{range .ScrapeURIs}}<code>{{.}}</code> {{end}}
+{{if eq .Health "down"}}<span class="down">down: {{.LastError}}</span>{{else}}{{.Health}}{{end}}
+{{with .LastScrape}}(last scrape {{.Format "2006-01-02 15:04:05 MST"}}){{end}}</p>
+{{end}}
+{{if not .Tables}}<p>No values collected yet.</p>{{end}}
+{{range .Tables}}
+<h2>{{.Method}}</h2>
+<p>Collected {{.Time.Format "2006-01-02 15:04:05 MST"}}{{if .Interval}}, deltas over {{.Interval}}{{end}}</p>
+<table>
+<tr><th>Metric</th><th>Labels</th><th>Value</th><th>Delta</th></tr>
+{{range .Rows}}<tr><td>{{.Name}}</td><td>{{.Labels}}</td><td class="number">{{number .Value}}</td><td class="number">{{if .HasDelta}}{{delta .Delta}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// statusHandler returns a handler rendering the last values collected for each method, with their
+// deltas since the previous call, for on-call engineers without access to Grafana.
+func statusHandler(c *Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		err := statusTemplate.Execute(w, map[string]any{
+			"Target": c.TargetStatus(),
+			"Tables": c.statusTables(),
+		})
+
+		if err != nil {
+			log.Println("[error] cannot render status page:", err)
+		}
+	})
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// errEmptyResponse is returned by streamBINRPC when kamailio returns no record, e.g. for empty lists.
+var errEmptyResponse = errors.New("empty response")
+
+// typeEnd is the type of the records returned by rpcDecoder.Next at the end of a struct or array.
+const typeEnd uint8 = 0xFF
+
+// rpcDecoder decodes the records of a BINRPC payload one at a time.
+// Structs and arrays are not materialized: a record of type binrpc.TypeStruct or binrpc.TypeArray
+// marks their start, and a record of type typeEnd their end. Struct keys are records of type binrpc.TypeAVP.
+//
+// This bounds the memory used by methods with very large responses (thousands of dispatcher targets
+// or TLS connections), since metrics can be emitted while the response is read.
+type rpcDecoder struct {
+	r      io.Reader
+	peeked *binrpc.Record
+	buf    []byte // reused for record values
+	intern bool   // intern strings (see intern.go), true unless unset by the parser
+}
+
+var (
+	// readers and decoders are reused between calls to limit allocations under frequent scrapes
+	readerPool  = sync.Pool{New: func() any { return bufio.NewReader(nil) }}
+	decoderPool = sync.Pool{New: func() any { return &rpcDecoder{buf: make([]byte, 64)} }}
+)
+
+// streamBINRPC calls method and passes a decoder of the response to fn.
+// Error replies from kamailio are returned as *RPCError.
+func (c *Collector) streamBINRPC(ctx context.Context, method string, fn func(d *rpcDecoder) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf(`timeout before calling method "%s": %w`, method, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := c.conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+
+	cookie, err := writePacket(c.conn, c.BINRPCCookie, method)
+
+	if err != nil {
+		return err
+	}
+
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(c.conn)
+
+	defer func() {
+		reader.Reset(nil)
+		readerPool.Put(reader)
+	}()
+
+	header, err := readHeader(reader, cookie)
+
+	if err != nil {
+		return err
+	}
+
+	d, payload := newDecoder(reader, header)
+	defer releaseDecoder(d)
+
+	first, err := d.Next()
+
+	if err == io.EOF {
+		return fmt.Errorf(`invalid response for method "%s": %w`, method, errEmptyResponse)
+	} else if err != nil {
+		return err
+	}
+
+	if first.Type == binrpc.TypeInt {
+		// error replies are a code followed by a message
+		code, _ := first.Int()
+		message := ""
+
+		if record, err := d.Next(); err == nil {
+			message, _ = record.String()
+		}
+
+		return &RPCError{Method: method, Code: code, Message: message}
+	}
+
+	d.peeked = &first
+
+	if err := fn(d); err != nil {
+		return err
+	}
+
+	// drain what fn did not read, so that the connection can be reused
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return err
+	}
+
+	if payload.N > 0 {
+		return fmt.Errorf(`truncated response for method "%s": %w`, method, io.ErrUnexpectedEOF)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf(`timeout while parsing method "%s": %w`, method, err)
+	}
+
+	return nil
+}
+
+// newDecoder returns a decoder of the payload of header, read from reader.
+// The payload is also returned, to check that it was fully read.
+func newDecoder(reader io.Reader, header *binrpc.Header) (*rpcDecoder, *io.LimitedReader) {
+	payload := &io.LimitedReader{R: reader, N: int64(header.PayloadLength)}
+
+	d := decoderPool.Get().(*rpcDecoder)
+	d.r = payload
+	d.intern = true
+
+	return d, payload
+}
+
+// releaseDecoder returns d to the pool.
+func releaseDecoder(d *rpcDecoder) {
+	d.r = nil
+	d.peeked = nil
+	decoderPool.Put(d)
+}
+
+// Record returns the next value of the payload, with its structs and arrays materialized like
+// binrpc.ReadRecord does: struct values are []binrpc.StructItem, and array values []binrpc.Record.
+// It returns io.EOF at the end of the payload.
+func (d *rpcDecoder) Record() (binrpc.Record, error) {
+	record, err := d.Next()
+
+	if err != nil {
+		return record, err
+	}
+
+	switch record.Type {
+	case typeEnd:
+		return record, errors.New("unexpected end of struct or array")
+	case binrpc.TypeStruct:
+		items := []binrpc.StructItem{}
+
+		for {
+			key, err := d.Next()
+
+			if err != nil {
+				return record, unexpectedEOF(err)
+			}
+
+			if key.Type == typeEnd {
+				break
+			}
+
+			if key.Type != binrpc.TypeAVP {
+				return record, fmt.Errorf("struct contains something else than avp: %d", key.Type)
+			}
+
+			value, err := d.Record()
+
+			if err != nil {
+				return record, unexpectedEOF(err)
+			}
+
+			items = append(items, binrpc.StructItem{Key: key.Value.(string), Value: value})
+		}
+
+		record.Value = items
+	case binrpc.TypeArray:
+		items := []binrpc.Record{}
+
+		for {
+			if next, err := d.peek(); err != nil {
+				return record, unexpectedEOF(err)
+			} else if next.Type == typeEnd {
+				d.peeked = nil
+				break
+			}
+
+			value, err := d.Record()
+
+			if err != nil {
+				return record, unexpectedEOF(err)
+			}
+
+			items = append(items, value)
+		}
+
+		record.Value = items
+	}
+
+	return record, nil
+}
+
+// peek returns the next record of the payload, without consuming it.
+func (d *rpcDecoder) peek() (binrpc.Record, error) {
+	record, err := d.Next()
+
+	if err == nil {
+		d.peeked = &record
+	}
+
+	return record, err
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF instead of io.EOF, since the payload ended inside a struct or array.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// Next returns the next record of the payload, or io.EOF at the end of the payload.
+func (d *rpcDecoder) Next() (binrpc.Record, error) {
+	if d.peeked != nil {
+		record := *d.peeked
+		d.peeked = nil
+
+		return record, nil
+	}
+
+	if _, err := io.ReadFull(d.r, d.buf[:1]); err != nil {
+		return binrpc.Record{}, err
+	}
+
+	flag := d.buf[0] >> 7
+	size := int(d.buf[0] >> 4 & 0x7)
+	record := binrpc.Record{Type: d.buf[0] & 0x0F}
+
+	if record.Type == binrpc.TypeStruct || record.Type == binrpc.TypeArray {
+		if flag == 1 {
+			record.Type = typeEnd
+		}
+
+		return record, nil
+	}
+
+	if flag == 1 {
+		sizeBytes := d.buf[:size]
+
+		if _, err := io.ReadFull(d.r, sizeBytes); err != nil {
+			return record, fmt.Errorf("cannot read record size: %w", err)
+		}
+
+		size = 0
+		for _, b := range sizeBytes {
+			size = size<<8 + int(b)
+		}
+	}
+
+	if size > cap(d.buf) {
+		d.buf = make([]byte, size)
+	}
+
+	value := d.buf[:size]
+
+	if _, err := io.ReadFull(d.r, value); err != nil {
+		return record, fmt.Errorf("cannot read record value: %w", err)
+	}
+
+	switch record.Type {
+	case binrpc.TypeAVP, binrpc.TypeString:
+		record.Value = ""
+
+		if size > 0 && d.intern {
+			// skip the null byte
+			record.Value = labelValues.Bytes(value[:size-1])
+		} else if size > 0 {
+			record.Value = string(value[:size-1])
+		}
+	case binrpc.TypeInt, binrpc.TypeDouble:
+		n := 0
+
+		for _, b := range value {
+			n = n<<8 + int(b)
+		}
+
+		record.Value = n
+
+		if record.Type == binrpc.TypeDouble {
+			// double are implemented as int*1000
+			record.Value = float64(n) / 1000.0
+		}
+	default:
+		return record, fmt.Errorf("type error: type %d not implemented", record.Type)
+	}
+
+	return record, nil
+}
+
+// Skip skips the value following a key: a single record, or a whole struct or array.
+func (d *rpcDecoder) Skip() error {
+	depth := 0
+
+	for {
+		record, err := d.Next()
+
+		if err != nil {
+			return err
+		}
+
+		switch record.Type {
+		case binrpc.TypeStruct, binrpc.TypeArray:
+			depth++
+		case typeEnd:
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// streamStructs decodes a response made of a list of structs, such as "dlg.list" or "dmq.list_nodes",
+// and calls fn with the single values of each struct, by key. Nested structs and arrays are skipped.
+// fields is reused between calls.
+func streamStructs(d *rpcDecoder, method string, fn func(fields map[string]binrpc.Record) error) error {
+	fields := make(map[string]binrpc.Record)
+
+	for {
+		record, err := d.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if record.Type != binrpc.TypeStruct {
+			return fmt.Errorf("unexpected record of type %d while parsing %s", record.Type, method)
+		}
+
+		for key := range fields {
+			delete(fields, key)
+		}
+
+		for {
+			record, err := d.Next()
+
+			if err == io.EOF {
+				return io.ErrUnexpectedEOF
+			} else if err != nil {
+				return err
+			}
+
+			if record.Type == typeEnd {
+				break
+			}
+
+			if record.Type != binrpc.TypeAVP {
+				return fmt.Errorf("unexpected record of type %d while parsing %s", record.Type, method)
+			}
+
+			key := record.Value.(string)
+			value, err := d.Next()
+
+			if err != nil {
+				return err
+			}
+
+			if value.Type == binrpc.TypeStruct || value.Type == binrpc.TypeArray {
+				d.peeked = &value
+
+				if err := d.Skip(); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			fields[key] = value
+		}
+
+		if err := fn(fields); err != nil {
+			return err
+		}
+	}
+}
+
+// walkRecords decodes a whole response, and calls fn for each single value, with the keys of its
+// enclosing structs and arrays below the top-level ones (path) and its own key. The end of each struct
+// or array is passed too, as a record of type typeEnd, with its key. Items of arrays and top-level
+// values have no key: the end of a top-level struct has an empty path and key.
+// It suits responses nested too deeply for streamStructs, when only a few keys matter.
+func walkRecords(d *rpcDecoder, method string, fn func(path []string, key string, record binrpc.Record) error) error {
+	var (
+		path []string
+		keys []string // of the enclosing structs and arrays, including top-level ones without key
+		key  string
+	)
+
+	for {
+		record, err := d.Next()
+
+		if err == io.EOF {
+			if len(keys) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		switch record.Type {
+		case binrpc.TypeAVP:
+			key = record.Value.(string)
+			continue
+		case binrpc.TypeStruct, binrpc.TypeArray:
+			keys = append(keys, key)
+
+			if len(keys) > 1 {
+				path = append(path, key)
+			}
+		case typeEnd:
+			if len(keys) == 0 {
+				return fmt.Errorf("unexpected end of struct while parsing %s", method)
+			}
+
+			if len(keys) > 1 {
+				path = path[:len(path)-1]
+			}
+
+			end := keys[len(keys)-1]
+			keys = keys[:len(keys)-1]
+
+			if err := fn(path, end, record); err != nil {
+				return err
+			}
+		default:
+			if err := fn(path, key, record); err != nil {
+				return err
+			}
+		}
+
+		key = ""
+	}
+}
+
+// intField returns the int value of key in fields, or 0 if it is missing or not an int.
+func intField(fields map[string]binrpc.Record, key string) int {
+	if record, found := fields[key]; found {
+		if i, err := record.Int(); err == nil {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// stringField returns the string value of key in fields, or an empty string if it is missing or not a string.
+func stringField(fields map[string]binrpc.Record, key string) string {
+	if record, found := fields[key]; found {
+		if s, err := record.String(); err == nil {
+			return s
+		}
+	}
+
+	return ""
+}
+
+// streamDispatcherTargets decodes a "dispatcher.list" response and calls fn for each target.
+//
+// The response looks like (only used keys are shown):
+//
+//	{
+//		RECORDS: {
+//			SET: {
+//				ID: 1
+//				TARGETS: {
+//					DEST: {
+//						URI: sip:10.0.0.1:5060
+//						FLAGS: AP
+//						PRIORITY: 0
+//						ATTRS: {
+//							BODY: weight=50;duid=a
+//							WEIGHT: 50
+//						}
+//						LATENCY: {
+//							AVG: 20.5
+//							STD: 1.2
+//							EST: 19.8
+//							MAX: 31
+//							TIMEOUT: 0
+//						}
+//					}
+//				}
+//			}
+//		}
+//	}
+func streamDispatcherTargets(d *rpcDecoder, fn func(target DispatcherTarget) error) error {
+	var (
+		path   []string // keys of the enclosing structs
+		key    string   // key of the next value
+		setID  int
+		target DispatcherTarget
+	)
+
+	for {
+		record, err := d.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		parent := ""
+		if len(path) > 0 {
+			parent = path[len(path)-1]
+		}
+
+		switch record.Type {
+		case binrpc.TypeAVP:
+			key = record.Value.(string)
+
+			// skip the content of unknown top-level keys, such as NRSETS
+			if parent == "" && len(path) == 1 && key != "RECORDS" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+			}
+
+			continue
+		case binrpc.TypeStruct, binrpc.TypeArray:
+			switch key {
+			case "SET":
+				setID = 0
+			case "DEST":
+				target = DispatcherTarget{}
+			case "LATENCY":
+				if parent == "DEST" {
+					target.Latency = &DispatcherLatency{}
+				}
+			}
+
+			path = append(path, key)
+		case typeEnd:
+			if len(path) == 0 {
+				return errors.New("unexpected end of struct while parsing dispatcher.list")
+			}
+
+			if parent == "DEST" {
+				if setID == 0 {
+					return errors.New("missing set ID while parsing dispatcher.list")
+				}
+
+				target.SetID = setID
+
+				if err := fn(target); err != nil {
+					return err
+				}
+			}
+
+			path = path[:len(path)-1]
+		default:
+			switch {
+			case parent == "SET" && key == "ID":
+				if setID, err = record.Int(); err != nil {
+					return err
+				}
+			case parent == "DEST" && key == "URI":
+				target.URI, _ = record.String()
+			case parent == "DEST" && key == "FLAGS":
+				target.Flags, _ = record.String()
+			case parent == "DEST" && key == "PRIORITY":
+				target.Priority, _ = record.Int()
+			case parent == "LATENCY" && target.Latency != nil:
+				// in milliseconds, as doubles or ints
+				value, err := statValue(record)
+
+				if err != nil {
+					return fmt.Errorf(`invalid latency "%s" of dispatcher target "%s": %w`, key, target.URI, err)
+				}
+
+				switch key {
+				case "AVG":
+					target.Latency.Average = value
+				case "STD":
+					target.Latency.Stdev = value
+				case "EST":
+					target.Latency.Estimate = value
+				case "MAX":
+					target.Latency.Max = value
+				case "TIMEOUT":
+					target.Latency.Timeouts = int(value)
+				}
+			case parent == "ATTRS" && key == "WEIGHT":
+				target.Weight, _ = record.Int()
+			case parent == "ATTRS" && key == "BODY", parent == "DEST" && key == "ATTRS":
+				// ATTRS is a plain string in older versions, without WEIGHT
+				target.Attrs, _ = record.String()
+
+				if target.Weight == 0 {
+					target.Weight = dispatcherWeight(target.Attrs)
+				}
+			}
+		}
+
+		key = ""
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+// stringInfo is the name of the info metrics exporting the string values of the responses,
+// if Collector.StringValues is set. It is available for every method.
+const stringInfo = "string_info"
+
+// stringInfoMetric returns the definition of the string_info metric of method.
+func stringInfoMetric(method string) Metric {
+	return NewMetricGauge(stringInfo, "String values of the response, always 1, with the key and value as labels.", method)
+}
+
+// stringValue returns the string_info value of key, with labels identifying the struct it belongs to, if any.
+func stringValue(key string, value string, labels map[string]string) MetricValue {
+	all := map[string]string{
+		"key":   labelValues.String(key),
+		"value": labelValues.String(value),
+	}
+
+	for name, value := range labels {
+		all[name] = value
+	}
+
+	return MetricValue{Value: 1, Labels: all}
+}
+
+// stringItems returns the string_info values of the string items of a struct.
+func stringItems(items []binrpc.StructItem) []MetricValue {
+	var values []MetricValue
+
+	for _, item := range items {
+		if item.Value.Type != binrpc.TypeString {
+			continue
+		}
+
+		s, _ := item.Value.String()
+		values = append(values, stringValue(item.Key, s, nil))
+	}
+
+	return values
+}
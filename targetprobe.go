@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeSchemes are the schemes of the targets of /probe. exec:// is excluded, since the target
+// comes from the request: it would let anyone reaching the exporter run commands. fifo: is excluded
+// too, since it creates the reply FIFOs in a directory given by the target.
+var probeSchemes = []string{"tcp", "udp", "unix", "unixgram", "http", "https"}
+
+// ProbeTargetConfig is a kamailio instance scraped with /probe?target=<name>, with its own credentials
+// and TLS settings, e.g. for instances owned by different tenants.
+type ProbeTargetConfig struct {
+	Name      string            `yaml:"name"`
+	URI       string            `yaml:"uri"`
+	BasicAuth *BasicAuthConfig  `yaml:"basic_auth"` // of http(s):// URIs, instead of the user information of URI
+	TLSConfig *TLSConfig        `yaml:"tls_config"` // of https:// URIs
+	Methods   []string          `yaml:"methods"`    // instead of the methods of the configuration, e.g. by role
+	Labels    map[string]string `yaml:"labels"`     // added to the labels of the configuration, e.g. datacenter or role
+
+	client *http.Client // with TLSConfig, kept until the configuration is reloaded
+	api    bool         // added with /api/v1/targets
+}
+
+// BasicAuthConfig is the user and password sent to the jsonrpcs module.
+type BasicAuthConfig struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"` // read on each probe
+}
+
+// TLSConfig is the configuration of the TLS connections to the jsonrpcs module.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`   // certificate authorities instead of the ones of the system
+	CertFile           string `yaml:"cert_file"` // client certificate
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// validateProbeTargets checks targets, and builds the HTTP clients of their TLS settings. Clients that
+// are already built are kept, so that validating the configuration again for a probe does not drop
+// the connections of the previous probes.
+func validateProbeTargets(targets []ProbeTargetConfig) error {
+	names := make(map[string]bool, len(targets))
+
+	for i := range targets {
+		t := &targets[i]
+
+		if t.Name == "" || t.URI == "" {
+			return errors.New("targets: name and uri are required")
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf(`targets: duplicate target "%s"`, t.Name)
+		}
+
+		names[t.Name] = true
+
+		u, err := url.Parse(t.URI)
+
+		if err != nil {
+			return fmt.Errorf(`targets: %s: invalid uri: %w`, t.Name, err)
+		}
+
+		if t.BasicAuth != nil {
+			if u.Scheme != "http" && u.Scheme != "https" {
+				return fmt.Errorf(`targets: %s: basic_auth requires an http:// or https:// uri`, t.Name)
+			}
+
+			if t.BasicAuth.Password != "" && t.BasicAuth.PasswordFile != "" {
+				return fmt.Errorf("targets: %s: password and password_file are mutually exclusive", t.Name)
+			}
+		}
+
+		if err := validateLabels(t.Labels); err != nil {
+			return fmt.Errorf("targets: %s: %w", t.Name, err)
+		}
+
+		for _, method := range t.Methods {
+			found := false
+
+			for _, m := range availableMethods {
+				if m == method {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return fmt.Errorf(`targets: %s: invalid method "%s"`, t.Name, method)
+			}
+		}
+
+		if t.TLSConfig == nil || t.client != nil {
+			continue
+		}
+
+		if u.Scheme != "https" {
+			return fmt.Errorf(`targets: %s: tls_config requires an https:// uri`, t.Name)
+		}
+
+		config, err := t.TLSConfig.build()
+
+		if err != nil {
+			return fmt.Errorf("targets: %s: %w", t.Name, err)
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+
+		t.client = &http.Client{Transport: transport}
+	}
+
+	return nil
+}
+
+// build returns the TLS configuration of c.
+func (c *TLSConfig) build() (*tls.Config, error) {
+	config := &tls.Config{ServerName: c.ServerName, InsecureSkipVerify: c.InsecureSkipVerify}
+
+	if c.CAFile != "" {
+		b, err := os.ReadFile(c.CAFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+
+		if !config.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CAFile)
+		}
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return nil, errors.New("cert_file and key_file must be set together")
+	}
+
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// scrapeURI returns the URI of t, with the credentials of its basic_auth.
+func (t *ProbeTargetConfig) scrapeURI() (string, error) {
+	if t.BasicAuth == nil {
+		return t.URI, nil
+	}
+
+	password := t.BasicAuth.Password
+
+	if t.BasicAuth.PasswordFile != "" {
+		b, err := os.ReadFile(t.BasicAuth.PasswordFile)
+
+		if err != nil {
+			return "", err
+		}
+
+		password = strings.TrimSpace(string(b))
+	}
+
+	u, err := url.Parse(t.URI)
+
+	if err != nil {
+		return "", err
+	}
+
+	u.User = url.UserPassword(t.BasicAuth.Username, password)
+
+	return u.String(), nil
+}
+
+// findProbeTarget returns the target of targets named name, or nil.
+func findProbeTarget(targets []ProbeTargetConfig, name string) *ProbeTargetConfig {
+	for i := range targets {
+		if targets[i].Name == name {
+			return &targets[i]
+		}
+	}
+
+	return nil
+}
+
+// probeHandler returns a handler scraping the kamailio instance given by the target parameter,
+// like the blackbox exporter, so that one exporter covers many instances. The target is the name
+// of a target of the configuration, or a URI. The methods and timeout parameters override the
+// configuration, and the methods of the target. Without timeout parameter, the timeout of
+// Prometheus applies if shorter, like on /metrics.
+//
+// A Collector is created for each request, from the current configuration, so that reloads
+// apply: values depending on the previous scrape, such as the completed dialogs of dlg.list,
+// are not exported.
+func probeHandler(loader *ConfigLoader, offset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		target := query.Get("target")
+
+		if target == "" {
+			http.Error(w, `missing "target" parameter`, http.StatusBadRequest)
+			return
+		}
+
+		config := *loader.Config()
+
+		// the schemes of the configured targets are not restricted, since they come from the file
+		t := findProbeTarget(config.Targets, target)
+		config.ScrapeURI = target
+
+		if t != nil {
+			uri, err := t.scrapeURI()
+
+			if err != nil {
+				log.Printf("[error] target %s: %s", t.Name, err)
+				http.Error(w, fmt.Sprintf("target %s: %s", t.Name, err), http.StatusInternalServerError)
+				return
+			}
+
+			config.ScrapeURI = uri
+
+			if len(t.Methods) > 0 {
+				config.Methods = t.Methods
+			}
+
+			if len(t.Labels) > 0 {
+				labels := make(map[string]string, len(config.Labels)+len(t.Labels))
+
+				for name, value := range config.Labels {
+					labels[name] = value
+				}
+
+				for name, value := range t.Labels {
+					labels[name] = value
+				}
+
+				config.Labels = labels
+			}
+		} else if err := validateProbeTarget(target); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// background collection, grace periods and persistent connections need a long-lived Collector
+		persistent := false
+
+		config.CollectInterval = nil
+		config.MethodIntervals = nil
+		config.GracePeriod = nil
+		config.PersistentConnection = &persistent
+
+		if methods := query.Get("methods"); methods != "" {
+			config.Methods = strings.Split(methods, ",")
+		}
+
+		if timeout := query.Get("timeout"); timeout != "" {
+			d, err := time.ParseDuration(timeout)
+
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf(`invalid "timeout" parameter "%s"`, timeout), http.StatusBadRequest)
+				return
+			}
+
+			config.Timeout = &d
+		}
+
+		c, err := config.NewCollector()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if t != nil {
+			c.HTTPClient = t.client
+		}
+
+		limit := time.Duration(0)
+		if query.Get("timeout") == "" {
+			limit = scrapeTimeout(r, offset)
+		}
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(timeoutCollector{c, limit})
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// targetMetricsHandler returns a handler of <prefix><name>, scraping the target name of the
+// configuration like /probe?target=<name>, so that Prometheus jobs with their own intervals
+// scrape each target. Unlike /probe, URIs are not accepted.
+func targetMetricsHandler(loader *ConfigLoader, prefix string, offset time.Duration) http.Handler {
+	probe := probeHandler(loader, offset)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, prefix)
+
+		if findProbeTarget(loader.Config().Targets, name) == nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		query.Set("target", name)
+
+		r = r.Clone(r.Context())
+		r.URL.RawQuery = query.Encode()
+
+		probe.ServeHTTP(w, r)
+	})
+}
+
+// validateProbeTarget checks that the URIs of target, separated by commas, have a scheme of probeSchemes.
+func validateProbeTarget(target string) error {
+	for _, uri := range strings.Split(target, ",") {
+		u, err := url.Parse(uri)
+
+		if err != nil {
+			return fmt.Errorf(`invalid target "%s": %w`, uri, err)
+		}
+
+		found := false
+
+		for _, scheme := range probeSchemes {
+			if u.Scheme == scheme {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf(`invalid target "%s": scheme must be one of %s`, uri, strings.Join(probeSchemes, ", "))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeTargetLabels(t *testing.T) {
+	k := newFakeKamailio(t, map[string]string{"core.uptime": `{"now": 1792266000, "up_since": 1792262400, "uptime": 3600}`})
+	timeout := 5 * time.Second
+
+	loader := NewConfigLoader("", Config{
+		ScrapeURI: "unix:/var/run/kamailio/kamailio_ctl",
+		Methods:   []string{"core.uptime"},
+		Timeout:   &timeout,
+		Labels:    map[string]string{"env": "prod", "datacenter": "global"},
+		Targets: []ProbeTargetConfig{
+			{Name: "edge-par", URI: k.URI, Labels: map[string]string{"datacenter": "par", "role": "edge"}},
+		},
+	})
+
+	if _, err := loader.Collector(); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	probeHandler(loader, 0).ServeHTTP(w, httptest.NewRequest("GET", "/probe?target=edge-par", nil))
+	body, _ := io.ReadAll(w.Body)
+
+	want := `kamailio_core_uptime_uptime_total{datacenter="par",env="prod",role="edge"} 3600`
+
+	if !strings.Contains(string(body), want) {
+		t.Errorf("missing %s in:\n%s", want, body)
+	}
+
+	// the labels of the target do not leak into the configuration
+	if labels := loader.Config().Labels; len(labels) != 2 || labels["datacenter"] != "global" {
+		t.Errorf("labels of the configuration changed: %v", labels)
+	}
+}
+
+func TestProbeTargetInvalidLabels(t *testing.T) {
+	for _, name := range []string{"0dc", "data-center", "table"} {
+		targets := []ProbeTargetConfig{{Name: "edge", URI: "tcp://10.0.0.1:2049", Labels: map[string]string{name: "par"}}}
+
+		if err := validateProbeTargets(targets); err == nil {
+			t.Errorf("label %s: no error", name)
+		}
+	}
+}
+
+func TestTargetMetrics(t *testing.T) {
+	k := newFakeKamailio(t, map[string]string{"core.uptime": `{"now": 1792266000, "up_since": 1792262400, "uptime": 3600}`})
+	timeout := 5 * time.Second
+
+	loader := NewConfigLoader("", Config{
+		Methods: []string{"core.uptime"},
+		Timeout: &timeout,
+		Targets: []ProbeTargetConfig{{Name: "edge-par", URI: k.URI}},
+	})
+
+	if _, err := loader.Collector(); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := targetMetricsHandler(loader, "/metrics/", 0)
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/metrics/edge-par", 200},
+		{"/metrics/edge-fra", 404},
+		{"/metrics/" + k.URI, 404},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d", test.path, w.Code, test.code)
+		}
+
+		if test.code == 200 && !strings.Contains(w.Body.String(), "kamailio_core_uptime_uptime_total 3600") {
+			t.Errorf("%s: missing the uptime in:\n%s", test.path, w.Body)
+		}
+	}
+}
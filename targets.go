@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// targetHealth is the outcome of the last scrape of kamailio, for /api/v1/targets.
+// It has its own mutex, so that the endpoint does not wait for a scrape in progress.
+type targetHealth struct {
+	mutex sync.Mutex
+
+	scrapeURIs []string
+	activeURI  string // the URI that answered the last connection attempt, if any
+	labels     map[string]string
+	lastScrape time.Time
+	duration   time.Duration
+	err        error
+	skipped    []string
+}
+
+// targetStatus is a target in the response of /api/v1/targets, like the targets API of Prometheus.
+type targetStatus struct {
+	ScrapeURIs         []string          `json:"scrapeUris"`
+	ActiveURI          string            `json:"activeUri"`
+	Labels             map[string]string `json:"labels"`
+	Health             string            `json:"health"` // "up", "down" or "unknown" before the first scrape
+	LastScrape         *time.Time        `json:"lastScrape"`
+	LastScrapeDuration float64           `json:"lastScrapeDuration"`
+	LastError          string            `json:"lastError"`
+	LastErrorType      string            `json:"lastErrorType"`
+	SkippedMethods     []string          `json:"skippedMethods"`
+}
+
+// updateHealth records the outcome of a scrape started at start. c.mutex must be held.
+func (c *Collector) updateHealth(start time.Time, skipped []string, err error) {
+	labels := make(map[string]string, len(c.Labels)+len(c.targetLabels))
+
+	for name, value := range c.Labels {
+		labels[name] = value
+	}
+
+	for name, value := range c.targetLabels {
+		labels[name] = value
+	}
+
+	uris := make([]string, 0, len(c.urls))
+
+	for _, u := range c.urls {
+		// without the passwords of http: URIs
+		uris = append(uris, u.Redacted())
+	}
+
+	c.health.mutex.Lock()
+	defer c.health.mutex.Unlock()
+
+	c.health.scrapeURIs = uris
+	c.health.labels = labels
+	c.health.lastScrape = start
+	c.health.duration = time.Since(start)
+	c.health.err = err
+	c.health.skipped = skipped
+}
+
+// setActiveURI records the URI that answered the last connection attempt, "" if none did.
+func (c *Collector) setActiveURI(uri string) {
+	c.health.mutex.Lock()
+	defer c.health.mutex.Unlock()
+
+	c.health.activeURI = uri
+}
+
+// TargetStatus returns the outcome of the last scrape.
+func (c *Collector) TargetStatus() targetStatus {
+	c.health.mutex.Lock()
+	defer c.health.mutex.Unlock()
+
+	status := targetStatus{
+		ScrapeURIs:     c.health.scrapeURIs,
+		ActiveURI:      c.health.activeURI,
+		Labels:         c.health.labels,
+		Health:         "unknown",
+		SkippedMethods: c.health.skipped,
+	}
+
+	if status.ScrapeURIs == nil {
+		status.ScrapeURIs = []string{}
+	}
+
+	if status.Labels == nil {
+		status.Labels = map[string]string{}
+	}
+
+	if status.SkippedMethods == nil {
+		status.SkippedMethods = []string{}
+	}
+
+	if c.health.lastScrape.IsZero() {
+		return status
+	}
+
+	lastScrape := c.health.lastScrape
+	status.LastScrape = &lastScrape
+	status.LastScrapeDuration = c.health.duration.Seconds()
+	status.Health = "up"
+
+	if c.health.err != nil {
+		status.Health = "down"
+		status.LastError = c.health.err.Error()
+		status.LastErrorType = scrapeErrorType(c.health.err)
+	}
+
+	return status
+}
+
+// configuredTarget is a target of /probe in the response of /api/v1/targets, without the credentials.
+type configuredTarget struct {
+	Name    string            `json:"name"`
+	URI     string            `json:"uri"`
+	Methods []string          `json:"methods"`
+	Labels  map[string]string `json:"labels"`
+	Source  string            `json:"source"` // "file" or "api"
+}
+
+// targetsHandler returns a handler listing the targets of the exporter and the outcome of their last
+// scrape as JSON, like the targets API of Prometheus, for inventory tools, along with the targets of
+// /probe:
+//
+//	{"status": "success", "data": {"activeTargets": [{"scrapeUris": ["unix:/var/run/kamailio/kamailio_ctl"], "health": "up", ...}], "probeTargets": [...]}}
+//
+// With --web.enable-lifecycle and an admin token, POST adds a target of /probe (YAML or JSON, with the keys of the targets of the
+// configuration file), or replaces the one of the same name, and DELETE with a name parameter removes
+// it, so that provisioning systems register nodes without waiting for the configuration file. Changes
+// are logged for auditing.
+//
+//	curl -X POST -H "Authorization: Bearer $TOKEN" --data-binary '{"name": "edge-3", "uri": "tcp://10.0.0.3:2049"}' http://localhost:9494/api/v1/targets
+func targetsHandler(c *Collector, loader *ConfigLoader, token string) http.Handler {
+	update := requireToken(token, targetsUpdateHandler(loader))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost, http.MethodDelete:
+			if token == "" {
+				writeJSONError(w, http.StatusForbidden, errors.New("changing the targets requires --web.enable-lifecycle and --web.admin-token-file"))
+				return
+			}
+
+			update.ServeHTTP(w, r)
+			return
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST, DELETE")
+			writeJSONError(w, http.StatusMethodNotAllowed, fmt.Errorf("only GET, POST or DELETE requests allowed"))
+			return
+		}
+
+		writeTargets(w, c, loader)
+	})
+}
+
+// targetsUpdateHandler returns a handler adding (POST) or removing (DELETE) a target of /probe.
+func targetsUpdateHandler(loader *ConfigLoader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			name := r.URL.Query().Get("name")
+
+			if err := loader.RemoveTarget(name); err != nil {
+				log.Printf("[audit] removal of target %s from %s rejected: %s", name, r.RemoteAddr, err)
+
+				code := http.StatusBadRequest
+				if errors.Is(err, errUnknownTarget) {
+					code = http.StatusNotFound
+				}
+
+				writeJSONError(w, code, err)
+				return
+			}
+
+			log.Printf("[audit] target %s removed from %s", name, r.RemoteAddr)
+			writeTargets(w, nil, loader)
+			return
+		}
+
+		b, err := io.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("cannot read target: %w", err))
+			return
+		}
+
+		var target ProbeTargetConfig
+
+		// JSON is valid YAML
+		if err := yaml.UnmarshalStrict(b, &target); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid target: %w", err))
+			return
+		}
+
+		if err := loader.AddTarget(target); err != nil {
+			log.Printf("[audit] target %s from %s rejected: %s", target.Name, r.RemoteAddr, err)
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		log.Printf("[audit] target %s (%s) added from %s", target.Name, redactURIs(target.URI), r.RemoteAddr)
+		writeTargets(w, nil, loader)
+	})
+}
+
+// writeTargets writes the targets of the exporter, without the scrape of c if nil.
+func writeTargets(w http.ResponseWriter, c *Collector, loader *ConfigLoader) {
+	data := map[string]any{"probeTargets": loader.Targets()}
+
+	if c != nil {
+		data["activeTargets"] = []targetStatus{c.TargetStatus()}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"status": "success", "data": data})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTargetsAPI(t *testing.T) {
+	k := newFakeKamailio(t, map[string]string{"core.uptime": `{"now": 1792266000, "up_since": 1792262400, "uptime": 3600}`})
+	timeout := 5 * time.Second
+
+	loader := NewConfigLoader("", Config{
+		ScrapeURI: "unix:/var/run/kamailio/kamailio_ctl",
+		Methods:   []string{"core.uptime"},
+		Timeout:   &timeout,
+		Targets:   []ProbeTargetConfig{{Name: "edge-par", URI: "tcp://10.0.0.1:2049"}},
+	})
+
+	c, err := loader.Collector()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := targetsHandler(c, loader, "secret")
+
+	request := func(method string, target string, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		code   int
+	}{
+		{"add", "POST", "/api/v1/targets", `{"name": "edge-fra", "uri": "` + k.URI + `", "labels": {"datacenter": "fra"}}`, 200},
+		{"replace", "POST", "/api/v1/targets", `{"name": "edge-fra", "uri": "` + k.URI + `", "labels": {"datacenter": "fra1"}}`, 200},
+		{"target of the file", "POST", "/api/v1/targets", `{"name": "edge-par", "uri": "` + k.URI + `"}`, 400},
+		{"exec", "POST", "/api/v1/targets", `{"name": "edge-exec", "uri": "exec:///usr/sbin/kamcmd"}`, 400},
+		{"password_file", "POST", "/api/v1/targets", `{"name": "edge-http", "uri": "http://10.0.0.2:5060/RPC", "basic_auth": {"username": "prometheus", "password_file": "/etc/shadow"}}`, 400},
+		{"invalid label", "POST", "/api/v1/targets", `{"name": "edge-lbl", "uri": "` + k.URI + `", "labels": {"data-center": "fra"}}`, 400},
+		{"unknown key", "POST", "/api/v1/targets", `{"name": "edge-key", "url": "` + k.URI + `"}`, 400},
+		{"remove a target of the file", "DELETE", "/api/v1/targets?name=edge-par", "", 404},
+	}
+
+	for _, test := range tests {
+		if w := request(test.method, test.target, test.body); w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.code, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	probeHandler(loader, 0).ServeHTTP(w, httptest.NewRequest("GET", "/probe?target=edge-fra", nil))
+
+	if want := `kamailio_core_uptime_uptime_total{datacenter="fra1"} 3600`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf("missing %s in:\n%s", want, w.Body)
+	}
+
+	var response struct {
+		Data struct {
+			ProbeTargets []configuredTarget `json:"probeTargets"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(request("GET", "/api/v1/targets", "").Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	if targets := response.Data.ProbeTargets; len(targets) != 2 || targets[0].Source != "file" || targets[1].Source != "api" {
+		t.Errorf("unexpected targets: %+v", targets)
+	}
+
+	// targets of the API are kept across reloads
+	if err := loader.reload(false); err != nil {
+		t.Fatal(err)
+	}
+
+	if findProbeTarget(loader.Config().Targets, "edge-fra") == nil {
+		t.Error("target edge-fra lost by the reload")
+	}
+
+	if w := request("DELETE", "/api/v1/targets?name=edge-fra", ""); w.Code != 200 {
+		t.Errorf("remove: got status %d: %s", w.Code, w.Body)
+	}
+
+	if findProbeTarget(loader.Config().Targets, "edge-fra") != nil {
+		t.Error("target edge-fra not removed")
+	}
+}
+
+func TestTargetsAPIWithoutToken(t *testing.T) {
+	timeout := 5 * time.Second
+	loader := NewConfigLoader("", Config{ScrapeURI: "unix:/var/run/kamailio/kamailio_ctl", Methods: []string{"core.uptime"}, Timeout: &timeout})
+	c, err := loader.Collector()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{"POST", "DELETE"} {
+		w := httptest.NewRecorder()
+		targetsHandler(c, loader, "").ServeHTTP(w, httptest.NewRequest(method, "/api/v1/targets?name=edge", strings.NewReader(`{"name": "edge", "uri": "tcp://10.0.0.1:2049"}`)))
+
+		if w.Code != 403 {
+			t.Errorf("%s: got status %d, want 403", method, w.Code)
+		}
+	}
+
+	if len(loader.Config().Targets) != 0 {
+		t.Errorf("targets changed: %+v", loader.Config().Targets)
+	}
+}
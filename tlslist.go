@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output (only used keys are shown)
+
+kamcmd> tls.list
+{
+	id: 12
+	src_ip: 192.0.2.10
+	src_port: 5061
+	cipher: ECDHE-RSA-AES256-GCM-SHA384 TLSv1.2 Kx=ECDH     Au=RSA  Enc=AESGCM(256) Mac=AEAD
+	state: established
+}
+{
+	id: 13
+	src_ip: 192.0.2.11
+	src_port: 5061
+	cipher: unknown
+	state: tls_accept
+}
+*/
+
+// tlsConnectionKey identifies the TLS connections counted together.
+type tlsConnectionKey struct {
+	state   string
+	version string
+	cipher  string
+}
+
+// scrapeTLSConnections passes the number of TLS connections to fn, by state, TLS version and cipher, so that
+// connections stuck in the handshake or using deprecated versions are noticed without a series per connection.
+func (c *Collector) scrapeTLSConnections(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	counts := make(map[tlsConnectionKey]int)
+
+	err := c.streamBINRPC(ctx, "tls.list", func(d *rpcDecoder) error {
+		return streamStructs(d, "tls.list", func(fields map[string]binrpc.Record) error {
+			version, cipher := tlsCipher(stringField(fields, "cipher"))
+			counts[tlsConnectionKey{stringField(fields, "state"), version, cipher}]++
+
+			return nil
+		})
+	})
+
+	// kamailio returns nothing when there is no TLS connection
+	if err != nil && !errors.Is(err, errEmptyResponse) {
+		return err
+	}
+
+	for key, count := range counts {
+		err := fn("connections", MetricValue{
+			Value:  float64(count),
+			Labels: map[string]string{"state": key.state, "version": key.version, "cipher": key.cipher},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tlsCipher returns the TLS version and the cipher of a connection, from the OpenSSL description of its
+// cipher, such as "ECDHE-RSA-AES256-GCM-SHA384 TLSv1.2 Kx=ECDH ...". Both are empty before the handshake,
+// when kamailio reports "unknown".
+func tlsCipher(description string) (version string, cipher string) {
+	fields := strings.Fields(description)
+
+	if len(fields) == 0 || description == "unknown" {
+		return "", ""
+	}
+
+	if len(fields) > 1 {
+		version = fields[1]
+	}
+
+	return version, fields[0]
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output (credentials are not exported)
+
+kamcmd> uac.reg_dump
+{
+	l_uuid: carrier-a
+	l_username: trunk
+	l_domain: pbx.example.com
+	r_username: 33100000000
+	r_domain: sip.carrier-a.net
+	realm: sip.carrier-a.net
+	auth_username: 33100000000
+	auth_password: ...
+	auth_proxy: sip:sip.carrier-a.net
+	expires: 360
+	flags: 20
+	diff_expires: 3
+	timer_expires: 1792267200
+	reg_init: 1792266840
+	reg_delay: 0
+}
+*/
+
+// flags of the remote registrations of the uac module
+const (
+	uacRegDisabled = 1 << 0
+	uacRegOnline   = 1 << 2
+)
+
+// scrapeUACRegistrations passes the state of the remote registrations of the uac module to fn, by
+// l_uuid and remote URI, so that a carrier dropping the registration of a trunk can be alerted on.
+func (c *Collector) scrapeUACRegistrations(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	now := time.Now().Unix()
+
+	err := c.streamBINRPC(ctx, "uac.reg_dump", func(d *rpcDecoder) error {
+		return streamStructs(d, "uac.reg_dump", func(fields map[string]binrpc.Record) error {
+			labels := map[string]string{
+				"l_uuid": stringField(fields, "l_uuid"),
+				"r_uri":  "sip:" + stringField(fields, "r_username") + "@" + stringField(fields, "r_domain"),
+			}
+
+			flags := intField(fields, "flags")
+			registered := flags&uacRegOnline != 0
+
+			// the registration is refreshed at timer_expires, before it expires
+			remaining := int64(0)
+			if registered {
+				if remaining = int64(intField(fields, "timer_expires")) - now; remaining < 0 {
+					remaining = 0
+				}
+			}
+
+			for name, value := range map[string]float64{
+				"registered":        boolValue(registered),
+				"disabled":          boolValue(flags&uacRegDisabled != 0),
+				"expires_seconds":   float64(intField(fields, "expires")),
+				"remaining_seconds": float64(remaining),
+			} {
+				if err := fn(name, MetricValue{Value: value, Labels: labels}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	})
+
+	// kamailio returns nothing when no registration is configured
+	if errors.Is(err, errEmptyResponse) {
+		return nil
+	}
+
+	return err
+}
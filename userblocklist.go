@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output
+
+kamcmd> userblocklist.dump_blocklist
+49900 blocklisted
+49901 blocklisted
+4990123 allowlisted
+*/
+
+// userBlocklistKinds are the kinds of entries of the global lists of the userblocklist module,
+// by the suffix used by kamailio (older versions use blacklisted and whitelisted).
+var userBlocklistKinds = map[string]string{
+	"blocklisted": "blocklist",
+	"blacklisted": "blocklist",
+	"allowlisted": "allowlist",
+	"whitelisted": "allowlist",
+}
+
+// scrapeUserBlocklist passes the number of entries of the global lists loaded by the userblocklist module,
+// by kind, to fn. Both kinds are always exported, so that a list failing to load shows as a count of 0.
+func (c *Collector) scrapeUserBlocklist(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	counts := map[string]int{"blocklist": 0, "allowlist": 0}
+
+	err := c.streamBINRPC(ctx, "userblocklist.dump_blocklist", func(d *rpcDecoder) error {
+		return walkRecords(d, "userblocklist.dump_blocklist", func(path []string, key string, record binrpc.Record) error {
+			s, err := record.String()
+
+			if err != nil {
+				return nil
+			}
+
+			// entries are "<prefix> <kind>" lines, or a prefix and its kind as separate values
+			fields := strings.Fields(s)
+
+			if len(fields) == 0 {
+				return nil
+			}
+
+			if kind, found := userBlocklistKinds[fields[len(fields)-1]]; found {
+				counts[kind]++
+			}
+
+			return nil
+		})
+	})
+
+	// kamailio returns nothing when the lists are empty
+	if err != nil && !errors.Is(err, errEmptyResponse) {
+		return err
+	}
+
+	for kind, count := range counts {
+		err := fn("entries", MetricValue{
+			Value:  float64(count),
+			Labels: map[string]string{"list": kind},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
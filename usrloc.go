@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output (only used keys are shown)
+
+kamcmd> ul.dump
+{
+	Domains: {
+		Domain: {
+			Domain: location
+			AoRs: {
+				Info: {
+					AoR: alice@example.com
+					Contacts: {
+						Contact: {
+							Address: sip:alice@192.0.2.10:5060
+							Expires: 3542
+						}
+						Contact: {
+							Address: sip:alice@192.0.2.11:5060
+							Expires: expired
+						}
+					}
+				}
+			}
+		}
+	}
+}
+*/
+
+// usrlocKey identifies the registrations counted together: the table of usrloc (the "domain" of
+// usrloc, e.g. "location") and the SIP domain of the AoRs.
+type usrlocKey struct {
+	table  string
+	domain string
+}
+
+// usrlocCounts are the registrations of a usrlocKey.
+type usrlocCounts struct {
+	aors     int
+	contacts int
+	expired  int
+}
+
+// scrapeUsrloc passes the number of registered AoRs, contacts and expired contacts to fn, by table and
+// SIP domain. Contacts are counted while the response of ul.dump is read, since it lists every contact.
+func (c *Collector) scrapeUsrloc(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	counts := make(map[usrlocKey]*usrlocCounts)
+
+	err := c.streamBINRPC(ctx, "ul.dump", func(d *rpcDecoder) error {
+		return streamUsrloc(d, counts)
+	})
+
+	if err != nil && !errors.Is(err, errEmptyResponse) {
+		return err
+	}
+
+	for key, count := range counts {
+		labels := map[string]string{"table": key.table, "domain": key.domain}
+
+		for name, value := range map[string]int{
+			"aors":             count.aors,
+			"contacts":         count.contacts,
+			"expired_contacts": count.expired,
+		} {
+			if err := fn(name, MetricValue{Value: float64(value), Labels: labels}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// streamUsrloc decodes a "ul.dump" response and adds its registrations to counts. Tables without
+// registrations are counted with an empty domain, so that they are exported.
+func streamUsrloc(d *rpcDecoder, counts map[usrlocKey]*usrlocCounts) error {
+	var (
+		path   []string // keys of the enclosing structs
+		key    string   // key of the next value
+		table  string
+		domain string // of the current AoR
+		aor    usrlocCounts
+		found  bool // AoRs in the current table
+	)
+
+	add := func(k usrlocKey, n usrlocCounts) {
+		if counts[k] == nil {
+			counts[k] = &usrlocCounts{}
+		}
+
+		counts[k].aors += n.aors
+		counts[k].contacts += n.contacts
+		counts[k].expired += n.expired
+	}
+
+	for {
+		record, err := d.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		parent := ""
+		if len(path) > 0 {
+			parent = path[len(path)-1]
+		}
+
+		switch record.Type {
+		case binrpc.TypeAVP:
+			key = record.Value.(string)
+			continue
+		case binrpc.TypeStruct, binrpc.TypeArray:
+			switch {
+			case key == "Domain" && parent == "Domains":
+				table, found = "", false
+			case key == "Info":
+				aor, domain = usrlocCounts{aors: 1}, ""
+			}
+
+			path = append(path, key)
+		case typeEnd:
+			if len(path) == 0 {
+				return errors.New("unexpected end of struct while parsing ul.dump")
+			}
+
+			switch {
+			case parent == "Info":
+				add(usrlocKey{table, domain}, aor)
+				found = true
+			case parent == "Domain" && !found:
+				add(usrlocKey{table, ""}, usrlocCounts{})
+			}
+
+			path = path[:len(path)-1]
+		default:
+			switch {
+			case parent == "Domain" && key == "Domain":
+				table, _ = record.String()
+			case parent == "Info" && key == "AoR":
+				s, _ := record.String()
+
+				if _, after, found := strings.Cut(s, "@"); found {
+					domain = after
+				}
+			case parent == "Contact" && key == "Expires":
+				// the remaining seconds, or "permanent", "expired" or "deleted"
+				s, _ := record.String()
+
+				switch s {
+				case "deleted":
+				case "expired":
+					aor.contacts++
+					aor.expired++
+				default:
+					aor.contacts++
+				}
+			}
+		}
+
+		key = ""
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchDirs watches the directories returned by dirs and calls reload when files change in them.
+// Events are debounced by delay, so that editors and Kubernetes config map updates
+// (which replace a symlink) trigger a single reload.
+//
+// Directories are watched rather than the files themselves, since files
+// replaced by a rename would otherwise silently stop being watched.
+// reload may be called for changes on unrelated files.
+//
+// dirs is called again after each reload, and whenever reloaded receives a value, so that
+// a new include_dir is watched, and the previous one is not anymore, whatever triggered the reload.
+func WatchDirs(dirs func() []string, reloaded <-chan struct{}, delay time.Duration, reload func()) error {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return err
+	}
+
+	watched := make(map[string]bool)
+
+	if err := watchDirs(watcher, watched, dirs()); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		timer := time.NewTimer(delay)
+		timer.Stop()
+
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				timer.Reset(delay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				log.Println("[error] file watcher:", err)
+			case <-timer.C:
+				reload()
+
+				if err := watchDirs(watcher, watched, dirs()); err != nil {
+					log.Println("[error] file watcher:", err)
+				}
+			case <-reloaded:
+				if err := watchDirs(watcher, watched, dirs()); err != nil {
+					log.Println("[error] file watcher:", err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// watchDirs makes watcher watch dirs, and stop watching the other directories of watched,
+// which is updated. Directories of dirs are added again, in case they were removed and
+// created again, since watches do not survive their directory.
+func watchDirs(watcher *fsnotify.Watcher, watched map[string]bool, dirs []string) error {
+	wanted := make(map[string]bool, len(dirs))
+
+	for _, dir := range dirs {
+		wanted[dir] = true
+	}
+
+	for dir := range watched {
+		if !wanted[dir] {
+			// fails if the directory was removed, and is not watched anymore anyway
+			watcher.Remove(dir)
+			delete(watched, dir)
+		}
+	}
+
+	var firstErr error
+
+	for _, dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		watched[dir] = true
+	}
+
+	return firstErr
+}
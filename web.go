@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// readTokenFile reads the admin token from path. An empty path means no token.
+func readTokenFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return "", fmt.Errorf("cannot read token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(b))
+
+	if token == "" {
+		return "", fmt.Errorf("token file %q is empty", path)
+	}
+
+	return token, nil
+}
+
+// externalPath returns the path of externalURL, the URL under which the exporter is reachable
+// (e.g. behind a reverse proxy), without trailing slash. An empty URL means the root.
+func externalPath(externalURL string) (string, error) {
+	if externalURL == "" {
+		return "", nil
+	}
+
+	u, err := url.Parse(externalURL)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid external URL: %w", err)
+	}
+
+	if u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("invalid external URL %q: scheme and host are required", externalURL)
+	}
+
+	return strings.TrimRight(u.Path, "/"), nil
+}
+
+// normalizeRoutePrefix returns prefix with a leading slash and without trailing slash, "" for the root.
+func normalizeRoutePrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return ""
+	}
+
+	return "/" + prefix
+}
+
+// rootRedirectHandler returns a handler redirecting "/" to the landing page under prefix.
+func rootRedirectHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.Redirect(w, r, prefix+"/", http.StatusFound)
+	})
+}
+
+// requireToken wraps h so that requests must carry "Authorization: Bearer <token>".
+// If token is empty, h is returned as is.
+func requireToken(token string, h http.Handler) http.Handler {
+	if token == "" {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// quitHandler returns a handler that closes quit on PUT or POST, mirroring the Prometheus lifecycle API.
+func quitHandler(quit chan<- struct{}) http.Handler {
+	var once sync.Once
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "Only PUT or POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		fmt.Fprintln(w, "Requesting termination... Goodbye!")
+
+		once.Do(func() { close(quit) })
+	})
+}
+
+// reloadHandler returns a handler that reloads the configuration on PUT or POST.
+func reloadHandler(loader *ConfigLoader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "Only PUT or POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if err := loader.Reload(); err != nil {
+			log.Println("[error] cannot reload configuration:", err)
+			http.Error(w, fmt.Sprintf("failed to reload config: %s", err), http.StatusInternalServerError)
+		}
+	})
+}
+
+// toolkitLogger logs the messages of exporter-toolkit with the log package, like the rest of the exporter.
+type toolkitLogger struct{}
+
+// Log implements the logger of exporter-toolkit, which passes its messages as key-value pairs.
+func (toolkitLogger) Log(keyvals ...any) error {
+	level := "info"
+	var fields []string
+
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		value := fmt.Sprint(keyvals[i+1])
+
+		switch key {
+		case "level":
+			level = value
+		case "msg":
+			fields = append([]string{value}, fields...)
+		default:
+			fields = append(fields, key+"="+value)
+		}
+	}
+
+	log.Printf("[%s] %s", level, strings.Join(fields, " "))
+
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Kinds of the state changes sent to webhooks.
+const (
+	webhookKamailioUp       = "kamailio_up"       // from "up" to "down", or back
+	webhookDispatcherTarget = "dispatcher_target" // state of a dispatcher.list target, "missing" when removed
+	webhookDMQNode          = "dmq_node"          // status of a dmq.list_nodes peer, "missing" when lost
+)
+
+// webhookNotifier posts the state changes of kamailio to webhooks, so that lightweight
+// automations can react without Prometheus and Alertmanager at small sites.
+type webhookNotifier struct {
+	URLs []string
+
+	client  *http.Client
+	up      string                             // "up" or "down", "" before the first scrape
+	states  map[string]webhookState            // committed, by key
+	pending map[string]map[string]webhookState // recorded during the scrape in progress, by method and key
+	seen    map[string]bool                    // methods committed at least once
+	events  []webhookEvent                     // to be sent at the end of the scrape
+}
+
+// webhookState is the state of a dispatcher target or DMQ node.
+type webhookState struct {
+	Kind   string
+	Method string
+	Labels map[string]string
+	State  string
+}
+
+// webhookEvent is the JSON payload posted to webhooks.
+type webhookEvent struct {
+	Kind      string            `json:"kind"`
+	Instance  string            `json:"instance"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// EnableWebhooks makes c post its state changes to urls.
+func (c *Collector) EnableWebhooks(urls []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.webhooks = &webhookNotifier{
+		URLs:    urls,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		states:  make(map[string]webhookState),
+		pending: make(map[string]map[string]webhookState),
+		seen:    make(map[string]bool),
+	}
+}
+
+// recordTransition records the state carried by the value of metricDef, if any. c.mutex must be held.
+func (c *Collector) recordTransition(metricDef Metric, value MetricValue) {
+	w := c.webhooks
+
+	// states are StateSets: the series set to 1 is the current state
+	if w == nil || value.Value != 1 {
+		return
+	}
+
+	var state webhookState
+
+	switch {
+	case metricDef.Method == "dispatcher.list" && metricDef.Name == "target_state":
+		state = webhookState{
+			Kind:   webhookDispatcherTarget,
+			Labels: map[string]string{"uri": value.Labels["uri"], "setid": value.Labels["setid"]},
+			State:  value.Labels[dispatcherStateMetricName],
+		}
+	case metricDef.Method == "dmq.list_nodes" && metricDef.Name == "node_state":
+		state = webhookState{
+			Kind:   webhookDMQNode,
+			Labels: map[string]string{"host": value.Labels["host"], "port": value.Labels["port"]},
+			State:  value.Labels[dmqNodeStateMetricName],
+		}
+	default:
+		return
+	}
+
+	state.Method = metricDef.Method
+	key := state.Kind
+
+	for _, name := range []string{"uri", "setid", "host", "port"} {
+		key += "\xff" + state.Labels[name]
+	}
+
+	if w.pending[state.Method] == nil {
+		w.pending[state.Method] = make(map[string]webhookState)
+	}
+
+	w.pending[state.Method][key] = state
+}
+
+// commitTransitions compares the states recorded for method with the previous ones, if ok, or drops them.
+// Targets and nodes added are not reported on the first call of method. c.mutex must be held.
+func (c *Collector) commitTransitions(method string, ok bool) {
+	w := c.webhooks
+
+	if w == nil {
+		return
+	}
+
+	pending := w.pending[method]
+	delete(w.pending, method)
+
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	instance := c.instance()
+
+	for key, state := range pending {
+		previous, found := w.states[key]
+
+		if w.seen[method] && (!found || previous.State != state.State) {
+			w.events = append(w.events, webhookEvent{
+				Kind:      state.Kind,
+				Instance:  instance,
+				Labels:    state.Labels,
+				From:      previous.State,
+				To:        state.State,
+				Timestamp: now,
+			})
+		}
+
+		w.states[key] = state
+	}
+
+	for key, previous := range w.states {
+		if _, found := pending[key]; found || previous.Method != method {
+			continue
+		}
+
+		w.events = append(w.events, webhookEvent{
+			Kind:      previous.Kind,
+			Instance:  instance,
+			Labels:    previous.Labels,
+			From:      previous.State,
+			To:        "missing",
+			Timestamp: now,
+		})
+
+		delete(w.states, key)
+	}
+
+	w.seen[method] = true
+}
+
+// notifyWebhooks records whether kamailio is up after a scrape, and sends the state changes
+// of the scrape. c.mutex must be held.
+func (c *Collector) notifyWebhooks(up bool) {
+	w := c.webhooks
+
+	if w == nil {
+		return
+	}
+
+	state := "down"
+	if up {
+		state = "up"
+	}
+
+	if w.up != "" && w.up != state {
+		w.events = append(w.events, webhookEvent{
+			Kind:      webhookKamailioUp,
+			Instance:  c.instance(),
+			From:      w.up,
+			To:        state,
+			Timestamp: time.Now(),
+		})
+	}
+
+	w.up = state
+
+	if len(w.events) == 0 {
+		return
+	}
+
+	go w.post(w.events)
+	w.events = nil
+}
+
+// post sends events to the webhooks, one request per event, in order.
+func (w *webhookNotifier) post(events []webhookEvent) {
+	for _, event := range events {
+		body, err := json.Marshal(event)
+
+		if err != nil {
+			log.Println("[error] cannot encode webhook event:", err)
+			continue
+		}
+
+		for _, url := range w.URLs {
+			w.send(url, body)
+		}
+	}
+}
+
+// send posts body to url.
+func (w *webhookNotifier) send(url string, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+
+	if err != nil {
+		log.Println("[error] cannot post webhook:", err)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+
+	if err != nil {
+		log.Println("[error] cannot post webhook:", err)
+		return
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		log.Printf("[error] cannot post webhook: %s replied %s", url, resp.Status)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	binrpc "github.com/florentchauveau/go-kamailio-binrpc/v3"
+)
+
+/* Sample output (only used keys are shown)
+
+kamcmd> ws.dump
+{
+	connection: {
+		id: 14
+		protocol: wss
+		state: OPEN
+		last_used: 12
+		sub_protocol: sip
+	}
+	connection: {
+		id: 15
+		protocol: ws
+		state: CLOSING
+		last_used: 480
+		sub_protocol: msrp
+	}
+	info: {
+		wscounter: 2
+		truncated: no
+	}
+}
+*/
+
+// webSocketKey identifies the WebSocket connections counted together.
+type webSocketKey struct {
+	protocol    string // ws or wss
+	state       string
+	subProtocol string
+}
+
+// scrapeWebSockets passes the number of WebSocket connections of the websocket module to fn, by protocol,
+// state and sub-protocol. kamailio lists a limited number of connections: the truncated metric is set to 1
+// when some were left out.
+func (c *Collector) scrapeWebSockets(ctx context.Context, fn func(name string, value MetricValue) error) error {
+	counts := make(map[webSocketKey]int)
+	truncated := false
+
+	err := c.streamBINRPC(ctx, "ws.dump", func(d *rpcDecoder) error {
+		return streamWebSockets(d, counts, &truncated)
+	})
+
+	if err != nil && !errors.Is(err, errEmptyResponse) {
+		return err
+	}
+
+	for key, count := range counts {
+		err := fn("connections", MetricValue{
+			Value:  float64(count),
+			Labels: map[string]string{"protocol": key.protocol, "state": key.state, "sub_protocol": key.subProtocol},
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn("truncated", MetricValue{Value: boolValue(truncated)})
+}
+
+// streamWebSockets decodes a "ws.dump" response, counts its connections in counts and sets truncated
+// if kamailio did not list all the connections.
+func streamWebSockets(d *rpcDecoder, counts map[webSocketKey]int, truncated *bool) error {
+	var (
+		path []string // keys of the enclosing structs
+		key  string   // key of the next value
+		conn webSocketKey
+	)
+
+	for {
+		record, err := d.Next()
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		parent := ""
+		if len(path) > 0 {
+			parent = path[len(path)-1]
+		}
+
+		switch record.Type {
+		case binrpc.TypeAVP:
+			key = record.Value.(string)
+			continue
+		case binrpc.TypeStruct, binrpc.TypeArray:
+			if key == "connection" {
+				conn = webSocketKey{}
+			}
+
+			path = append(path, key)
+		case typeEnd:
+			if len(path) == 0 {
+				return errors.New("unexpected end of struct while parsing ws.dump")
+			}
+
+			if parent == "connection" {
+				counts[conn]++
+			}
+
+			path = path[:len(path)-1]
+		default:
+			s, _ := record.String()
+
+			switch {
+			case parent == "connection" && key == "protocol":
+				conn.protocol = s
+			case parent == "connection" && key == "state":
+				conn.state = s
+			case parent == "connection" && key == "sub_protocol":
+				conn.subProtocol = s
+			case parent == "info" && key == "truncated":
+				*truncated = s == "yes"
+			}
+		}
+
+		key = ""
+	}
+}
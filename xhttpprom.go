@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// XHTTPPromCollector merges the metrics exposed by the xhttp_prom module of kamailio
+// into the exposition of the exporter, so that custom counters of the routing script
+// are scraped along with the RPC metrics.
+type XHTTPPromCollector struct {
+	URL          string
+	Timeout      time.Duration
+	SourcePrefix string // removed from the names of the metrics (xhttp_prom_pref of the module)
+	Prefix       string // added to the names of the metrics
+
+	client http.Client
+	up     prometheus.Gauge
+}
+
+// NewXHTTPPromCollector returns a new XHTTPPromCollector fetching url.
+func NewXHTTPPromCollector(url string, timeout time.Duration, sourcePrefix string, prefix string) *XHTTPPromCollector {
+	return &XHTTPPromCollector{
+		URL:          url,
+		Timeout:      timeout,
+		SourcePrefix: sourcePrefix,
+		Prefix:       prefix,
+
+		up: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "exporter_xhttp_prom_up",
+			Help:      "Was the last fetch of the xhttp_prom metrics successful.",
+		}),
+	}
+}
+
+// Describe implements prometheus.Collector.
+// Nothing is sent: the metrics of kamailio are not known in advance.
+func (x *XHTTPPromCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// Collect implements prometheus.Collector.
+func (x *XHTTPPromCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := x.collect(ch); err != nil {
+		log.Println("[error] xhttp_prom:", err)
+		x.up.Set(0)
+	} else {
+		x.up.Set(1)
+	}
+
+	ch <- x.up
+}
+
+// collect fetches and parses the metrics of xhttp_prom, and sends them to ch.
+func (x *XHTTPPromCollector) collect(ch chan<- prometheus.Metric) error {
+	ctx, cancel := context.WithTimeout(context.Background(), x.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, x.URL, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := x.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var parser expfmt.TextParser
+
+	families, err := parser.TextToMetricFamilies(resp.Body)
+
+	if err != nil {
+		return fmt.Errorf("cannot parse metrics: %w", err)
+	}
+
+	for _, family := range families {
+		name := x.Prefix + strings.TrimPrefix(family.GetName(), x.SourcePrefix)
+
+		for _, m := range family.Metric {
+			metric, err := convertMetric(name, family, m)
+
+			if err != nil {
+				return err
+			}
+
+			ch <- metric
+		}
+	}
+
+	return nil
+}
+
+// convertMetric returns m of family as a const metric named name.
+func convertMetric(name string, family *dto.MetricFamily, m *dto.Metric) (prometheus.Metric, error) {
+	var labelNames, labelValues []string
+
+	for _, label := range m.Label {
+		labelNames = append(labelNames, label.GetName())
+		labelValues = append(labelValues, label.GetValue())
+	}
+
+	help := family.GetHelp()
+	if help == "" {
+		help = "Metric of the xhttp_prom module of kamailio."
+	}
+
+	desc := prometheus.NewDesc(name, help, labelNames, nil)
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.Counter.GetValue(), labelValues...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.Gauge.GetValue(), labelValues...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := make(map[float64]uint64)
+
+		for _, b := range m.Histogram.Bucket {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+
+		return prometheus.NewConstHistogram(desc, m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum(), buckets, labelValues...)
+	case dto.MetricType_SUMMARY:
+		quantiles := make(map[float64]float64)
+
+		for _, q := range m.Summary.Quantile {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+
+		return prometheus.NewConstSummary(desc, m.Summary.GetSampleCount(), m.Summary.GetSampleSum(), quantiles, labelValues...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.Untyped.GetValue(), labelValues...)
+	}
+}